@@ -15,12 +15,33 @@ func main() {
 		_ = dbInstance.Close()
 	}()
 
+	//Redis無法連線時不會回傳錯誤，只有設定檔讀取失敗時才中止啟動
 	rdb, err := config.SetupRedisConnection()
 	if err != nil {
-		panic("無法連接到Redis")
+		panic("無法讀取Redis設定")
 	}
 	defer rdb.Close()
 
+	err = config.SetupPaymentProviders()
+	if err != nil {
+		panic("無法設定金流")
+	}
+
+	err = config.SetupReservation(db, rdb)
+	if err != nil {
+		panic("無法設定保留庫存")
+	}
+
+	err = config.SetupProductCache(db, rdb)
+	if err != nil {
+		panic("無法設定商品快取")
+	}
+
+	err = config.SetupJWT(db, rdb)
+	if err != nil {
+		panic("無法讀取JWT金鑰")
+	}
+
 	router := routers.SetupRouters(db, rdb)
 	router.Run(":3000")
 }
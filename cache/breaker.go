@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrRedisUnavailable = errors.New("Redis暫時無法使用")
+
+// Redis斷路器，連續連線失敗達門檻時開啟，開啟期間所有指令直接回傳ErrRedisUnavailable
+// 開啟後由RunRedisMonitor定期嘗試重新連線，成功後關閉並通知協調程序修復斷線期間未寫入的快取
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  int
+	open      bool
+	recovered chan struct{}
+}
+
+var redisBreaker = &breaker{
+	threshold: 3,
+	recovered: make(chan struct{}, 1),
+}
+
+// 監控程序的連線測試不受斷路器限制
+type probeKey struct{}
+
+// 設定連續失敗幾次後開啟斷路器，未設定時為3次
+func SetBreakerThreshold(threshold int) {
+	redisBreaker.mu.Lock()
+	defer redisBreaker.mu.Unlock()
+	redisBreaker.threshold = threshold
+}
+
+// Redis目前是否可用
+func RedisAvailable() bool {
+	redisBreaker.mu.Lock()
+	defer redisBreaker.mu.Unlock()
+	return !redisBreaker.open
+}
+
+// 立即開啟斷路器，用於啟動時無法連線
+func OpenBreaker(err error) {
+	redisBreaker.trip(err)
+}
+
+func (b *breaker) trip(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return
+	}
+	b.open = true
+	log.Printf("Redis連線失敗，暫停使用Redis並改由資料庫提供商品資料: %v\n", err)
+}
+
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if !b.open {
+		return
+	}
+	b.open = false
+	log.Println("Redis已恢復連線")
+	select {
+	case b.recovered <- struct{}{}:
+	default:
+	}
+}
+
+// 只有連線相關的錯誤才計入失敗，Redis回覆的錯誤(含redis.Nil)及請求取消不計入
+func isConnectionError(err error) bool {
+	if err == nil || errors.Is(err, ErrRedisUnavailable) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var redisErr redis.Error
+	return !errors.As(err, &redisErr)
+}
+
+func (b *breaker) record(err error) {
+	if !isConnectionError(err) {
+		b.mu.Lock()
+		if !b.open {
+			b.failures = 0
+		}
+		b.mu.Unlock()
+		return
+	}
+
+	b.mu.Lock()
+	b.failures++
+	reached := b.failures >= b.threshold
+	b.mu.Unlock()
+	if reached {
+		b.trip(err)
+	}
+}
+
+func (b *breaker) allow(ctx context.Context) bool {
+	if ctx.Value(probeKey{}) != nil {
+		return true
+	}
+	return RedisAvailable()
+}
+
+type breakerHook struct{}
+
+func (breakerHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (breakerHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !redisBreaker.allow(ctx) {
+			return ErrRedisUnavailable
+		}
+		err := next(ctx, cmd)
+		redisBreaker.record(err)
+		return err
+	}
+}
+
+func (breakerHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !redisBreaker.allow(ctx) {
+			for _, cmd := range cmds {
+				cmd.SetErr(ErrRedisUnavailable)
+			}
+			return ErrRedisUnavailable
+		}
+		err := next(ctx, cmds)
+		redisBreaker.record(err)
+		return err
+	}
+}
+
+// 為Redis連線加上斷路器
+func InstallBreaker(rdb *redis.Client) {
+	rdb.AddHook(breakerHook{})
+}
+
+// 斷路器開啟時定期嘗試重新連線，應以goroutine執行
+func RunRedisMonitor(rdb *redis.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	probe := context.WithValue(context.Background(), probeKey{}, true)
+	for range ticker.C {
+		if RedisAvailable() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(probe, interval)
+		err := rdb.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			redisBreaker.reset()
+		}
+	}
+}
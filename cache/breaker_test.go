@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"Backend/models"
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"time"
+)
+
+// 測試結束後關閉斷路器並還原門檻，避免影響其他測試
+func resetBreakerAfter(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		redisBreaker.mu.Lock()
+		redisBreaker.open = false
+		redisBreaker.failures = 0
+		redisBreaker.threshold = 3
+		redisBreaker.mu.Unlock()
+		select {
+		case <-redisBreaker.recovered:
+		default:
+		}
+	})
+}
+
+// 連續連線失敗達門檻時開啟斷路器，開啟後指令不會送出，Redis回覆的錯誤不計入失敗
+func TestBreakerOpensOnConnectionErrors(t *testing.T) {
+	resetBreakerAfter(t)
+	server, rdb := newTestRedis(t)
+	InstallBreaker(rdb)
+	ctx := context.Background()
+
+	//redis.Nil不是連線錯誤
+	for i := 0; i < 5; i++ {
+		if err := rdb.Get(ctx, "missing").Err(); err != redis.Nil {
+			t.Fatalf("Get(missing) error = %v, want redis.Nil", err)
+		}
+	}
+	if !RedisAvailable() {
+		t.Fatal("breaker should stay closed on redis.Nil")
+	}
+
+	server.Close()
+	for i := 0; i < 3; i++ {
+		if !RedisAvailable() {
+			t.Fatalf("breaker opened after %d failures, threshold is 3", i)
+		}
+		err := rdb.Get(ctx, "key").Err()
+		if err == nil || errors.Is(err, ErrRedisUnavailable) {
+			t.Fatalf("Get with Redis down error = %v, want connection error", err)
+		}
+	}
+	if RedisAvailable() {
+		t.Fatal("breaker should be open after 3 connection errors")
+	}
+
+	if err := rdb.Get(ctx, "key").Err(); !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("Get with breaker open error = %v, want %v", err, ErrRedisUnavailable)
+	}
+	pipe := rdb.Pipeline()
+	cmd := pipe.Get(ctx, "key")
+	if _, err := pipe.Exec(ctx); !errors.Is(err, ErrRedisUnavailable) || !errors.Is(cmd.Err(), ErrRedisUnavailable) {
+		t.Errorf("pipeline with breaker open error = %v, %v, want %v", err, cmd.Err(), ErrRedisUnavailable)
+	}
+}
+
+// 斷路器開啟期間不送出指令，監控程序重新連線成功後關閉並通知協調程序
+func TestRedisMonitorClosesBreaker(t *testing.T) {
+	resetBreakerAfter(t)
+	server, rdb := newTestRedis(t)
+	InstallBreaker(rdb)
+	ctx := context.Background()
+
+	OpenBreaker(errors.New("connection refused"))
+	before := server.CommandCount()
+	if err := rdb.Set(ctx, "key", "value", 0).Err(); !errors.Is(err, ErrRedisUnavailable) {
+		t.Fatalf("Set with breaker open error = %v, want %v", err, ErrRedisUnavailable)
+	}
+	if count := server.CommandCount(); count != before {
+		t.Errorf("commands sent with breaker open = %d, want 0", count-before)
+	}
+
+	go RunRedisMonitor(rdb, 10*time.Millisecond)
+	select {
+	case <-redisBreaker.recovered:
+	case <-time.After(time.Second):
+		t.Fatal("breaker was not closed after Redis became reachable")
+	}
+	if !RedisAvailable() {
+		t.Fatal("breaker should be closed")
+	}
+	if err := rdb.Set(ctx, "key", "value", 0).Err(); err != nil {
+		t.Errorf("Set after recovery: %v", err)
+	}
+}
+
+// 斷路器開啟時商品快取改從資料庫讀取，不使用Redis中的舊資料
+func TestProductCacheFallsBackToDatabase(t *testing.T) {
+	resetBreakerAfter(t)
+	_, rdb := newTestRedis(t)
+	InstallBreaker(rdb)
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	err = db.AutoMigrate(&models.Product{}, &models.ProductVariant{}, &models.ProductImage{}, &models.Category{})
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	product := models.Product{Name: "Cable", Price: 300, Stock: 10}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+	pc := NewProductCache(db, rdb)
+	if _, err := pc.GetDetail(ctx, product.ID); err != nil {
+		t.Fatalf("GetDetail: %v", err)
+	}
+
+	//Redis斷線期間修改商品，快取仍是舊資料
+	OpenBreaker(errors.New("connection refused"))
+	db.Model(&product).Update("name", "USB Cable")
+
+	detail, err := pc.GetDetail(ctx, product.ID)
+	if err != nil {
+		t.Fatalf("GetDetail with breaker open: %v", err)
+	}
+	if detail.Name != "USB Cable" {
+		t.Errorf("name = %q, want the database value", detail.Name)
+	}
+	if _, err := pc.Get(ctx, []uint{product.ID}); !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("Get with breaker open error = %v, want %v", err, ErrRedisUnavailable)
+	}
+	if err := pc.Refresh(ctx, product.ID); !errors.Is(err, ErrRedisUnavailable) {
+		t.Errorf("Refresh with breaker open error = %v, want %v", err, ErrRedisUnavailable)
+	}
+}
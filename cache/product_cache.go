@@ -0,0 +1,317 @@
+package cache
+
+import (
+	"Backend/catalog"
+	"Backend/models"
+	"Backend/search"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+// Redis中的商品快取
+// products 為ZSET，分數為商品ID，成員為商品JSON
+// products:versions 為HASH，記錄快取中每個商品的版本(商品updated_at的毫秒數)
+// products:tombstones 為HASH，記錄已刪除商品的刪除版本，避免較晚到達的舊資料將商品寫回
+// products:detail:<商品ID> 為HASH，version為版本，product為含規格及圖片的商品JSON，用於商品詳細資料
+const (
+	productsKey     = "products"
+	versionsKey     = "products:versions"
+	tombstonesKey   = "products:tombstones"
+	detailKeyPrefix = "products:detail:"
+)
+
+// 版本不低於目前快取且高於刪除版本時才寫入，回傳1代表已寫入
+var setScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or '0')
+local deleted = tonumber(redis.call('HGET', KEYS[3], ARGV[1]) or '0')
+local version = tonumber(ARGV[2])
+if version < current or version <= deleted then
+	return 0
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[1])
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[3])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('HSET', KEYS[4], 'version', ARGV[2], 'product', ARGV[3])
+return 1
+`)
+
+// 刪除商品快取並記錄刪除版本
+var removeScript = redis.NewScript(`
+local deleted = tonumber(redis.call('HGET', KEYS[3], ARGV[1]) or '0')
+if tonumber(ARGV[2]) > deleted then
+	redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('DEL', KEYS[4])
+return 1
+`)
+
+// 商品快取，負責Redis中的商品資料、商品列表索引及搜尋索引
+// 所有寫入都應在資料庫事務提交後經由Refresh重新從資料庫讀取，快取不會領先資料庫
+type ProductCache struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewProductCache(db *gorm.DB, rdb *redis.Client) *ProductCache {
+	return &ProductCache{db: db, rdb: rdb}
+}
+
+// 商品的快取版本，商品或其規格、圖片、標籤變更時都須更新updated_at
+func Version(product *models.Product) int64 {
+	return product.UpdatedAt.UnixMilli()
+}
+
+// 更新商品的updated_at使快取版本遞增，用於未直接修改商品欄位的變更(如圖片、標籤)
+// 應在與變更相同的事務中呼叫
+func Touch(tx *gorm.DB, productIDs ...uint) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+	return tx.
+		Unscoped().
+		Model(&models.Product{}).
+		Where("id IN ?", productIDs).
+		Update("updated_at", time.Now()).
+		Error
+}
+
+// 建立商品列表索引所需的資料，product須已載入標籤
+func CatalogEntry(product *models.Product) catalog.Entry {
+	categoryIDs := make([]uint, len(product.Categories))
+	for i, category := range product.Categories {
+		categoryIDs[i] = category.ID
+	}
+	return catalog.Entry{
+		ID:          product.ID,
+		Name:        product.Name,
+		Price:       product.Price,
+		Stock:       product.Stock,
+		CreatedAt:   product.CreatedAt,
+		CategoryIDs: categoryIDs,
+	}
+}
+
+// 建立搜尋索引所需的資料
+func SearchDocument(product *models.Product) search.Document {
+	return search.Document{
+		ProductID:   product.ID,
+		Name:        product.Name,
+		Description: product.Description,
+	}
+}
+
+// 載入快取所需的標籤、規格及圖片，規格依ID排序，圖片依順序排序
+func preloadDetails(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Categories").
+		Preload("Variants", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id ASC")
+		}).
+		Preload("Images", func(db *gorm.DB) *gorm.DB {
+			return db.Order("sort_order ASC, id ASC")
+		})
+}
+
+func formatID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func detailKey(productID uint) string {
+	return detailKeyPrefix + formatID(productID)
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// 重新從資料庫讀取商品(含標籤、規格及圖片)並更新快取，已刪除或不存在的商品從快取刪除
+// 應在事務提交後以事務外的db呼叫
+func (pc *ProductCache) Refresh(ctx context.Context, productIDs ...uint) error {
+	if !RedisAvailable() {
+		return ErrRedisUnavailable
+	}
+
+	productIDs = uniqueIDs(productIDs)
+	for start := 0; start < len(productIDs); start += 500 {
+		end := start + 500
+		if end > len(productIDs) {
+			end = len(productIDs)
+		}
+		batch := productIDs[start:end]
+
+		var products []models.Product
+		err := pc.db.
+			Scopes(preloadDetails).
+			Where("id IN ?", batch).
+			Find(&products).
+			Error
+		if err != nil {
+			return err
+		}
+
+		found := make(map[uint]bool, len(products))
+		for i := range products {
+			found[products[i].ID] = true
+			if err := pc.set(ctx, &products[i]); err != nil {
+				return err
+			}
+		}
+
+		var missingIDs []uint
+		for _, productID := range batch {
+			if !found[productID] {
+				missingIDs = append(missingIDs, productID)
+			}
+		}
+		if len(missingIDs) == 0 {
+			continue
+		}
+
+		//已軟刪除的商品以刪除時間為刪除版本，完全不存在的商品以目前時間為刪除版本
+		var deletedProducts []models.Product
+		err = pc.db.
+			Unscoped().
+			Select("id", "deleted_at").
+			Where("id IN ? AND deleted_at IS NOT NULL", missingIDs).
+			Find(&deletedProducts).
+			Error
+		if err != nil {
+			return err
+		}
+		deletedVersions := make(map[uint]int64, len(deletedProducts))
+		for _, product := range deletedProducts {
+			deletedVersions[product.ID] = product.DeletedAt.Time.UnixMilli()
+		}
+
+		for _, productID := range missingIDs {
+			version, ok := deletedVersions[productID]
+			if !ok {
+				version = time.Now().UnixMilli()
+			}
+			if err := pc.remove(ctx, productID, version); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 寫入商品快取，版本較舊時略過
+// 索引更新失敗時刪除版本紀錄，讓協調程序重新寫入
+func (pc *ProductCache) set(ctx context.Context, product *models.Product) error {
+	productJSON, err := json.Marshal(product)
+	if err != nil {
+		return fmt.Errorf("無法序列化商品%d: %w", product.ID, err)
+	}
+
+	member := formatID(product.ID)
+	written, err := setScript.Run(ctx, pc.rdb,
+		[]string{productsKey, versionsKey, tombstonesKey, detailKey(product.ID)},
+		member, Version(product), productJSON,
+	).Int()
+	if err != nil {
+		return err
+	}
+	if written == 0 {
+		return nil
+	}
+
+	err = catalog.IndexProduct(ctx, pc.rdb, CatalogEntry(product))
+	if err == nil {
+		err = search.IndexProduct(ctx, pc.rdb, SearchDocument(product))
+	}
+	if err != nil {
+		pc.rdb.HDel(ctx, versionsKey, member)
+		return err
+	}
+	return nil
+}
+
+// 從快取及索引刪除商品
+func (pc *ProductCache) remove(ctx context.Context, productID uint, version int64) error {
+	err := removeScript.Run(ctx, pc.rdb,
+		[]string{productsKey, versionsKey, tombstonesKey, detailKey(productID)},
+		formatID(productID), version,
+	).Err()
+	if err != nil {
+		return err
+	}
+
+	err = catalog.RemoveProduct(ctx, pc.rdb, productID)
+	if err != nil {
+		return err
+	}
+	return search.RemoveProduct(ctx, pc.rdb, productID)
+}
+
+// 依商品ID讀取快取中的商品資料，快取中沒有的商品不會出現在結果中
+// Redis無法使用時回傳ErrRedisUnavailable，呼叫端應改從資料庫讀取
+func (pc *ProductCache) Get(ctx context.Context, productIDs []uint) (map[uint]models.Product, error) {
+	if !RedisAvailable() {
+		return nil, ErrRedisUnavailable
+	}
+
+	pipe := pc.rdb.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(productIDs))
+	for i, productID := range productIDs {
+		score := formatID(productID)
+		cmds[i] = pipe.ZRangeByScore(ctx, productsKey, &redis.ZRangeBy{Min: score, Max: score})
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	products := make(map[uint]models.Product, len(productIDs))
+	for i, productID := range productIDs {
+		for _, productJSON := range cmds[i].Val() {
+			var product models.Product
+			if err := json.Unmarshal([]byte(productJSON), &product); err != nil {
+				continue
+			}
+			products[productID] = product
+		}
+	}
+	return products, nil
+}
+
+// 讀取商品詳細資料(含標籤、規格及圖片)，快取中沒有時從資料庫讀取並寫入快取
+// Redis無法使用時直接從資料庫讀取，商品不存在時回傳gorm.ErrRecordNotFound
+func (pc *ProductCache) GetDetail(ctx context.Context, productID uint) (models.Product, error) {
+	var product models.Product
+	if RedisAvailable() {
+		productJSON, err := pc.rdb.HGet(ctx, detailKey(productID), "product").Result()
+		if err == nil && json.Unmarshal([]byte(productJSON), &product) == nil {
+			return product, nil
+		}
+	}
+
+	err := pc.db.
+		Scopes(preloadDetails).
+		First(&product, productID).
+		Error
+	if err != nil {
+		return product, err
+	}
+
+	//寫入失敗不影響回應，由協調程序修復
+	if RedisAvailable() {
+		_ = pc.set(ctx, &product)
+	}
+	return product, nil
+}
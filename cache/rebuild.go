@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"time"
+)
+
+var ErrRebuildInProgress = errors.New("其他程序正在重建中")
+
+// 重建鎖的有效時間，重建期間每隔三分之一的時間延長一次，程序中止時鎖會自動過期
+const lockTTL = 30 * time.Second
+
+// 重建鎖的等待者每隔多久檢查一次鎖是否已釋放
+const lockPollInterval = 100 * time.Millisecond
+
+// 只有持有者可以釋放或延長鎖
+var (
+	releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+	extendLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+// 同一程序內相同名稱的重建只執行一次，同時呼叫者等待並共用結果
+type flightCall struct {
+	done chan struct{}
+	err  error
+}
+
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+var rebuilds = &flightGroup{calls: make(map[string]*flightCall)}
+
+func (g *flightGroup) do(key string, fn func() error) error {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.err = fn()
+	return call.err
+}
+
+func lockKey(name string) string {
+	return "lock:rebuild:" + name
+}
+
+// 以SET NX PX取得鎖，回傳用於釋放鎖的token
+func acquireLock(ctx context.Context, rdb *redis.Client, key string) (string, bool, error) {
+	token := uuid.New().String()
+	ok, err := rdb.SetNX(ctx, key, token, lockTTL).Result()
+	return token, ok, err
+}
+
+// 等待其他程序釋放鎖，超過wait時回傳ErrRebuildInProgress
+func waitForLock(ctx context.Context, rdb *redis.Client, key string, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		time.Sleep(lockPollInterval)
+
+		count, err := rdb.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+	}
+	return ErrRebuildInProgress
+}
+
+// 執行fn期間定期延長鎖，fn結束後釋放鎖
+func runWithLock(ctx context.Context, rdb *redis.Client, key, token string, fn func(ctx context.Context) error) error {
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		releaseLockScript.Run(ctx, rdb, []string{key}, token)
+	}()
+
+	go func() {
+		ticker := time.NewTicker(lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				extendLockScript.Run(ctx, rdb, []string{key}, token, lockTTL.Milliseconds())
+			}
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// 重建Redis中以name區分的資料，避免大量請求同時重建
+// 同一程序內只有一個呼叫者執行，跨程序以Redis鎖確保只有一個實例重建
+// 未取得鎖時最多等待wait讓其他實例完成，逾時回傳ErrRebuildInProgress，呼叫端應改用資料庫或舊資料
+// 不使用請求的context，避免發起請求的連線中斷時中止其他請求也在等待的重建
+func Rebuild(rdb *redis.Client, name string, wait time.Duration, fn func(ctx context.Context) error) error {
+	return rebuilds.do(name, func() error {
+		ctx := context.Background()
+		key := lockKey(name)
+		token, ok, err := acquireLock(ctx, rdb, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return waitForLock(ctx, rdb, key, wait)
+		}
+		return runWithLock(ctx, rdb, key, token, fn)
+	})
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return server, rdb
+}
+
+// 同一程序內同時呼叫只執行一次重建，所有呼叫者取得相同的結果
+func TestRebuildSharesConcurrentCalls(t *testing.T) {
+	server, rdb := newTestRedis(t)
+
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	errRebuild := errors.New("rebuild failed")
+	fn := func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		//重建期間持有鎖且設定了有效時間
+		if ttl := server.TTL(lockKey("shared")); ttl != lockTTL {
+			t.Errorf("lock TTL = %v, want %v", ttl, lockTTL)
+		}
+		<-release
+		return errRebuild
+	}
+
+	const callers = 10
+	errs := make(chan error, callers)
+	go func() {
+		errs <- Rebuild(rdb, "shared", time.Second, fn)
+	}()
+	<-started
+
+	var wg sync.WaitGroup
+	for i := 1; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Rebuild(rdb, "shared", time.Second, fn)
+		}()
+	}
+	//讓其他呼叫者加入進行中的重建
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		if err := <-errs; err != errRebuild {
+			t.Errorf("Rebuild error = %v, want %v", err, errRebuild)
+		}
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	if server.Exists(lockKey("shared")) {
+		t.Error("lock should be released after rebuild")
+	}
+
+	//重建結束後再次呼叫會重新執行
+	if err := Rebuild(rdb, "shared", time.Second, func(ctx context.Context) error { return nil }); err != nil {
+		t.Errorf("Rebuild after finish: %v", err)
+	}
+}
+
+// 其他實例持有鎖時不執行重建，等待鎖釋放後回傳
+func TestRebuildWaitsForOtherInstance(t *testing.T) {
+	server, rdb := newTestRedis(t)
+	key := lockKey("waiting")
+	server.Set(key, "other-instance")
+
+	go func() {
+		time.Sleep(3 * lockPollInterval)
+		server.Del(key)
+	}()
+
+	called := false
+	start := time.Now()
+	err := Rebuild(rdb, "waiting", 5*time.Second, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Rebuild error = %v, want nil", err)
+	}
+	if called {
+		t.Error("fn should not run while another instance holds the lock")
+	}
+	if elapsed := time.Since(start); elapsed < 3*lockPollInterval {
+		t.Errorf("Rebuild returned after %v, before the lock was released", elapsed)
+	}
+}
+
+// 其他實例在等待時間內未完成時回傳ErrRebuildInProgress
+func TestRebuildTimesOut(t *testing.T) {
+	server, rdb := newTestRedis(t)
+	key := lockKey("timeout")
+	server.Set(key, "other-instance")
+
+	called := false
+	start := time.Now()
+	err := Rebuild(rdb, "timeout", 3*lockPollInterval, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if err != ErrRebuildInProgress {
+		t.Fatalf("Rebuild error = %v, want %v", err, ErrRebuildInProgress)
+	}
+	if called {
+		t.Error("fn should not run while another instance holds the lock")
+	}
+	if elapsed := time.Since(start); elapsed < 3*lockPollInterval {
+		t.Errorf("Rebuild timed out after %v, want at least %v", elapsed, 3*lockPollInterval)
+	}
+	if value, _ := server.Get(key); value != "other-instance" {
+		t.Errorf("lock of another instance = %q, should not be changed", value)
+	}
+}
+
+// 鎖過期後被其他實例取得時，原本的持有者結束重建不會刪除其他實例的鎖
+func TestRebuildReleasesOnlyOwnLock(t *testing.T) {
+	server, rdb := newTestRedis(t)
+	key := lockKey("owner")
+
+	err := Rebuild(rdb, "owner", time.Second, func(ctx context.Context) error {
+		server.Set(key, "other-instance")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	if value, _ := server.Get(key); value != "other-instance" {
+		t.Errorf("lock = %q, lock of another instance should be kept", value)
+	}
+}
+
+func TestExtendLock(t *testing.T) {
+	server, rdb := newTestRedis(t)
+	ctx := context.Background()
+	key := lockKey("extend")
+
+	token, ok, err := acquireLock(ctx, rdb, key)
+	if err != nil || !ok {
+		t.Fatalf("acquireLock = %v, %v", ok, err)
+	}
+	if _, ok, _ := acquireLock(ctx, rdb, key); ok {
+		t.Fatal("lock should not be acquired twice")
+	}
+
+	server.FastForward(lockTTL - time.Second)
+	extendLockScript.Run(ctx, rdb, []string{key}, token, lockTTL.Milliseconds())
+	if ttl := server.TTL(key); ttl != lockTTL {
+		t.Errorf("TTL after extend = %v, want %v", ttl, lockTTL)
+	}
+
+	//其他token不可延長
+	server.FastForward(lockTTL - time.Second)
+	extendLockScript.Run(ctx, rdb, []string{key}, "other-token", lockTTL.Milliseconds())
+	server.FastForward(2 * time.Second)
+	if server.Exists(key) {
+		t.Error("lock extended by another token should expire")
+	}
+}
@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"Backend/catalog"
+	"Backend/models"
+	"Backend/search"
+	"context"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"time"
+)
+
+// 刪除版本保留一天，足以擋下延遲到達的舊寫入
+const tombstoneTTL = 24 * time.Hour
+
+// 協調結果，Checked為檢查的商品數，Repaired為重新寫入的商品數，Removed為從快取刪除的商品數
+type ReconcileResult struct {
+	Checked  int
+	Repaired int
+	Removed  int
+}
+
+// 比對資料庫與快取並修復差異
+// 版本不符或快取中沒有的商品重新寫入，快取或索引中已不存在於資料庫的商品刪除
+func (pc *ProductCache) Reconcile(ctx context.Context) (ReconcileResult, error) {
+	var result ReconcileResult
+
+	liveIDs := make(map[uint]bool)
+	var products []models.Product
+	var refreshErr error
+	err := pc.db.
+		Select("id", "updated_at").
+		FindInBatches(&products, 1000, func(tx *gorm.DB, batch int) error {
+			staleIDs, err := pc.staleProductIDs(ctx, products)
+			if err != nil {
+				refreshErr = err
+				return err
+			}
+			if err := pc.Refresh(ctx, staleIDs...); err != nil {
+				refreshErr = err
+				return err
+			}
+
+			for _, product := range products {
+				liveIDs[product.ID] = true
+			}
+			result.Checked += len(products)
+			result.Repaired += len(staleIDs)
+			return nil
+		}).
+		Error
+	if refreshErr != nil {
+		return result, refreshErr
+	}
+	if err != nil {
+		return result, err
+	}
+
+	cachedIDs, err := pc.cachedProductIDs(ctx, len(liveIDs))
+	if err != nil {
+		return result, err
+	}
+	var orphanIDs []uint
+	for _, productID := range cachedIDs {
+		if !liveIDs[productID] {
+			orphanIDs = append(orphanIDs, productID)
+		}
+	}
+	//Refresh會重新確認資料庫，避免誤刪協調期間新增的商品
+	if err := pc.Refresh(ctx, orphanIDs...); err != nil {
+		return result, err
+	}
+	result.Removed = len(orphanIDs)
+
+	return result, pc.purgeTombstones(ctx)
+}
+
+// 版本與資料庫不符或快取中沒有資料(列表或詳細資料)的商品
+func (pc *ProductCache) staleProductIDs(ctx context.Context, products []models.Product) ([]uint, error) {
+	if len(products) == 0 {
+		return nil, nil
+	}
+
+	fields := make([]string, len(products))
+	for i, product := range products {
+		fields[i] = formatID(product.ID)
+	}
+
+	pipe := pc.rdb.Pipeline()
+	versionsCmd := pipe.HMGet(ctx, versionsKey, fields...)
+	countCmds := make([]*redis.IntCmd, len(products))
+	detailCmds := make([]*redis.StringCmd, len(products))
+	for i, field := range fields {
+		countCmds[i] = pipe.ZCount(ctx, productsKey, field, field)
+		detailCmds[i] = pipe.HGet(ctx, detailKey(products[i].ID), "version")
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var staleIDs []uint
+	for i, value := range versionsCmd.Val() {
+		version, _ := value.(string)
+		expected := strconv.FormatInt(Version(&products[i]), 10)
+		if version != expected || countCmds[i].Val() != 1 || detailCmds[i].Val() != version {
+			staleIDs = append(staleIDs, products[i].ID)
+		}
+	}
+	return staleIDs, nil
+}
+
+// 快取、商品列表索引及搜尋索引中的所有商品ID
+// 快取商品數與資料庫不同時才逐一讀取快取中的商品ID，找出沒有版本紀錄的舊資料
+func (pc *ProductCache) cachedProductIDs(ctx context.Context, liveCount int) ([]uint, error) {
+	var productIDs []uint
+
+	fields, err := pc.rdb.HKeys(ctx, versionsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		productID, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		productIDs = append(productIDs, uint(productID))
+	}
+
+	catalogIDs, err := catalog.IndexedProductIDs(ctx, pc.rdb)
+	if err != nil {
+		return nil, err
+	}
+	productIDs = append(productIDs, catalogIDs...)
+
+	searchIDs, err := search.IndexedProductIDs(ctx, pc.rdb)
+	if err != nil {
+		return nil, err
+	}
+	productIDs = append(productIDs, searchIDs...)
+
+	count, err := pc.rdb.ZCard(ctx, productsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	for start := int64(0); count != int64(liveCount) && start < count; start += 1000 {
+		entries, err := pc.rdb.ZRangeWithScores(ctx, productsKey, start, start+999).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			productIDs = append(productIDs, uint(entry.Score))
+		}
+	}
+
+	return uniqueIDs(productIDs), nil
+}
+
+// 刪除超過保留時間的刪除版本
+func (pc *ProductCache) purgeTombstones(ctx context.Context) error {
+	tombstones, err := pc.rdb.HGetAll(ctx, tombstonesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	expiredBefore := time.Now().Add(-tombstoneTTL).UnixMilli()
+	var expired []string
+	for field, value := range tombstones {
+		version, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || version < expiredBefore {
+			expired = append(expired, field)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	return pc.rdb.HDel(ctx, tombstonesKey, expired...).Err()
+}
+
+// 啟動時先協調一次，之後定期及Redis恢復連線時比對資料庫與快取，應以goroutine執行
+// Redis無法使用時略過，恢復後立即協調以補上斷線期間未寫入的變更
+// 多個實例同時執行時只有取得鎖的實例協調
+func (pc *ProductCache) RunReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if RedisAvailable() {
+			var result ReconcileResult
+			err := Rebuild(pc.rdb, "reconcile", 0, func(ctx context.Context) error {
+				var err error
+				result, err = pc.Reconcile(ctx)
+				return err
+			})
+			if err != nil && err != ErrRebuildInProgress {
+				log.Printf("協調商品快取失敗: %v\n", err)
+			} else if result.Repaired > 0 || result.Removed > 0 {
+				log.Printf("商品快取已修復%d筆、刪除%d筆\n", result.Repaired, result.Removed)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-redisBreaker.recovered:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// 標籤分組的簽章，標籤新增、移動或合併後簽章不同，已儲存的數量視為過期
+func groupsSignature(groups map[uint][]uint) string {
+	categoryIDs := make([]uint, 0, len(groups))
+	for categoryID := range groups {
+		categoryIDs = append(categoryIDs, categoryID)
+	}
+	sort.Slice(categoryIDs, func(i, j int) bool { return categoryIDs[i] < categoryIDs[j] })
+
+	hash := fnv.New64a()
+	for _, categoryID := range categoryIDs {
+		memberIDs := append([]uint(nil), groups[categoryID]...)
+		sort.Slice(memberIDs, func(i, j int) bool { return memberIDs[i] < memberIDs[j] })
+		fmt.Fprintf(hash, "%d:%s;", categoryID, formatIDs(memberIDs))
+	}
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// 各標籤有庫存的商品數量，groups為每個標籤對應其本身及所有子標籤的ID，含有其中任一標籤的商品只計算一次
+// 數量儲存於Redis，商品索引變更或標籤分組改變後的第一次查詢重新計算
+func InStockCategoryCounts(ctx context.Context, rdb *redis.Client, groups map[uint][]uint) (map[uint]int64, error) {
+	signature := groupsSignature(groups)
+
+	pipe := rdb.Pipeline()
+	revisionCmd := pipe.Get(ctx, revisionKey)
+	storedCmd := pipe.HGetAll(ctx, categoryCountsKey)
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	revision := revisionCmd.Val()
+	stored := storedCmd.Val()
+
+	counts := make(map[uint]int64, len(groups))
+	if len(stored) > 0 && stored["revision"] == revision && stored["groups"] == signature {
+		for categoryID := range groups {
+			counts[categoryID], _ = strconv.ParseInt(stored[formatID(categoryID)], 10, 64)
+		}
+		return counts, nil
+	}
+
+	//計算期間有商品變更時，儲存的revision已較舊，下次查詢會再重新計算
+	tmpKey := tmpKeyPrefix + uuid.New().String()
+	pipe = rdb.TxPipeline()
+	countCmds := make(map[uint]*redis.IntCmd, len(groups))
+	for categoryID, categoryIDs := range groups {
+		pipe.SUnionStore(ctx, tmpKey, categoryKeys(categoryIDs)...)
+		countCmds[categoryID] = pipe.SInterStore(ctx, tmpKey, tmpKey, inStockKey)
+	}
+	pipe.Del(ctx, tmpKey)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []interface{}{"revision", revision, "groups", signature}
+	for categoryID, countCmd := range countCmds {
+		counts[categoryID] = countCmd.Val()
+		fields = append(fields, formatID(categoryID), countCmd.Val())
+	}
+
+	pipe = rdb.TxPipeline()
+	pipe.Del(ctx, categoryCountsKey)
+	pipe.HSet(ctx, categoryCountsKey, fields...)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
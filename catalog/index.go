@@ -0,0 +1,229 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis中商品列表的次要索引，成員皆為補零至20位的商品ID
+// 分數相同的成員依字典順序排列，補零後與商品ID的數值順序相同，與資料庫以ID排序相同的商品一致
+// products:index:ids 為ZSET，分數為商品ID，用於預設排序
+// products:index:price 為ZSET，分數為價格，用於價格篩選及排序
+// products:index:created 為ZSET，分數為建立時間的毫秒數，用於最新排序
+// products:index:instock 為SET，有庫存的商品
+// products:index:category:<標籤ID> 為SET，含有此標籤的商品
+// products:index:product:<補零的商品ID> 為HASH，記錄名稱排序用的鍵(名稱接上補零的商品ID)及目前的標籤，用於更新及刪除索引
+// products:index:ready:<格式版本> 在索引完整建立後才存在，重建期間、遺失或格式變更時不存在
+// products:index:revision 每次商品索引變更時遞增，用於判斷標籤商品數量是否過期
+// products:index:categorycounts 為HASH，各標籤(含子標籤)有庫存的商品數量
+const (
+	keyPrefix         = "products:index:"
+	idsKey            = keyPrefix + "ids"
+	priceKey          = keyPrefix + "price"
+	createdKey        = keyPrefix + "created"
+	inStockKey        = keyPrefix + "instock"
+	categoryKeyPrefix = keyPrefix + "category:"
+	productKeyPrefix  = keyPrefix + "product:"
+	tmpKeyPrefix      = keyPrefix + "tmp:"
+	readyKey          = keyPrefix + "ready:" + indexFormat
+	revisionKey       = keyPrefix + "revision"
+	categoryCountsKey = keyPrefix + "categorycounts"
+)
+
+// 索引格式版本，成員或分數的格式變更時遞增，舊格式的索引視為未建立而重新建立
+const indexFormat = "3"
+
+// 建立索引所需的商品資料
+type Entry struct {
+	ID          uint
+	Name        string
+	Price       uint
+	Stock       uint
+	CreatedAt   time.Time
+	CategoryIDs []uint
+}
+
+func formatID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// 索引成員，商品ID補零至20位(uint64的最大位數)
+func formatMember(id uint) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+// 標籤的商品集合
+func CategoryKey(categoryID uint) string {
+	return categoryKeyPrefix + formatID(categoryID)
+}
+
+// 商品的索引資料，與成員同樣補零，名稱排序時SORT以成員組成此鍵
+func productKey(productID uint) string {
+	return productKeyPrefix + formatMember(productID)
+}
+
+// 名稱排序用的鍵，SORT ALPHA遇到相同的值時順序不固定，因此接上補零的商品ID
+// 分隔字元\x01小於所有可見字元，名稱為另一名稱的前綴時仍排在前面
+func sortName(entry Entry) string {
+	return entry.Name + "\x01" + formatMember(entry.ID)
+}
+
+func formatIDs(ids []uint) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = formatID(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseIDs(value string) []uint {
+	var ids []uint
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids
+}
+
+// 將建立商品索引的指令加入pipe，oldCategoryIDs為商品原本的標籤
+func addEntry(ctx context.Context, pipe redis.Pipeliner, entry Entry, oldCategoryIDs []uint) {
+	member := formatMember(entry.ID)
+
+	newCategories := make(map[uint]bool, len(entry.CategoryIDs))
+	for _, categoryID := range entry.CategoryIDs {
+		newCategories[categoryID] = true
+	}
+	for _, categoryID := range oldCategoryIDs {
+		if !newCategories[categoryID] {
+			pipe.SRem(ctx, CategoryKey(categoryID), member)
+		}
+	}
+	for categoryID := range newCategories {
+		pipe.SAdd(ctx, CategoryKey(categoryID), member)
+	}
+
+	pipe.ZAdd(ctx, idsKey, redis.Z{Score: float64(entry.ID), Member: member})
+	pipe.ZAdd(ctx, priceKey, redis.Z{Score: float64(entry.Price), Member: member})
+	pipe.ZAdd(ctx, createdKey, redis.Z{Score: float64(entry.CreatedAt.UnixMilli()), Member: member})
+	if entry.Stock > 0 {
+		pipe.SAdd(ctx, inStockKey, member)
+	} else {
+		pipe.SRem(ctx, inStockKey, member)
+	}
+	pipe.HSet(ctx, productKey(entry.ID), "name", sortName(entry), "categories", formatIDs(entry.CategoryIDs))
+	pipe.Incr(ctx, revisionKey)
+}
+
+// 建立或更新商品的索引
+func IndexProduct(ctx context.Context, rdb *redis.Client, entry Entry) error {
+	oldCategories, err := rdb.HGet(ctx, productKey(entry.ID), "categories").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	pipe := rdb.TxPipeline()
+	addEntry(ctx, pipe, entry, parseIDs(oldCategories))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 刪除商品的索引
+func RemoveProduct(ctx context.Context, rdb *redis.Client, productID uint) error {
+	oldCategories, err := rdb.HGet(ctx, productKey(productID), "categories").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	member := formatMember(productID)
+
+	pipe := rdb.TxPipeline()
+	for _, categoryID := range parseIDs(oldCategories) {
+		pipe.SRem(ctx, CategoryKey(categoryID), member)
+	}
+	pipe.ZRem(ctx, idsKey, member)
+	pipe.ZRem(ctx, priceKey, member)
+	pipe.ZRem(ctx, createdKey, member)
+	pipe.SRem(ctx, inStockKey, member)
+	pipe.Del(ctx, productKey(productID))
+	pipe.Incr(ctx, revisionKey)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 索引是否已完整建立，商品數量為0時索引仍可能是完整的
+func IsReady(ctx context.Context, rdb *redis.Client) (bool, error) {
+	count, err := rdb.Exists(ctx, readyKey).Result()
+	return count > 0, err
+}
+
+// 開始重建，刪除完成標記及所有索引，之後以AddEntries分批加入並以FinishRebuild標記完成
+func BeginRebuild(ctx context.Context, rdb *redis.Client) error {
+	err := rdb.Del(ctx, readyKey).Err()
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	iter := rdb.Scan(ctx, 0, keyPrefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	pipe := rdb.Pipeline()
+	for start := 0; start < len(keys); start += 1000 {
+		end := start + 1000
+		if end > len(keys) {
+			end = len(keys)
+		}
+		pipe.Del(ctx, keys[start:end]...)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 重建期間加入一批商品的索引
+func AddEntries(ctx context.Context, rdb *redis.Client, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	pipe := rdb.Pipeline()
+	for _, entry := range entries {
+		addEntry(ctx, pipe, entry, nil)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 標記索引已完整建立
+func FinishRebuild(ctx context.Context, rdb *redis.Client) error {
+	return rdb.Set(ctx, readyKey, 1, 0).Err()
+}
+
+// 已建立索引的所有商品ID
+func IndexedProductIDs(ctx context.Context, rdb *redis.Client) ([]uint, error) {
+	members, err := rdb.ZRange(ctx, idsKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs := make([]uint, 0, len(members))
+	for _, member := range members {
+		productID, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		productIDs = append(productIDs, uint(productID))
+	}
+	return productIDs, nil
+}
@@ -0,0 +1,221 @@
+package catalog
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+)
+
+// 商品列表的排序方式
+type SortOrder string
+
+const (
+	SortByID        SortOrder = "id"
+	SortByPriceAsc  SortOrder = "price_asc"
+	SortByPriceDesc SortOrder = "price_desc"
+	SortByNewest    SortOrder = "newest"
+	SortByName      SortOrder = "name"
+)
+
+// 是否為支援的排序方式
+func IsValidSortOrder(sort SortOrder) bool {
+	switch sort {
+	case SortByID, SortByPriceAsc, SortByPriceDesc, SortByNewest, SortByName:
+		return true
+	}
+	return false
+}
+
+// 價格區間，包含Min，Max為nil代表沒有上限，否則不包含Max
+type PriceBucket struct {
+	Min uint
+	Max *uint
+}
+
+// 商品列表的篩選條件
+// Categories每個元素為一個標籤及其所有子標籤的ID，含有其中任一標籤即符合該元素
+// 依MatchAnyCategory決定須符合全部或任一元素，ExcludeCategories中的標籤皆不可含有
+// FacetCategories及PriceBuckets為要計算符合商品數量的標籤(對應其本身及子標籤的ID)及價格區間
+type Query struct {
+	MinPrice          *uint
+	MaxPrice          *uint
+	InStockOnly       bool
+	Categories        [][]uint
+	MatchAnyCategory  bool
+	ExcludeCategories []uint
+	Sort              SortOrder
+	Offset            int
+	Limit             int
+	FacetCategories   map[uint][]uint
+	PriceBuckets      []PriceBucket
+}
+
+func categoryKeys(categoryIDs []uint) []string {
+	keys := make([]string, len(categoryIDs))
+	for i, categoryID := range categoryIDs {
+		keys[i] = CategoryKey(categoryID)
+	}
+	return keys
+}
+
+// 篩選結果，ProductIDs為目前分頁的商品ID，Total為符合條件的商品總數
+// CategoryCounts及PriceBucketCounts為符合條件的商品中各標籤及價格區間的數量
+type Result struct {
+	ProductIDs        []uint
+	Total             int64
+	CategoryCounts    map[uint]int64
+	PriceBucketCounts []int64
+}
+
+// 依篩選條件查詢商品ID，所有運算在同一個Redis事務中以暫存的ZSET完成
+// 暫存的ZSET分數為商品價格，用於價格篩選及價格區間的數量
+func Find(ctx context.Context, rdb *redis.Client, query Query) (Result, error) {
+	result := Result{
+		CategoryCounts: make(map[uint]int64, len(query.FacetCategories)),
+	}
+
+	tmpKey := tmpKeyPrefix + uuid.New().String()
+	resultKey := tmpKey + ":result"
+	anyKey := tmpKey + ":any"
+	excludeKey := tmpKey + ":exclude"
+	sortedKey := tmpKey + ":sorted"
+	facetKey := tmpKey + ":facet"
+	tmpKeys := []string{resultKey, anyKey, excludeKey, sortedKey, facetKey}
+
+	//含有多個標籤的元素先合併為暫存集合
+	groupKey := func(name string, categoryIDs []uint) string {
+		if len(categoryIDs) == 1 {
+			return CategoryKey(categoryIDs[0])
+		}
+		key := tmpKey + ":" + name
+		tmpKeys = append(tmpKeys, key)
+		return key
+	}
+
+	pipe := rdb.TxPipeline()
+
+	groupKeys := make([]string, len(query.Categories))
+	for i, categoryIDs := range query.Categories {
+		groupKeys[i] = groupKey("group:"+strconv.Itoa(i), categoryIDs)
+		if len(categoryIDs) > 1 {
+			pipe.SUnionStore(ctx, groupKeys[i], categoryKeys(categoryIDs)...)
+		}
+	}
+
+	//所有商品的價格與有庫存及須全部符合的標籤取交集，分數保留價格
+	keys := []string{priceKey}
+	weights := []float64{1}
+	if query.InStockOnly {
+		keys = append(keys, inStockKey)
+		weights = append(weights, 0)
+	}
+	if !query.MatchAnyCategory {
+		for _, key := range groupKeys {
+			keys = append(keys, key)
+			weights = append(weights, 0)
+		}
+	}
+	pipe.ZInterStore(ctx, resultKey, &redis.ZStore{Keys: keys, Weights: weights})
+
+	if query.MatchAnyCategory && len(groupKeys) > 0 {
+		pipe.ZUnionStore(ctx, anyKey, &redis.ZStore{Keys: groupKeys})
+		pipe.ZInterStore(ctx, resultKey, &redis.ZStore{
+			Keys:    []string{resultKey, anyKey},
+			Weights: []float64{1, 0},
+		})
+	}
+
+	//排除的商品分數設為-1後移除，價格不會小於0
+	if len(query.ExcludeCategories) > 0 {
+		pipe.ZUnionStore(ctx, excludeKey, &redis.ZStore{Keys: categoryKeys(query.ExcludeCategories)})
+		pipe.ZUnionStore(ctx, resultKey, &redis.ZStore{
+			Keys:      []string{resultKey, excludeKey},
+			Weights:   []float64{1, -1},
+			Aggregate: "MIN",
+		})
+		pipe.ZRemRangeByScore(ctx, resultKey, "-inf", "(0")
+	}
+
+	if query.MinPrice != nil {
+		pipe.ZRemRangeByScore(ctx, resultKey, "-inf", "("+strconv.FormatUint(uint64(*query.MinPrice), 10))
+	}
+	if query.MaxPrice != nil {
+		pipe.ZRemRangeByScore(ctx, resultKey, "("+strconv.FormatUint(uint64(*query.MaxPrice), 10), "+inf")
+	}
+
+	totalCmd := pipe.ZCard(ctx, resultKey)
+
+	categoryCmds := make(map[uint]*redis.IntCmd, len(query.FacetCategories))
+	for categoryID, categoryIDs := range query.FacetCategories {
+		key := groupKey("facet:"+strconv.FormatUint(uint64(categoryID), 10), categoryIDs)
+		if len(categoryIDs) > 1 {
+			pipe.SUnionStore(ctx, key, categoryKeys(categoryIDs)...)
+		}
+		categoryCmds[categoryID] = pipe.ZInterStore(ctx, facetKey, &redis.ZStore{
+			Keys: []string{resultKey, key},
+		})
+	}
+
+	bucketCmds := make([]*redis.IntCmd, len(query.PriceBuckets))
+	for i, bucket := range query.PriceBuckets {
+		max := "+inf"
+		if bucket.Max != nil {
+			max = "(" + strconv.FormatUint(uint64(*bucket.Max), 10)
+		}
+		bucketCmds[i] = pipe.ZCount(ctx, resultKey, strconv.FormatUint(uint64(bucket.Min), 10), max)
+	}
+
+	start := int64(query.Offset)
+	stop := int64(query.Offset + query.Limit - 1)
+	var idsCmd *redis.StringSliceCmd
+	switch query.Sort {
+	case SortByPriceAsc:
+		idsCmd = pipe.ZRange(ctx, resultKey, start, stop)
+	case SortByPriceDesc:
+		idsCmd = pipe.ZRevRange(ctx, resultKey, start, stop)
+	case SortByNewest:
+		pipe.ZInterStore(ctx, sortedKey, &redis.ZStore{
+			Keys:    []string{resultKey, createdKey},
+			Weights: []float64{0, 1},
+		})
+		idsCmd = pipe.ZRevRange(ctx, sortedKey, start, stop)
+	case SortByName:
+		idsCmd = pipe.Sort(ctx, resultKey, &redis.Sort{
+			By:     productKeyPrefix + "*->name",
+			Offset: int64(query.Offset),
+			Count:  int64(query.Limit),
+			Alpha:  true,
+		})
+	default:
+		pipe.ZInterStore(ctx, sortedKey, &redis.ZStore{
+			Keys:    []string{resultKey, idsKey},
+			Weights: []float64{0, 1},
+		})
+		idsCmd = pipe.ZRange(ctx, sortedKey, start, stop)
+	}
+
+	pipe.Del(ctx, tmpKeys...)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	result.Total = totalCmd.Val()
+	for categoryID, categoryCmd := range categoryCmds {
+		result.CategoryCounts[categoryID] = categoryCmd.Val()
+	}
+	result.PriceBucketCounts = make([]int64, len(bucketCmds))
+	for i, bucketCmd := range bucketCmds {
+		result.PriceBucketCounts[i] = bucketCmd.Val()
+	}
+	for _, member := range idsCmd.Val() {
+		productID, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		result.ProductIDs = append(result.ProductIDs, uint(productID))
+	}
+	return result, nil
+}
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"Backend/cache"
+	"Backend/jwt"
 	"Backend/models"
+	"Backend/payment"
+	"Backend/reservation"
+	"context"
 	"fmt"
 	"github.com/redis/go-redis/v9"
 	"gopkg.in/yaml.v3"
@@ -9,6 +14,9 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type DatabaseConfig struct {
@@ -19,15 +27,53 @@ type DatabaseConfig struct {
 	Database string `yaml:"database"`
 }
 
+// 未設定時連續失敗3次開啟斷路器，每5秒嘗試重新連線
 type RedisConfig struct {
-	Addr     string `yaml:"addr"`
-	Password string `yaml:"password"`
-	Database int    `yaml:"database"`
+	Addr                     string `yaml:"addr"`
+	Password                 string `yaml:"password"`
+	Database                 int    `yaml:"database"`
+	FailureThreshold         int    `yaml:"failureThreshold"`
+	ReconnectIntervalSeconds int    `yaml:"reconnectIntervalSeconds"`
+}
+
+type PaymentConfig struct {
+	EnableLocal bool `yaml:"enableLocal"`
+}
+
+// 未設定時保留15分鐘，每60秒清除過期保留
+type ReservationConfig struct {
+	TTLMinutes           int `yaml:"ttlMinutes"`
+	SweepIntervalSeconds int `yaml:"sweepIntervalSeconds"`
+}
+
+// 未設定時每300秒比對資料庫與Redis商品快取
+type ProductCacheConfig struct {
+	ReconcileIntervalSeconds int `yaml:"reconcileIntervalSeconds"`
+}
+
+// JWT金鑰，只用於驗證的舊金鑰可只設定publicKey
+type JWTKeyConfig struct {
+	ID         string `yaml:"id"`
+	PrivateKey string `yaml:"privateKey"`
+	PublicKey  string `yaml:"publicKey"`
+}
+
+// 未設定keys時使用jwt/private_key.pem及jwt/public_key.pem，未設定signingKeyID時以第一個金鑰簽發Token
+// 未設定時每30秒檢查JWT金鑰檔案是否變更，每3600秒清除過期的登入
+type JWTConfig struct {
+	SigningKeyID                string         `yaml:"signingKeyID"`
+	Keys                        []JWTKeyConfig `yaml:"keys"`
+	KeyReloadIntervalSeconds    int            `yaml:"keyReloadIntervalSeconds"`
+	SessionSweepIntervalSeconds int            `yaml:"sessionSweepIntervalSeconds"`
 }
 
 type Config struct {
-	Database DatabaseConfig `yaml:"database"`
-	Redis    RedisConfig    `yaml:"redis"`
+	Database     DatabaseConfig     `yaml:"database"`
+	Redis        RedisConfig        `yaml:"redis"`
+	Payment      PaymentConfig      `yaml:"payment"`
+	Reservation  ReservationConfig  `yaml:"reservation"`
+	ProductCache ProductCacheConfig `yaml:"productCache"`
+	JWT          JWTConfig          `yaml:"jwt"`
 }
 
 func LoadConfig(filename string) (Config, error) {
@@ -70,20 +116,79 @@ func SetupMySQLConnection() (*gorm.DB, error) {
 	err = db.AutoMigrate(
 		&models.User{},
 		&models.LoginToken{},
+		&models.RefreshToken{},
 		&models.Product{},
+		&models.ProductVariant{},
+		&models.ProductImage{},
 		&models.Category{},
 		&models.Order{},
 		&models.OrderItem{},
 		&models.Cart{},
 		&models.CartItem{},
+		&models.Payment{},
+		&models.OrderStatusHistory{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.ShippingMethod{},
+		&models.StockReservation{},
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	err = backfillCategorySlugs(db)
+	if err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
+// 為新增Slug欄位前建立的分類產生代稱，名稱無法產生代稱或代稱已被使用時加上分類ID
+func backfillCategorySlugs(db *gorm.DB) error {
+	var categories []models.Category
+	err := db.
+		Unscoped().
+		Select("id", "name", "slug").
+		Order("id ASC").
+		Find(&categories).
+		Error
+	if err != nil {
+		return err
+	}
+
+	//tree保留給分類樹的路由使用
+	used := map[string]bool{"tree": true}
+	for _, category := range categories {
+		if category.Slug != "" {
+			used[category.Slug] = true
+		}
+	}
+	for _, category := range categories {
+		if category.Slug != "" {
+			continue
+		}
+		slug := models.Slugify(category.Name)
+		for slug == "" || used[slug] {
+			slug = strings.TrimPrefix(slug+"-"+strconv.FormatUint(uint64(category.ID), 10), "-")
+		}
+		used[slug] = true
+		err = db.
+			Unscoped().
+			Model(&models.Category{}).
+			Where("id = ?", category.ID).
+			Update("slug", slug).
+			Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 建立Redis連線並加上斷路器
+// 不可因Redis無法連線而回傳錯誤，連線失敗時開啟斷路器並照常回傳客戶端，由資料庫提供資料直到重新連線
+// 只有設定檔讀取失敗時回傳錯誤，此時資料庫設定同樣無法讀取，呼叫者應中止啟動
 func SetupRedisConnection() (*redis.Client, error) {
 	config, err := LoadConfig("config/config.yaml")
 	if err != nil {
@@ -91,10 +196,115 @@ func SetupRedisConnection() (*redis.Client, error) {
 	}
 
 	redisClient := redis.NewClient(&redis.Options{
-		Addr:     config.Redis.Addr,
-		Password: config.Redis.Password,
-		DB:       config.Redis.Database,
+		Addr:        config.Redis.Addr,
+		Password:    config.Redis.Password,
+		DB:          config.Redis.Database,
+		DialTimeout: 2 * time.Second,
 	})
 
+	if config.Redis.FailureThreshold > 0 {
+		cache.SetBreakerThreshold(config.Redis.FailureThreshold)
+	}
+	cache.InstallBreaker(redisClient)
+
+	//無法連線時不中止服務，商品資料改由資料庫提供，並由背景程序定期重新連線
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		cache.OpenBreaker(err)
+	}
+
+	reconnectInterval := 5 * time.Second
+	if config.Redis.ReconnectIntervalSeconds > 0 {
+		reconnectInterval = time.Duration(config.Redis.ReconnectIntervalSeconds) * time.Second
+	}
+	go cache.RunRedisMonitor(redisClient, reconnectInterval)
+
 	return redisClient, nil
 }
+
+// 依設定註冊金流供應商
+func SetupPaymentProviders() error {
+	config, err := LoadConfig("config/config.yaml")
+	if err != nil {
+		return err
+	}
+
+	if config.Payment.EnableLocal {
+		payment.Register(payment.NewLocalProvider())
+	}
+
+	return nil
+}
+
+// 設定結帳保留庫存時間並啟動清除過期保留的背景程序
+func SetupReservation(db *gorm.DB, rdb *redis.Client) error {
+	config, err := LoadConfig("config/config.yaml")
+	if err != nil {
+		return err
+	}
+
+	if config.Reservation.TTLMinutes > 0 {
+		reservation.SetTTL(time.Duration(config.Reservation.TTLMinutes) * time.Minute)
+	}
+
+	sweepInterval := 60 * time.Second
+	if config.Reservation.SweepIntervalSeconds > 0 {
+		sweepInterval = time.Duration(config.Reservation.SweepIntervalSeconds) * time.Second
+	}
+	go reservation.RunSweeper(db, rdb, sweepInterval)
+
+	return nil
+}
+
+// 啟動比對並修復資料庫與Redis商品快取的背景程序
+func SetupProductCache(db *gorm.DB, rdb *redis.Client) error {
+	config, err := LoadConfig("config/config.yaml")
+	if err != nil {
+		return err
+	}
+
+	reconcileInterval := 300 * time.Second
+	if config.ProductCache.ReconcileIntervalSeconds > 0 {
+		reconcileInterval = time.Duration(config.ProductCache.ReconcileIntervalSeconds) * time.Second
+	}
+	go cache.NewProductCache(db, rdb).RunReconciler(reconcileInterval)
+
+	return nil
+}
+
+// 載入JWT金鑰並啟動金鑰檔案的重新載入程序及過期登入的清除程序，登入快取使用rdb
+func SetupJWT(db *gorm.DB, rdb *redis.Client) error {
+	config, err := LoadConfig("config/config.yaml")
+	if err != nil {
+		return err
+	}
+
+	keyConfigs := make([]jwt.KeyConfig, len(config.JWT.Keys))
+	for i, key := range config.JWT.Keys {
+		keyConfigs[i] = jwt.KeyConfig{
+			ID:             key.ID,
+			PrivateKeyPath: key.PrivateKey,
+			PublicKeyPath:  key.PublicKey,
+		}
+	}
+	err = jwt.SetupKeys(keyConfigs, config.JWT.SigningKeyID)
+	if err != nil {
+		return err
+	}
+	jwt.SetupSessionCache(rdb)
+
+	reloadInterval := 30 * time.Second
+	if config.JWT.KeyReloadIntervalSeconds > 0 {
+		reloadInterval = time.Duration(config.JWT.KeyReloadIntervalSeconds) * time.Second
+	}
+	go jwt.RunKeyReloader(reloadInterval)
+
+	sweepInterval := 3600 * time.Second
+	if config.JWT.SessionSweepIntervalSeconds > 0 {
+		sweepInterval = time.Duration(config.JWT.SessionSweepIntervalSeconds) * time.Second
+	}
+	go jwt.RunSessionSweeper(db, sweepInterval)
+
+	return nil
+}
@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"Backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strings"
+)
+
+// 新增及修改分類的請求資料，修改時未提供的欄位保持不變
+type categoryReq struct {
+	Name        *string `json:"name"`
+	Slug        *string `json:"slug"`
+	Description *string `json:"description"`
+	SortOrder   *int    `json:"sortOrder"`
+}
+
+// 將請求資料套用至分類，代稱另外檢查
+func (req categoryReq) applyTo(category *models.Category) {
+	if req.Name != nil {
+		category.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Slug != nil {
+		category.Slug = *req.Slug
+	}
+	if req.Description != nil {
+		category.Description = *req.Description
+	}
+	if req.SortOrder != nil {
+		category.SortOrder = *req.SortOrder
+	}
+}
+
+// 鎖定所有分類後載入分類樹，避免同時移動或合併分類形成循環
+func lockCategoryTree(tx *gorm.DB) (*categoryTree, error) {
+	return loadCategoryTree(tx.Clauses(clause.Locking{Strength: "UPDATE"}))
+}
+
+// 新增分類，未指定代稱時由名稱產生，parentID為上層分類
+func CreateCategoryHandler(c *gin.Context, db *gorm.DB) {
+	var newCategoryReq struct {
+		categoryReq
+		ParentID *uint `json:"parentID"`
+	}
+	err := c.ShouldBindJSON(&newCategoryReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var category models.Category
+	newCategoryReq.applyTo(&category)
+	if category.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "分類名稱不得為空",
+		})
+		return
+	}
+
+	if newCategoryReq.ParentID != nil {
+		err = db.Select("id").First(&models.Category{}, *newCategoryReq.ParentID).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "查無上層分類",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "查詢上層分類失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+		category.ParentID = newCategoryReq.ParentID
+	}
+
+	if category.Slug == "" {
+		category.Slug, err = uniqueCategorySlug(db, category.Name, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "產生分類代稱失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	} else {
+		err, msg := checkCategorySlug(db, category.Slug, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": msg,
+				"error":   err.Error(),
+			})
+			return
+		}
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": msg,
+			})
+			return
+		}
+	}
+
+	err = db.Create(&category).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "新增分類失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "成功新增分類",
+		"category": category,
+	})
+}
+
+// 修改分類名稱、代稱、說明及排序，並更新含有此分類的商品快取
+func UpdateCategoryHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	categoryID := c.Param("categoryID")
+
+	var categoryDataReq categoryReq
+	err := c.ShouldBindJSON(&categoryDataReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var category models.Category
+	err = db.First(&category, categoryID).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此分類",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢分類失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	oldSlug := category.Slug
+	categoryDataReq.applyTo(&category)
+	if category.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "分類名稱不得為空",
+		})
+		return
+	}
+	if category.Slug != oldSlug {
+		err, msg := checkCategorySlug(db, category.Slug, category.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": msg,
+				"error":   err.Error(),
+			})
+			return
+		}
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": msg,
+			})
+			return
+		}
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	err = tx.
+		Model(&category).
+		Select("name", "slug", "description", "sort_order").
+		Updates(&category).
+		Error
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "修改分類失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//商品快取中含有分類資料
+	productIDs, err := touchCategoryProducts(tx, category.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "更新商品資料失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	refreshProductCache(c, db, rdb, productIDs...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "成功修改分類",
+		"category": category,
+	})
+}
+
+// 移動分類至其他上層分類，parentID為null時移至最上層，不可移至本身或其子分類之下
+func MoveCategoryHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	categoryID := c.Param("categoryID")
+
+	var moveReq struct {
+		ParentID  *uint `json:"parentID"`
+		SortOrder *int  `json:"sortOrder"`
+	}
+	err := c.ShouldBindJSON(&moveReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	tree, err := lockCategoryTree(tx)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取分類列表",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var category models.Category
+	err = tx.First(&category, categoryID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此分類",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢分類失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if moveReq.ParentID != nil {
+		if _, ok := tree.categories[*moveReq.ParentID]; !ok {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "查無上層分類",
+			})
+			return
+		}
+		if tree.isDescendant(*moveReq.ParentID, category.ID) {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "無法將分類移至其本身或子分類之下",
+			})
+			return
+		}
+	}
+
+	category.ParentID = moveReq.ParentID
+	if moveReq.SortOrder != nil {
+		category.SortOrder = *moveReq.SortOrder
+	}
+	err = tx.
+		Model(&category).
+		Select("parent_id", "sort_order").
+		Updates(&category).
+		Error
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "移動分類失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	productIDs, err := touchCategoryProducts(tx, category.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "更新商品資料失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	refreshProductCache(c, db, rdb, productIDs...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "成功移動分類",
+		"category": category,
+	})
+}
+
+// 將分類合併至targetID，商品及子分類移至目標分類後刪除原分類
+// 目標分類不可為原分類本身或其子分類
+func MergeCategoryHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	categoryID := c.Param("categoryID")
+
+	var mergeReq struct {
+		TargetID uint `json:"targetID" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&mergeReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	tree, err := lockCategoryTree(tx)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取分類列表",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var category models.Category
+	err = tx.First(&category, categoryID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此分類",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢分類失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	target, ok := tree.categories[mergeReq.TargetID]
+	if !ok {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "查無目標分類",
+		})
+		return
+	}
+	if tree.isDescendant(target.ID, category.ID) {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "無法將分類合併至其本身或子分類",
+		})
+		return
+	}
+
+	//原分類及子分類的商品快取都須更新，子分類的上層分類會改變
+	childIDs := tree.children[category.ID]
+	productIDs, err := touchCategoryProducts(tx, append([]uint{category.ID}, childIDs...)...)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "更新商品資料失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//已屬於目標分類的商品略過
+	err = tx.Exec(
+		"INSERT IGNORE INTO category_products (category_id, product_id) SELECT ?, product_id FROM category_products WHERE category_id = ?",
+		target.ID, category.ID,
+	).Error
+	if err == nil {
+		err = tx.Model(&category).Association("Products").Clear()
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "移動分類商品失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	err = tx.
+		Model(&models.Category{}).
+		Where("parent_id = ?", category.ID).
+		Update("parent_id", target.ID).
+		Error
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "移動子分類失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	err = tx.Delete(&category).Error
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "刪除分類失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	refreshProductCache(c, db, rdb, productIDs...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "成功合併分類",
+		"targetID": target.ID,
+	})
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"Backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// 新增及修改優惠券的請求資料，修改時未提供的欄位保持不變
+type couponReq struct {
+	Code         *string    `json:"code"`
+	Description  *string    `json:"description"`
+	Type         *string    `json:"type"`
+	Value        *uint      `json:"value"`
+	MaxDiscount  *uint      `json:"maxDiscount"`
+	MinSpend     *uint      `json:"minSpend"`
+	UsageLimit   *uint      `json:"usageLimit"`
+	PerUserLimit *uint      `json:"perUserLimit"`
+	StartsAt     *time.Time `json:"startsAt"`
+	EndsAt       *time.Time `json:"endsAt"`
+	Enabled      *bool      `json:"enabled"`
+}
+
+// 將請求資料套用至優惠券
+func (req couponReq) applyTo(coupon *models.Coupon) {
+	if req.Code != nil {
+		coupon.Code = normalizeCouponCode(*req.Code)
+	}
+	if req.Description != nil {
+		coupon.Description = *req.Description
+	}
+	if req.Type != nil {
+		coupon.Type = *req.Type
+	}
+	if req.Value != nil {
+		coupon.Value = *req.Value
+	}
+	if req.MaxDiscount != nil {
+		coupon.MaxDiscount = *req.MaxDiscount
+	}
+	if req.MinSpend != nil {
+		coupon.MinSpend = *req.MinSpend
+	}
+	if req.UsageLimit != nil {
+		coupon.UsageLimit = *req.UsageLimit
+	}
+	if req.PerUserLimit != nil {
+		coupon.PerUserLimit = *req.PerUserLimit
+	}
+	if req.StartsAt != nil {
+		coupon.StartsAt = req.StartsAt
+	}
+	if req.EndsAt != nil {
+		coupon.EndsAt = req.EndsAt
+	}
+	if req.Enabled != nil {
+		coupon.Enabled = *req.Enabled
+	}
+}
+
+// 檢查優惠券資料是否合法，回傳錯誤訊息
+func validateCoupon(coupon *models.Coupon) string {
+	if coupon.Code == "" {
+		return "優惠券代碼不得為空"
+	}
+	switch coupon.Type {
+	case models.CouponTypePercentage:
+		if coupon.Value < 1 || coupon.Value > 100 {
+			return "折扣百分比須介於1到100"
+		}
+	case models.CouponTypeFixedAmount:
+		if coupon.Value == 0 {
+			return "折抵金額須大於0"
+		}
+	case models.CouponTypeFreeShipping:
+	default:
+		return "優惠券類型錯誤"
+	}
+	if coupon.StartsAt != nil && coupon.EndsAt != nil && !coupon.EndsAt.After(*coupon.StartsAt) {
+		return "結束時間須晚於開始時間"
+	}
+	return ""
+}
+
+// 查詢優惠券列表
+func GetCouponListHandler(c *gin.Context, db *gorm.DB) {
+	var coupons []models.Coupon
+	err := db.Order("id DESC").Find(&coupons).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取優惠券列表",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功讀取優惠券列表",
+		"coupons": coupons,
+	})
+}
+
+// 查詢優惠券
+func GetCouponHandler(c *gin.Context, db *gorm.DB) {
+	couponID := c.Param("couponID")
+
+	var coupon models.Coupon
+	err := db.First(&coupon, couponID).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此優惠券",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢優惠券失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功查詢優惠券",
+		"coupon":  coupon,
+	})
+}
+
+// 新增優惠券
+func CreateCouponHandler(c *gin.Context, db *gorm.DB) {
+	var newCouponReq couponReq
+	err := c.ShouldBindJSON(&newCouponReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//新優惠券預設啟用
+	coupon := models.Coupon{
+		Enabled: true,
+	}
+	newCouponReq.applyTo(&coupon)
+
+	if msg := validateCoupon(&coupon); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": msg,
+		})
+		return
+	}
+
+	var count int64
+	err = db.Unscoped().Model(&models.Coupon{}).Where("code = ?", coupon.Code).Count(&count).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "檢查優惠券代碼失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "優惠券代碼已被使用",
+		})
+		return
+	}
+
+	err = db.Create(&coupon).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "新增優惠券失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "成功新增優惠券",
+		"coupon":  coupon,
+	})
+}
+
+// 修改優惠券
+func UpdateCouponHandler(c *gin.Context, db *gorm.DB) {
+	couponID := c.Param("couponID")
+
+	var couponDataReq couponReq
+	err := c.ShouldBindJSON(&couponDataReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var coupon models.Coupon
+	err = db.First(&coupon, couponID).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此優惠券",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢優惠券失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	couponDataReq.applyTo(&coupon)
+
+	if msg := validateCoupon(&coupon); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": msg,
+		})
+		return
+	}
+
+	var count int64
+	err = db.Unscoped().Model(&models.Coupon{}).Where("code = ? AND id <> ?", coupon.Code, coupon.ID).Count(&count).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "檢查優惠券代碼失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "優惠券代碼已被使用",
+		})
+		return
+	}
+
+	//使用次數由結帳時更新，避免覆蓋同時進行的結帳
+	err = db.Model(&coupon).Omit("used_count").Save(&coupon).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "修改優惠券失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功修改優惠券",
+		"coupon":  coupon,
+	})
+}
+
+// 刪除優惠券，已使用的紀錄仍保留於訂單
+func DeleteCouponHandler(c *gin.Context, db *gorm.DB) {
+	couponID := c.Param("couponID")
+
+	result := db.Delete(&models.Coupon{}, couponID)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "刪除優惠券失敗",
+			"error":   result.Error.Error(),
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "查無此優惠券",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功刪除優惠券",
+	})
+}
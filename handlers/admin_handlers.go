@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"Backend/models"
-	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
@@ -11,7 +10,6 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -72,7 +70,12 @@ func GetProductAllDataHandler(c *gin.Context, db *gorm.DB) {
 	productID := c.Param("productID")
 
 	var product models.Product
-	err := db.Preload("Categories").Find(&product, productID).Error
+	err := db.
+		Preload("Categories").
+		Preload("Variants").
+		Preload("Images", orderProductImages).
+		Find(&product, productID).
+		Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "查詢商品資料失敗",
@@ -105,7 +108,6 @@ func UploadImageHandler(c *gin.Context) {
 		return
 	}
 
-	uploadsDir := "./uploads"
 	//檢查uploads資料夾是否存在，如不存在則創建
 	_, err = os.Stat(uploadsDir)
 	if err != nil {
@@ -144,12 +146,14 @@ func UploadImageHandler(c *gin.Context) {
 
 func CreateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 	var newProduct struct {
-		Name        string   `json:"name" binding:"required"`
-		Price       uint     `json:"price" binding:"required"`
-		Stock       uint     `json:"stock" binding:"required"`
-		ImageURL    string   `json:"imageURL" binding:"required"`
-		Description string   `json:"description"`
-		Categories  []string `json:"categories"`
+		SKU         string              `json:"sku"`
+		Name        string              `json:"name" binding:"required"`
+		Price       uint                `json:"price" binding:"required"`
+		Stock       uint                `json:"stock"`
+		ImageURL    string              `json:"imageURL" binding:"required"`
+		Description string              `json:"description"`
+		Categories  []string            `json:"categories"`
+		Variants    []productVariantReq `json:"variants" binding:"dive"`
 	}
 	err := c.ShouldBindJSON(&newProduct)
 	if err != nil {
@@ -160,33 +164,45 @@ func CreateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
-	//查詢已存在的標籤
-	var mergeCategories []models.Category
-	err = db.
-		Model(&models.Category{}).
-		Where("Name IN ?", newProduct.Categories).
-		Find(&mergeCategories).
-		Error
+	//有規格的商品庫存為規格庫存總和，沒有規格時必須提供庫存
+	if len(newProduct.Variants) == 0 && newProduct.Stock == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "商品庫存不得為空",
+		})
+		return
+	}
+
+	err, msg := validateProductVariants(db, 0, newProduct.Variants)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "查詢標籤失敗",
+			"message": msg,
 			"error":   err.Error(),
 		})
 		return
 	}
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": msg,
+		})
+		return
+	}
 
-	//將尚未建立之標籤加入mergeCategories
-	for _, categoryName := range newProduct.Categories {
-		exists := false
-		for _, mergeCategory := range mergeCategories {
-			if categoryName == mergeCategory.Name {
-				exists = true
-			}
+	//SKU為選填，提供時不可與其他商品重複
+	newProduct.SKU = strings.TrimSpace(newProduct.SKU)
+	if newProduct.SKU != "" {
+		err, msg := checkProductSKU(db, newProduct.SKU, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": msg,
+				"error":   err.Error(),
+			})
+			return
 		}
-		if !exists {
-			mergeCategories = append(mergeCategories, models.Category{
-				Name: categoryName,
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": msg,
 			})
+			return
 		}
 	}
 
@@ -196,7 +212,17 @@ func CreateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		Stock:       newProduct.Stock,
 		ImageURL:    newProduct.ImageURL,
 		Description: newProduct.Description,
-		Categories:  mergeCategories,
+		Variants:    newProductVariants(newProduct.Variants),
+		Images: []models.ProductImage{{
+			URL:       newProduct.ImageURL,
+			IsPrimary: true,
+		}},
+	}
+	if len(product.Variants) > 0 {
+		product.Stock = sumVariantStock(product.Variants)
+	}
+	if newProduct.SKU != "" {
+		product.SKU = &newProduct.SKU
 	}
 
 	tx := db.Begin()
@@ -214,34 +240,22 @@ func CreateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
-	err = tx.Create(&product).Error
+	//查詢已存在的標籤，不存在的標籤以最上層分類建立
+	product.Categories, err = findOrCreateCategories(tx, newProduct.Categories)
 	if err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "新增商品失敗",
-			"error":   err.Error(),
-		})
-		return
-	}
-
-	productJSON, err := json.Marshal(product)
-	if err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "無法序列化商品資料",
+			"message": "查詢標籤失敗",
 			"error":   err.Error(),
 		})
 		return
 	}
 
-	err = rdb.ZAdd(c, "products", redis.Z{
-		Score:  float64(product.ID),
-		Member: productJSON,
-	}).Err()
+	err = tx.Create(&product).Error
 	if err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "無法將商品資料加入Redis",
+			"message": "新增商品失敗",
 			"error":   err.Error(),
 		})
 		return
@@ -256,6 +270,8 @@ func CreateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
+	refreshProductCache(c, db, rdb, product.ID)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "成功新增商品",
 		"product": product,
@@ -266,12 +282,16 @@ func UpdateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 	productID := c.Param("productID")
 
 	var productDataReq struct {
+		//空字串代表移除SKU
+		SKU         *string  `json:"sku"`
 		Name        *string  `json:"name"`
 		Price       *uint    `json:"price"`
 		Stock       *uint    `json:"stock"`
 		ImageURL    *string  `json:"imageURL"`
 		Description *string  `json:"description"`
 		Categories  []string `json:"categories"`
+		//提供時取代商品的所有規格，空陣列代表移除所有規格
+		Variants *[]productVariantReq `json:"variants" binding:"omitempty,dive"`
 	}
 	err := c.ShouldBind(&productDataReq)
 	if err != nil {
@@ -291,31 +311,80 @@ func UpdateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
-	if len(productDataReq.Categories) > 0 {
-		err = db.Model(&product).Association("Categories").Clear()
+	if productDataReq.Variants != nil {
+		err, msg := validateProductVariants(db, product.ID, *productDataReq.Variants)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
+				"message": msg,
+				"error":   err.Error(),
+			})
+			return
+		}
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": msg,
 			})
 			return
 		}
+	}
 
-		//查詢每個標籤，如不存在就創建
-		var categories []models.Category
-		for _, categoryName := range productDataReq.Categories {
-			var category models.Category
-			err = db.
-				Model(&models.Category{}).
-				Where("Name = ?", categoryName).
-				FirstOrCreate(&category).
-				Error
+	if productDataReq.SKU != nil {
+		sku := strings.TrimSpace(*productDataReq.SKU)
+		if sku == "" {
+			product.SKU = nil
+		} else {
+			err, msg := checkProductSKU(db, sku, product.ID)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": err.Error(),
+					"message": msg,
+					"error":   err.Error(),
+				})
+				return
+			}
+			if msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": msg,
 				})
 				return
 			}
-			categories = append(categories, category)
+			product.SKU = &sku
+		}
+	}
+
+	//標籤的變更與商品資料在同一個事務中，提交失敗時不會留下已清除的標籤或新建的標籤
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	if len(productDataReq.Categories) > 0 {
+		err = tx.Model(&product).Association("Categories").Clear()
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		//查詢每個標籤，如不存在就創建
+		categories, err := findOrCreateCategories(tx, productDataReq.Categories)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
 		}
 
 		product.Categories = categories
@@ -337,21 +406,6 @@ func UpdateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		product.Description = *productDataReq.Description
 	}
 
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	if tx.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "開啟資料庫事務失敗",
-			"error":   tx.Error.Error(),
-		})
-		return
-	}
-
 	result := tx.Save(&product)
 	err = result.Error
 	if err != nil {
@@ -362,16 +416,38 @@ func UpdateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
-	err, msg := UpdateProductToRedis(c, rdb, &product)
+	if productDataReq.Variants != nil {
+		err = syncProductVariants(tx, &product, *productDataReq.Variants)
+	} else {
+		err = tx.Where("product_id = ?", product.ID).Order("id ASC").Find(&product.Variants).Error
+		if err == nil && len(product.Variants) > 0 {
+			//有規格的商品庫存不可直接修改
+			product.Stock = sumVariantStock(product.Variants)
+			err = tx.Model(&product).Update("stock", product.Stock).Error
+		}
+	}
 	if err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": msg,
+			"message": "修改商品規格失敗",
 			"error":   err.Error(),
 		})
 		return
 	}
 
+	//直接修改圖片網址時同步設為主圖
+	if productDataReq.ImageURL != nil && product.ImageURL != "" {
+		err = setPrimaryProductImageByURL(tx, &product, product.ImageURL)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "設定商品主圖失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -381,6 +457,8 @@ func UpdateProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
+	refreshProductCache(c, db, rdb, product.ID)
+
 	if result.RowsAffected == 0 {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "沒有變更資料",
@@ -458,28 +536,26 @@ func DeleteProductHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
-	score := strconv.Itoa(int(product.ID))
-
-	err = rdb.ZRemRangeByScore(c, "products", score, score).Err()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "已從資料庫刪除商品資料，但從Redis刪除商品失敗",
-			"error":   err.Error(),
-		})
-		return
-	}
+	refreshProductCache(c, db, rdb, product.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "成功刪除商品",
 	})
 }
 
-func DeleteCategoryHandler(c *gin.Context, db *gorm.DB) {
+// 刪除商品標籤，子分類移至被刪除分類的上層分類，並更新原本含有此標籤及子分類的商品快取及標籤索引
+func DeleteCategoryHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 	categoryID := c.Param("categoryID")
 
 	var category models.Category
 	err := db.First(&category, categoryID).Error
 	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此分類",
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "查詢商品標籤失敗",
 			"error":   err.Error(),
@@ -487,8 +563,50 @@ func DeleteCategoryHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	err = db.Model(&category).Association("Products").Clear()
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	var childIDs []uint
+	err = tx.
+		Model(&models.Category{}).
+		Where("parent_id = ?", category.ID).
+		Pluck("id", &childIDs).
+		Error
 	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢子分類失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//標籤關聯不會更新商品，刪除關聯前先更新快取版本並記錄含有此標籤的商品
+	productIDs, err := touchCategoryProducts(tx, append([]uint{category.ID}, childIDs...)...)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "更新商品資料失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	err = tx.Model(&category).Association("Products").Clear()
+	if err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "刪除商品標籤關聯失敗",
 			"error":   err.Error(),
@@ -496,14 +614,44 @@ func DeleteCategoryHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	err = db.Delete(&category).Error
+	if len(childIDs) > 0 {
+		err = tx.
+			Model(&models.Category{}).
+			Where("id IN ?", childIDs).
+			Update("parent_id", category.ParentID).
+			Error
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "移動子分類失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	err = tx.Delete(&category).Error
 	if err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "刪除標籤失敗",
 			"error":   err.Error(),
 		})
 		return
 	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//商品快取更新時會將商品從此標籤的索引中移除
+	refreshProductCache(c, db, rdb, productIDs...)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "成功刪除標籤",
 	})
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"Backend/catalog"
+	"Backend/models"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"testing"
+)
+
+// 修改商品失敗時，商品原本的標籤仍然存在且不會建立新的標籤
+func TestUpdateProductRollsBackCategories(t *testing.T) {
+	db, rdb := newTestStore(t)
+	category := models.Category{Name: "電子", Slug: "electronics"}
+	if err := db.Create(&category).Error; err != nil {
+		t.Fatalf("create category: %v", err)
+	}
+	product := createTestProduct(t, db, "Cable", 300, 10)
+	if err := db.Model(&product).Association("Categories").Append(&category); err != nil {
+		t.Fatalf("append category: %v", err)
+	}
+
+	//標籤變更後儲存商品時失敗
+	err := db.Callback().Update().Before("gorm:update").Register("test:fail_product_update", func(tx *gorm.DB) {
+		if tx.Statement.Table == "products" {
+			tx.AddError(errors.New("update failed"))
+		}
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	recorder := performRequest(t, func(c *gin.Context) { UpdateProductHandler(c, db, rdb) }, 1,
+		gin.H{"name": "USB Cable", "categories": []string{"配件"}},
+		gin.Param{Key: "productID", Value: fmt.Sprint(product.ID)})
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var stored models.Product
+	db.Preload("Categories").First(&stored, product.ID)
+	if stored.Name != "Cable" {
+		t.Errorf("name = %q, should not be changed", stored.Name)
+	}
+	if len(stored.Categories) != 1 || stored.Categories[0].ID != category.ID {
+		t.Errorf("categories = %+v, want only category %d", stored.Categories, category.ID)
+	}
+	var created int64
+	db.Model(&models.Category{}).Where("name = ?", "配件").Count(&created)
+	if created != 0 {
+		t.Errorf("new category should not be created, found %d", created)
+	}
+}
+
+// 新增、修改及刪除商品時更新Redis中標籤的商品集合，標籤商品查詢不需重建索引
+func TestProductHandlersMaintainCategoryIndex(t *testing.T) {
+	db, rdb := newTestStore(t)
+	ctx := context.Background()
+
+	//先建立空的索引
+	categoryProducts := func(categoryID uint) []interface{} {
+		t.Helper()
+		recorder := performGet(t, func(c *gin.Context) { GetProductsFromCategoriesHandler(c, db, rdb) },
+			fmt.Sprintf("/products/categories?categories=%d", categoryID), nil)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("category products status = %d, body = %s", recorder.Code, recorder.Body.String())
+		}
+		products, _ := decodeResponse(t, recorder)["products"].([]interface{})
+		return products
+	}
+	categoryProducts(1)
+	if ready, err := catalog.IsReady(ctx, rdb); err != nil || !ready {
+		t.Fatalf("IsReady = %v, %v, want ready", ready, err)
+	}
+
+	recorder := performRequest(t, func(c *gin.Context) { CreateProductHandler(c, db, rdb) }, 1, gin.H{
+		"name":       "Cable",
+		"price":      300,
+		"stock":      10,
+		"imageURL":   "/images/cable",
+		"categories": []string{"電子"},
+	})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	var product models.Product
+	db.Preload("Categories").First(&product)
+	electronics := product.Categories[0].ID
+	if count := rdb.SCard(ctx, catalog.CategoryKey(electronics)).Val(); count != 1 {
+		t.Errorf("category set size after create = %d, want 1", count)
+	}
+	if products := categoryProducts(electronics); len(products) != 1 {
+		t.Errorf("category products after create = %v, want 1 product", products)
+	}
+
+	recorder = performRequest(t, func(c *gin.Context) { UpdateProductHandler(c, db, rdb) }, 1,
+		gin.H{"categories": []string{"配件"}},
+		gin.Param{Key: "productID", Value: fmt.Sprint(product.ID)})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("update status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	var accessories models.Category
+	db.Where("name = ?", "配件").First(&accessories)
+	if count := rdb.SCard(ctx, catalog.CategoryKey(electronics)).Val(); count != 0 {
+		t.Errorf("old category set size after update = %d, want 0", count)
+	}
+	if products := categoryProducts(accessories.ID); len(products) != 1 {
+		t.Errorf("new category products after update = %v, want 1 product", products)
+	}
+
+	recorder = performRequest(t, func(c *gin.Context) { DeleteProductHandler(c, db, rdb) }, 1, nil,
+		gin.Param{Key: "productID", Value: fmt.Sprint(product.ID)})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if count := rdb.SCard(ctx, catalog.CategoryKey(accessories.ID)).Val(); count != 0 {
+		t.Errorf("category set size after delete = %d, want 0", count)
+	}
+	if products := categoryProducts(accessories.ID); len(products) != 0 {
+		t.Errorf("category products after delete = %v, want none", products)
+	}
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"Backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 查詢所有訂單列表，可依狀態、使用者及建立日期篩選
+func GetAdminOrderListHandler(c *gin.Context, db *gorm.DB) {
+	limitInt, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limitInt < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "查詢數量輸入錯誤",
+		})
+		return
+	}
+	//限制最高查詢數量為50
+	if limitInt > 50 {
+		limitInt = 50
+	}
+
+	offsetInt, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offsetInt < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "offset輸入錯誤",
+		})
+		return
+	}
+
+	query := db.Model(&models.Order{})
+
+	if status := c.Query("status"); status != "" {
+		if !models.IsValidOrderStatus(status) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "訂單狀態輸入錯誤",
+			})
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	if userID := c.Query("userID"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	//日期格式為2006-01-02，to包含當天
+	if from := c.Query("from"); from != "" {
+		fromTime, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "起始日期輸入錯誤",
+				"error":   err.Error(),
+			})
+			return
+		}
+		query = query.Where("created_at >= ?", fromTime)
+	}
+	if to := c.Query("to"); to != "" {
+		toTime, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "結束日期輸入錯誤",
+				"error":   err.Error(),
+			})
+			return
+		}
+		query = query.Where("created_at < ?", toTime.AddDate(0, 0, 1))
+	}
+
+	var totalCount int64
+	err = query.Count(&totalCount).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢訂單數量失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var orders []models.Order
+	err = query.
+		Order("id DESC").
+		Limit(limitInt).
+		Offset(offsetInt).
+		Find(&orders).
+		Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢訂單列表失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var orderList []gin.H
+	for _, order := range orders {
+		orderList = append(orderList, gin.H{
+			"OrderID":        order.ID,
+			"UserID":         order.UserID,
+			"OrderTime":      order.CreatedAt,
+			"ShippingMethod": order.ShippingMethod,
+			"Total":          order.Total,
+			"Status":         order.Status,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "成功查詢訂單列表",
+		"orderList":  orderList,
+		"totalCount": totalCount,
+	})
+}
+
+// 查詢訂單詳細資訊及狀態紀錄
+func GetAdminOrderDataHandler(c *gin.Context, db *gorm.DB) {
+	orderID := c.Param("orderID")
+
+	var order models.Order
+	err := db.
+		Preload("OrderItems").
+		Preload("OrderItems.Product", func(db *gorm.DB) *gorm.DB {
+			return db.Unscoped()
+		}).
+		Preload("Payments").
+		Preload("StatusHistory", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id ASC")
+		}).
+		First(&order, orderID).
+		Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此訂單",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢訂單失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var orderItemsData []gin.H
+	for _, orderItem := range order.OrderItems {
+		name, unitPrice, imageURL := orderItem.Snapshot()
+		orderItemsData = append(orderItemsData, gin.H{
+			"ProductID":   orderItem.ProductID,
+			"VariantID":   orderItem.VariantID,
+			"SKU":         orderItem.SKU,
+			"OptionLabel": orderItem.OptionLabel,
+			"Name":        name,
+			"Price":       unitPrice,
+			"ImageURL":    imageURL,
+			"Quantity":    orderItem.Quantity,
+			"Subtotal":    orderItem.LineTotal(),
+		})
+	}
+
+	var statusHistory []gin.H
+	for _, history := range order.StatusHistory {
+		statusHistory = append(statusHistory, gin.H{
+			"FromStatus":    history.FromStatus,
+			"ToStatus":      history.ToStatus,
+			"ChangedBy":     history.ChangedBy,
+			"ChangedByRole": history.ChangedByRole,
+			"Note":          history.Note,
+			"ChangedAt":     history.CreatedAt,
+		})
+	}
+
+	var payments []gin.H
+	for _, orderPayment := range order.Payments {
+		payments = append(payments, gin.H{
+			"PaymentID":     orderPayment.ID,
+			"Provider":      orderPayment.Provider,
+			"TradeNo":       orderPayment.TradeNo,
+			"TransactionID": orderPayment.TransactionID,
+			"Amount":        orderPayment.Amount,
+			"Status":        orderPayment.Status,
+			"PaidAt":        orderPayment.PaidAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "成功查詢訂單",
+		"OrderID":        order.ID,
+		"UserID":         order.UserID,
+		"Name":           order.Name,
+		"Address":        order.Address,
+		"Phone":          order.Phone,
+		"ShippingMethod": order.ShippingMethod,
+		"Subtotal":       order.Subtotal,
+		"CouponCode":     order.CouponCode,
+		"Discount":       order.Discount,
+		"ShippingFee":    order.ShippingFee,
+		"Total":          order.Total,
+		"OrderTime":      order.CreatedAt,
+		"Status":         order.Status,
+		"NextStatuses":   models.NextOrderStatuses(order.Status, models.OrderActorAdmin),
+		"orderItemsData": orderItemsData,
+		"payments":       payments,
+		"statusHistory":  statusHistory,
+	})
+}
+
+// 變更訂單狀態，取消訂單時將商品數量加回庫存並歸還優惠券
+func UpdateOrderStatusHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	orderID := c.Param("orderID")
+	adminID, ok := c.Get("UserID")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法取得使用者ID",
+		})
+		return
+	}
+
+	var statusReq struct {
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
+	}
+	err := c.ShouldBindJSON(&statusReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if !models.IsValidOrderStatus(statusReq.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "訂單狀態輸入錯誤",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	var order models.Order
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("OrderItems").
+		First(&order, orderID).
+		Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此訂單",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢訂單失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	fromStatus := order.Status
+	err = changeOrderStatus(tx, &order, statusReq.Status, adminID.(uint), models.OrderActorAdmin, statusReq.Note)
+	if err != nil {
+		tx.Rollback()
+		if err == ErrInvalidOrderStatusTransition {
+			c.JSON(http.StatusConflict, gin.H{
+				"message":      err.Error(),
+				"status":       fromStatus,
+				"nextStatuses": models.NextOrderStatuses(fromStatus, models.OrderActorAdmin),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "更新訂單狀態失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if order.Status == models.OrderStatusCancelled {
+		err, msg := releaseCancelledOrder(tx, &order)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": msg,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if order.Status == models.OrderStatusCancelled {
+		refreshProductCache(c, db, rdb, orderItemProductIDs(&order)...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "成功變更訂單狀態",
+		"fromStatus": fromStatus,
+		"status":     order.Status,
+	})
+}
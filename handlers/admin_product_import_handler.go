@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"Backend/models"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// 匯入檔案的大小上限
+const maxImportFileSize = 10 << 20
+
+// 依查詢參數format決定檔案格式，未指定時依副檔名判斷
+func productFileFormat(c *gin.Context, filename string) string {
+	format := strings.ToLower(c.Query("format"))
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+	if format != productFileCSV && format != productFileJSON {
+		return ""
+	}
+	return format
+}
+
+// 以CSV或JSON檔案(表單欄位file)批次新增或更新商品，dryRun=true時只檢查不寫入
+// 所有資料在同一個事務中處理，每筆資料使用各自的savepoint，失敗的資料不影響其他資料
+func ImportProductsHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	dryRun := c.Query("dryRun") == "true"
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定匯入檔案失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "匯入檔案不得超過10MB",
+		})
+		return
+	}
+	format := productFileFormat(c, fileHeader.Filename)
+	if format == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "只支援csv及json格式",
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取匯入檔案",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	var rows []parsedImportRow
+	var msg string
+	if format == productFileCSV {
+		rows, msg = parseProductCSV(file)
+	} else {
+		rows, msg = parseProductJSON(file)
+	}
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": msg,
+		})
+		return
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	//同一個商品在檔案中只能出現一次
+	seenSKUs := make(map[string]int)
+	seenIDs := make(map[uint]int)
+	results := make([]productImportResult, 0, len(rows))
+	var productIDs []uint
+	counts := map[string]int{}
+	for _, row := range rows {
+		result := productImportResult{Row: row.Row, SKU: row.Data.SKU, Error: row.Err}
+		if result.Error == "" && row.Data.SKU != "" && seenSKUs[row.Data.SKU] != 0 {
+			result.Error = fmt.Sprintf("sku與第%d筆重複", seenSKUs[row.Data.SKU])
+		}
+		if result.Error == "" && row.Data.ID != 0 && seenIDs[row.Data.ID] != 0 {
+			result.Error = fmt.Sprintf("id與第%d筆重複", seenIDs[row.Data.ID])
+		}
+		if result.Error != "" {
+			counts["failed"]++
+			results = append(results, result)
+			continue
+		}
+
+		savepoint := fmt.Sprintf("import_row_%d", row.Row)
+		err = tx.SavePoint(savepoint).Error
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "建立savepoint失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		product, action, err, msg := importProductRow(tx, row.Data)
+		if err != nil || msg != "" {
+			if err != nil {
+				msg = msg + ": " + err.Error()
+			}
+			err = tx.RollbackTo(savepoint).Error
+			if err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "還原savepoint失敗",
+					"error":   err.Error(),
+				})
+				return
+			}
+			result.Error = msg
+			counts["failed"]++
+			results = append(results, result)
+			continue
+		}
+
+		if product.SKU != nil {
+			seenSKUs[*product.SKU] = row.Row
+		}
+		seenIDs[product.ID] = row.Row
+		result.ProductID = product.ID
+		result.Action = action
+		counts[action]++
+		productIDs = append(productIDs, product.ID)
+		results = append(results, result)
+	}
+
+	if dryRun {
+		tx.Rollback()
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "檢查完成，未寫入任何資料",
+			"dryRun":   true,
+			"created":  counts["created"],
+			"updated":  counts["updated"],
+			"restored": counts["restored"],
+			"failed":   counts["failed"],
+			"results":  results,
+		})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	refreshProductCache(c, db, rdb, productIDs...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "匯入完成",
+		"dryRun":   false,
+		"created":  counts["created"],
+		"updated":  counts["updated"],
+		"restored": counts["restored"],
+		"failed":   counts["failed"],
+		"results":  results,
+	})
+}
+
+// 以CSV或JSON(format=csv|json，預設csv)匯出所有商品，格式與匯入相同
+// 分批從資料庫讀取並直接寫入回應，不會一次載入所有商品
+func ExportProductsHandler(c *gin.Context, db *gorm.DB) {
+	format := strings.ToLower(c.DefaultQuery("format", productFileCSV))
+	if format != productFileCSV && format != productFileJSON {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "只支援csv及json格式",
+		})
+		return
+	}
+
+	filename := "products." + format
+	if format == productFileCSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "application/json; charset=utf-8")
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	var csvWriter *csv.Writer
+	first := true
+	if format == productFileCSV {
+		csvWriter = csv.NewWriter(c.Writer)
+		csvWriter.Write(productCSVHeader)
+	} else {
+		c.Writer.WriteString("[")
+	}
+
+	//回應已開始傳送，發生錯誤時只能記錄並中止
+	var writeErr error
+	var products []models.Product
+	err := db.
+		Preload("Categories", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id ASC")
+		}).
+		FindInBatches(&products, 500, func(tx *gorm.DB, batch int) error {
+			for i := range products {
+				row := newProductImportRow(&products[i])
+				if csvWriter != nil {
+					writeErr = csvWriter.Write(row.csvRecord())
+				} else {
+					var rowJSON []byte
+					rowJSON, writeErr = json.Marshal(row)
+					if writeErr == nil {
+						if !first {
+							c.Writer.WriteString(",")
+						}
+						_, writeErr = c.Writer.Write(rowJSON)
+					}
+				}
+				if writeErr != nil {
+					return writeErr
+				}
+				first = false
+			}
+			if csvWriter != nil {
+				csvWriter.Flush()
+				writeErr = csvWriter.Error()
+			}
+			c.Writer.Flush()
+			return writeErr
+		}).
+		Error
+	if err != nil {
+		log.Printf("匯出商品失敗: %v\n", err)
+		c.Abort()
+		return
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+	} else {
+		c.Writer.WriteString("]")
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"Backend/jwt"
+	"Backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+// 依路徑參數userID查詢使用者是否存在，失敗時已回應錯誤
+func findSessionUser(c *gin.Context, db *gorm.DB) (uint, bool) {
+	userID, err := strconv.ParseUint(c.Param("userID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "使用者ID輸入錯誤",
+		})
+		return 0, false
+	}
+
+	var count int64
+	err = db.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢使用者失敗",
+			"error":   err.Error(),
+		})
+		return 0, false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "查無此使用者",
+		})
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// 查詢使用者所有有效的登入
+func GetUserSessionListHandler(c *gin.Context, db *gorm.DB) {
+	userID, ok := findSessionUser(c, db)
+	if !ok {
+		return
+	}
+
+	sessions, err := jwt.ListSessions(db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢登入列表失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//標記管理員自己目前的登入
+	_, currentSessionID, _ := currentSession(c)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "成功查詢登入列表",
+		"userID":   userID,
+		"sessions": sessionsData(sessions, currentSessionID),
+	})
+}
+
+// 撤銷使用者的一個登入
+func RevokeUserSessionHandler(c *gin.Context, db *gorm.DB) {
+	userID, ok := findSessionUser(c, db)
+	if !ok {
+		return
+	}
+	sessionID, err := strconv.ParseUint(c.Param("sessionID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "登入ID輸入錯誤",
+		})
+		return
+	}
+
+	found, err := jwt.RevokeSession(c, db, userID, uint(sessionID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "撤銷登入失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "找不到此登入或已撤銷",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功撤銷登入",
+	})
+}
+
+// 撤銷使用者的所有登入
+func RevokeAllUserSessionsHandler(c *gin.Context, db *gorm.DB) {
+	userID, ok := findSessionUser(c, db)
+	if !ok {
+		return
+	}
+
+	count, err := jwt.RevokeUserSessions(c, db, userID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "撤銷登入失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "成功撤銷登入",
+		"userID":       userID,
+		"revokedCount": count,
+	})
+}
+
+// 變更使用者的角色，使用者目前的Access Token隨即失效，換發後使用新的角色
+func UpdateUserRoleHandler(c *gin.Context, db *gorm.DB) {
+	userID, ok := findSessionUser(c, db)
+	if !ok {
+		return
+	}
+
+	var roleReq struct {
+		Role string `json:"role" binding:"required,oneof=user admin"`
+	}
+	err := c.ShouldBindJSON(&roleReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	err = jwt.ChangeUserRole(c, db, userID, roleReq.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "變更角色失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功變更角色",
+		"userID":  userID,
+		"role":    roleReq.Role,
+	})
+}
@@ -36,6 +36,7 @@ func setAnonymousCartID(c *gin.Context, cartID string) {
 func AddToCartHandler(c *gin.Context, db *gorm.DB) {
 	var cartItemReq struct {
 		ProductID uint
+		VariantID *uint
 		Quantity  uint
 	}
 	err := c.BindJSON(&cartItemReq)
@@ -87,26 +88,19 @@ func AddToCartHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	//查詢商品庫存數量
-	var productStock uint
-	err = db.
-		Model(&models.Product{}).
-		Select("Stock").
-		Where("id = ?", cartItemReq.ProductID).
-		First(&productStock).
-		Error
+	//查詢商品可購買數量(扣除其他使用者結帳中保留的數量)
+	key := stockKey{ProductID: cartItemReq.ProductID, VariantID: variantIDValue(cartItemReq.VariantID)}
+	productStock, err := getAvailableStock(db, key, currentUserID(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "查詢商品庫存錯誤",
-			"error":   err.Error(),
-		})
+		respondStockUnitError(c, err)
 		return
 	}
 
 	//新增商品至購物車
 	var cartItem models.CartItem
 	err = db.
-		Where("product_id = ? AND cart_id = ?", cartItemReq.ProductID, cart.ID).
+		Where("cart_id = ?", cart.ID).
+		Scopes(matchStockKeys([]stockKey{key})).
 		First(&cartItem).
 		Error
 	if err != nil {
@@ -118,6 +112,7 @@ func AddToCartHandler(c *gin.Context, db *gorm.DB) {
 			err := db.Create(&models.CartItem{
 				CartID:    cart.ID,
 				ProductID: cartItemReq.ProductID,
+				VariantID: variantIDPtr(key.VariantID),
 				Quantity:  cartItemReq.Quantity,
 			}).Error
 			if err != nil {
@@ -131,6 +126,7 @@ func AddToCartHandler(c *gin.Context, db *gorm.DB) {
 			c.JSON(http.StatusOK, gin.H{
 				"message":   "成功新增物品至購物車",
 				"productID": cartItemReq.ProductID,
+				"variantID": cartItemReq.VariantID,
 				"Quantity":  cartItemReq.Quantity,
 			})
 			return
@@ -151,6 +147,7 @@ func AddToCartHandler(c *gin.Context, db *gorm.DB) {
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "成功更新購物車物品數量",
 		"productID": cartItem.ProductID,
+		"variantID": cartItem.VariantID,
 		"Quantity":  cartItem.Quantity,
 	})
 	return
@@ -160,6 +157,7 @@ func AddToCartHandler(c *gin.Context, db *gorm.DB) {
 func UpdateCartItemQuantityHandler(c *gin.Context, db *gorm.DB) {
 	var cartItemReq struct {
 		ProductID uint
+		VariantID *uint
 		Quantity  uint
 	}
 	err := c.BindJSON(&cartItemReq)
@@ -214,9 +212,11 @@ func UpdateCartItemQuantityHandler(c *gin.Context, db *gorm.DB) {
 	}
 
 	//查詢購物車商品
+	key := stockKey{ProductID: cartItemReq.ProductID, VariantID: variantIDValue(cartItemReq.VariantID)}
 	var cartItem models.CartItem
 	err = db.
-		Where("product_id = ? AND cart_id = ?", cartItemReq.ProductID, cart.ID).
+		Where("cart_id = ?", cart.ID).
+		Scopes(matchStockKeys([]stockKey{key})).
 		First(&cartItem).
 		Error
 	if err != nil {
@@ -233,9 +233,16 @@ func UpdateCartItemQuantityHandler(c *gin.Context, db *gorm.DB) {
 			return
 		}
 	}
-	//如果請求減少的數量大於庫存則更新為庫存數量
-	if cartItemReq.Quantity > cartItem.Product.Stock {
-		cartItem.Quantity = cartItem.Product.Stock
+	//查詢商品可購買數量(扣除其他使用者結帳中保留的數量)
+	productStock, err := getAvailableStock(db, key, currentUserID(c))
+	if err != nil {
+		respondStockUnitError(c, err)
+		return
+	}
+
+	//如果請求的數量大於可購買數量則更新為可購買數量
+	if cartItemReq.Quantity > productStock {
+		cartItem.Quantity = productStock
 	} else {
 		cartItem.Quantity = cartItemReq.Quantity
 	}
@@ -243,6 +250,7 @@ func UpdateCartItemQuantityHandler(c *gin.Context, db *gorm.DB) {
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "成功減少購物車物品數量",
 		"productID": cartItem.ProductID,
+		"variantID": cartItem.VariantID,
 		"Quantity":  cartItem.Quantity,
 	})
 	return
@@ -287,10 +295,13 @@ func DeleteCartItemHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	//刪除購物車商品
+	//刪除購物車商品，有規格的商品以variantID指定要刪除的規格
+	query = db.Where("product_id = ? AND cart_id = ?", productID, cart.ID)
+	if variantID := c.Query("variantID"); variantID != "" {
+		query = query.Where("variant_id = ?", variantID)
+	}
 	var cartItem models.CartItem
-	err = db.
-		Where("product_id = ? AND cart_id = ?", productID, cart.ID).
+	err = query.
 		Delete(&cartItem).
 		Error
 	if err != nil {
@@ -339,6 +350,7 @@ func MergeCartHandler(c *gin.Context, db *gorm.DB) {
 		Where("anonymous_cart_uuid = ?", anonymousCartID).
 		Preload("CartItems").
 		Preload("CartItems.Product").
+		Preload("CartItems.Variant").
 		First(&anonymousCart).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -353,6 +365,7 @@ func MergeCartHandler(c *gin.Context, db *gorm.DB) {
 		Where("user_id = ?", userID).
 		Preload("CartItems").
 		Preload("CartItems.Product").
+		Preload("CartItems.Variant").
 		First(&cart).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -360,6 +373,7 @@ func MergeCartHandler(c *gin.Context, db *gorm.DB) {
 			for i, anonCartItem := range anonymousCart.CartItems {
 				cartItems[i].CartID = cart.ID
 				cartItems[i].ProductID = anonCartItem.ProductID
+				cartItems[i].VariantID = anonCartItem.VariantID
 				cartItems[i].Quantity = anonCartItem.Quantity
 			}
 			cart = models.Cart{
@@ -386,11 +400,15 @@ func MergeCartHandler(c *gin.Context, db *gorm.DB) {
 		for _, anonCartItem := range anonymousCart.CartItems {
 			itemExists := false
 			for _, cartItem := range cart.CartItems {
-				if cartItem.ProductID == anonCartItem.ProductID {
+				if cartItem.ProductID == anonCartItem.ProductID && variantIDValue(cartItem.VariantID) == variantIDValue(anonCartItem.VariantID) {
 					itemExists = true
 					cartItem.Quantity += anonCartItem.Quantity
-					if cartItem.Quantity > cartItem.Product.Stock {
-						cartItem.Quantity = cartItem.Product.Stock
+					stock := cartItem.Product.Stock
+					if cartItem.Variant != nil {
+						stock = cartItem.Variant.Stock
+					}
+					if cartItem.Quantity > stock {
+						cartItem.Quantity = stock
 					}
 					err := db.Updates(&cartItem).Error
 					if err != nil {
@@ -407,6 +425,7 @@ func MergeCartHandler(c *gin.Context, db *gorm.DB) {
 				cart.CartItems = append(cart.CartItems, models.CartItem{
 					CartID:    cart.ID,
 					ProductID: anonCartItem.ProductID,
+					VariantID: anonCartItem.VariantID,
 					Quantity:  anonCartItem.Quantity,
 				})
 			}
@@ -465,6 +484,7 @@ func GetCartHandler(c *gin.Context, db *gorm.DB) {
 	err := query.
 		Preload("CartItems").
 		Preload("CartItems.Product").
+		Preload("CartItems.Variant").
 		First(&cart).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -476,13 +496,21 @@ func GetCartHandler(c *gin.Context, db *gorm.DB) {
 
 	var cartItemsData []gin.H
 	for _, cartItem := range cart.CartItems {
+		line := newCheckoutLine(cartItem.Product, cartItem.Variant, cartItem.Quantity)
+		stock := cartItem.Product.Stock
+		if cartItem.Variant != nil {
+			stock = cartItem.Variant.Stock
+		}
 		cartItemsData = append(cartItemsData, gin.H{
-			"ProductID": cartItem.Product.ID,
-			"Name":      cartItem.Product.Name,
-			"Price":     cartItem.Product.Price,
-			"ImageURL":  cartItem.Product.ImageURL,
-			"Quantity":  cartItem.Quantity,
-			"Stock":     cartItem.Product.Stock,
+			"ProductID":   cartItem.Product.ID,
+			"VariantID":   cartItem.VariantID,
+			"SKU":         line.SKU,
+			"OptionLabel": line.OptionLabel,
+			"Name":        line.Name,
+			"Price":       line.UnitPrice,
+			"ImageURL":    line.ImageURL,
+			"Quantity":    cartItem.Quantity,
+			"Stock":       stock,
 		})
 	}
 
@@ -531,3 +559,83 @@ func ClearCartHandler(c *gin.Context, db *gorm.DB) {
 		"message": "成功清空購物車",
 	})
 }
+
+// 試算購物車套用優惠券後的金額，可帶入運送方式一併計算運費
+func PreviewCouponHandler(c *gin.Context, db *gorm.DB) {
+	var couponReq struct {
+		Code           string `json:"code" binding:"required"`
+		ShippingMethod string `json:"shippingMethod"`
+	}
+	err := c.ShouldBindJSON(&couponReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	userID, login := c.Get("UserID")
+	query := db
+	var cart models.Cart
+	if !login {
+		anonymousCartID := getAnonymousCartID(c)
+		if anonymousCartID == "" {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "尚未創建匿名購物車",
+			})
+			return
+		}
+		query = query.Where("anonymous_cart_uuid = ?", anonymousCartID)
+		userID = uint(0)
+	} else {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	err = query.
+		Preload("CartItems").
+		Preload("CartItems.Product").
+		Preload("CartItems.Variant").
+		First(&cart).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢購物車失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if len(cart.CartItems) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "購物車沒有商品",
+		})
+		return
+	}
+
+	var breakdown checkoutBreakdown
+	for _, cartItem := range cart.CartItems {
+		line := newCheckoutLine(cartItem.Product, cartItem.Variant, cartItem.Quantity)
+		breakdown.Items = append(breakdown.Items, line)
+		breakdown.Subtotal += line.LineTotal
+	}
+	breakdown.recalculate()
+
+	_, checkoutErr := applyCoupon(db, couponReq.Code, userID.(uint), &breakdown, false)
+	if checkoutErr != nil {
+		respondCheckoutError(c, checkoutErr)
+		return
+	}
+
+	if couponReq.ShippingMethod != "" {
+		_, checkoutErr = applyShippingMethod(db, couponReq.ShippingMethod, &breakdown)
+		if checkoutErr != nil {
+			respondCheckoutError(c, checkoutErr)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "成功試算優惠券",
+		"breakdown": breakdown,
+	})
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"Backend/cache"
+	"Backend/catalog"
+	"Backend/models"
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 未指定priceBuckets時的價格區間分界
+var defaultPriceBucketBounds = []uint{500, 1000, 2000, 5000}
+
+// 等待其他請求或其他實例重建商品列表索引的最長時間，逾時改由資料庫查詢
+const catalogRebuildWait = 3 * time.Second
+
+// 從資料庫重新建立商品列表索引，分批讀取並寫入，不會一次載入所有商品資料
+// 重建完成前索引不會標記為完成，查詢會改由資料庫提供
+func RebuildCatalogIndex(ctx context.Context, db *gorm.DB, rdb *redis.Client) (err error, msg string) {
+	err = catalog.BeginRebuild(ctx, rdb)
+	if err != nil {
+		return err, "無法清除商品列表索引"
+	}
+
+	var indexErr error
+	var products []models.Product
+	err = db.
+		Select("id", "name", "price", "stock", "created_at").
+		Preload("Categories", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id")
+		}).
+		FindInBatches(&products, 1000, func(tx *gorm.DB, batch int) error {
+			entries := make([]catalog.Entry, len(products))
+			for i := range products {
+				entries[i] = cache.CatalogEntry(&products[i])
+			}
+			indexErr = catalog.AddEntries(ctx, rdb, entries)
+			return indexErr
+		}).
+		Error
+	if indexErr != nil {
+		return indexErr, "無法建立商品列表索引"
+	}
+	if err != nil {
+		return err, "無法讀取商品列表"
+	}
+
+	err = catalog.FinishRebuild(ctx, rdb)
+	if err != nil {
+		return err, "無法建立商品列表索引"
+	}
+	return nil, ""
+}
+
+// 商品列表索引尚未建立或已遺失時從資料庫重建，同時只有一個請求執行重建
+func ensureCatalogIndex(c *gin.Context, db *gorm.DB, rdb *redis.Client) (err error, msg string) {
+	ready, err := catalog.IsReady(c, rdb)
+	if err != nil {
+		return err, "無法讀取商品列表索引"
+	}
+	if ready {
+		return nil, ""
+	}
+
+	err = cache.Rebuild(rdb, "catalog", catalogRebuildWait, func(ctx context.Context) error {
+		err, _ := RebuildCatalogIndex(ctx, db, rdb)
+		return err
+	})
+	if err != nil {
+		return err, "無法建立商品列表索引"
+	}
+
+	//等待的是其他實例的重建時須再確認是否成功
+	ready, err = catalog.IsReady(c, rdb)
+	if err != nil {
+		return err, "無法讀取商品列表索引"
+	}
+	if !ready {
+		return cache.ErrRebuildInProgress, "商品列表索引重建中"
+	}
+	return nil, ""
+}
+
+// 依篩選條件查詢商品ID，Redis無法使用或查詢失敗時改以資料庫分頁查詢
+func findProducts(c *gin.Context, db *gorm.DB, rdb *redis.Client, query catalog.Query) (catalog.Result, error) {
+	if cache.RedisAvailable() {
+		err, _ := ensureCatalogIndex(c, db, rdb)
+		if err == nil {
+			var result catalog.Result
+			result, err = catalog.Find(c, rdb, query)
+			if err == nil {
+				return result, nil
+			}
+		}
+		log.Println("Redis error: ", err)
+	}
+	return findProductsInDB(db, query)
+}
+
+// 以資料庫執行與catalog.Find相同的篩選、排序、分頁及數量統計，用於Redis無法使用時
+func findProductsInDB(db *gorm.DB, query catalog.Query) (catalog.Result, error) {
+	result := catalog.Result{
+		CategoryCounts: make(map[uint]int64, len(query.FacetCategories)),
+	}
+
+	categoryProducts := func(categoryIDs ...uint) *gorm.DB {
+		return db.Table("category_products").Select("product_id").Where("category_id IN ?", categoryIDs)
+	}
+	filter := func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Model(&models.Product{})
+		if query.MinPrice != nil {
+			tx = tx.Where("price >= ?", *query.MinPrice)
+		}
+		if query.MaxPrice != nil {
+			tx = tx.Where("price <= ?", *query.MaxPrice)
+		}
+		if query.InStockOnly {
+			tx = tx.Where("stock > 0")
+		}
+		if query.MatchAnyCategory {
+			var categoryIDs []uint
+			for _, group := range query.Categories {
+				categoryIDs = append(categoryIDs, group...)
+			}
+			if len(categoryIDs) > 0 {
+				tx = tx.Where("id IN (?)", categoryProducts(categoryIDs...))
+			}
+		} else {
+			for _, group := range query.Categories {
+				tx = tx.Where("id IN (?)", categoryProducts(group...))
+			}
+		}
+		if len(query.ExcludeCategories) > 0 {
+			tx = tx.Where("id NOT IN (?)", categoryProducts(query.ExcludeCategories...))
+		}
+		return tx
+	}
+
+	err := db.Scopes(filter).Count(&result.Total).Error
+	if err != nil {
+		return result, err
+	}
+
+	//只有本身的標籤以一次查詢分組計算，含有子標籤的標籤須另外計算不重複的商品數量
+	var singleIDs []uint
+	for categoryID, categoryIDs := range query.FacetCategories {
+		if len(categoryIDs) == 1 {
+			singleIDs = append(singleIDs, categoryIDs[0])
+			continue
+		}
+		var count int64
+		err = db.
+			Table("category_products").
+			Where("category_id IN ?", categoryIDs).
+			Where("product_id IN (?)", db.Scopes(filter).Select("id")).
+			Distinct("product_id").
+			Count(&count).
+			Error
+		if err != nil {
+			return result, err
+		}
+		result.CategoryCounts[categoryID] = count
+	}
+	if len(singleIDs) > 0 {
+		var counts []struct {
+			CategoryID uint
+			Count      int64
+		}
+		err = db.
+			Table("category_products").
+			Select("category_id, COUNT(*) AS count").
+			Where("category_id IN ?", singleIDs).
+			Where("product_id IN (?)", db.Scopes(filter).Select("id")).
+			Group("category_id").
+			Scan(&counts).
+			Error
+		if err != nil {
+			return result, err
+		}
+		for _, count := range counts {
+			result.CategoryCounts[count.CategoryID] = count.Count
+		}
+	}
+
+	result.PriceBucketCounts = make([]int64, len(query.PriceBuckets))
+	for i, bucket := range query.PriceBuckets {
+		tx := db.Scopes(filter).Where("price >= ?", bucket.Min)
+		if bucket.Max != nil {
+			tx = tx.Where("price < ?", *bucket.Max)
+		}
+		err = tx.Count(&result.PriceBucketCounts[i]).Error
+		if err != nil {
+			return result, err
+		}
+	}
+
+	order := "id ASC"
+	switch query.Sort {
+	case catalog.SortByPriceAsc:
+		order = "price ASC, id ASC"
+	case catalog.SortByPriceDesc:
+		order = "price DESC, id DESC"
+	case catalog.SortByNewest:
+		order = "created_at DESC, id DESC"
+	case catalog.SortByName:
+		order = "name ASC, id ASC"
+	}
+	err = db.
+		Scopes(filter).
+		Order(order).
+		Offset(query.Offset).
+		Limit(query.Limit).
+		Pluck("id", &result.ProductIDs).
+		Error
+	return result, err
+}
+
+// 依商品ID從Redis商品快取讀取商品資料並保持順序，快取中沒有的商品改從資料庫讀取
+func loadProductsByIDs(c *gin.Context, db *gorm.DB, rdb *redis.Client, productIDs []uint) ([]models.Product, error) {
+	productsByID, err := cache.NewProductCache(db, rdb).Get(c, productIDs)
+	if err != nil {
+		if err != cache.ErrRedisUnavailable {
+			log.Println("Redis error: ", err)
+		}
+		productsByID = make(map[uint]models.Product, len(productIDs))
+	}
+
+	var missingIDs []uint
+	for _, productID := range productIDs {
+		if _, ok := productsByID[productID]; !ok {
+			missingIDs = append(missingIDs, productID)
+		}
+	}
+
+	if len(missingIDs) > 0 {
+		var products []models.Product
+		err = db.Preload("Categories").Where("id IN ?", missingIDs).Find(&products).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, product := range products {
+			productsByID[product.ID] = product
+		}
+	}
+
+	products := make([]models.Product, 0, len(productIDs))
+	for _, productID := range productIDs {
+		if product, ok := productsByID[productID]; ok {
+			products = append(products, product)
+		}
+	}
+	return products, nil
+}
+
+// 解析以逗號分隔的ID列表，空字串回傳nil
+func parseUintList(value string) ([]uint, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var ids []uint
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+// 解析選填的非負整數，空字串回傳nil
+func parseOptionalUint(value string) (*uint, error) {
+	if value == "" {
+		return nil, nil
+	}
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	result := uint(number)
+	return &result, nil
+}
+
+// 依遞增的分界建立價格區間，例如[500, 1000]為0-499、500-999及1000以上
+func priceBuckets(bounds []uint) []catalog.PriceBucket {
+	buckets := make([]catalog.PriceBucket, 0, len(bounds)+1)
+	var min uint
+	for i := range bounds {
+		buckets = append(buckets, catalog.PriceBucket{Min: min, Max: &bounds[i]})
+		min = bounds[i]
+	}
+	return append(buckets, catalog.PriceBucket{Min: min})
+}
+
+// 以資料庫計算各標籤有庫存的商品數量，用於Redis無法使用時
+// groups為每個標籤對應其本身及所有子標籤的ID，含有其中任一標籤的商品只計算一次
+func categoryCountsInDB(db *gorm.DB, groups map[uint][]uint) (map[uint]int64, error) {
+	var links []struct {
+		CategoryID uint
+		ProductID  uint
+	}
+	err := db.
+		Table("category_products").
+		Select("category_products.category_id", "category_products.product_id").
+		Joins("JOIN products ON products.id = category_products.product_id").
+		Where("products.deleted_at IS NULL AND products.stock > 0").
+		Scan(&links).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	productIDsByCategory := make(map[uint][]uint)
+	for _, link := range links {
+		productIDsByCategory[link.CategoryID] = append(productIDsByCategory[link.CategoryID], link.ProductID)
+	}
+
+	counts := make(map[uint]int64, len(groups))
+	for categoryID, categoryIDs := range groups {
+		seen := make(map[uint]bool)
+		for _, id := range categoryIDs {
+			for _, productID := range productIDsByCategory[id] {
+				seen[productID] = true
+			}
+		}
+		counts[categoryID] = int64(len(seen))
+	}
+	return counts, nil
+}
+
+// 各標籤(含子標籤)有庫存的商品數量，Redis無法使用或查詢失敗時改以資料庫計算
+func findCategoryCounts(c *gin.Context, db *gorm.DB, rdb *redis.Client, groups map[uint][]uint) (map[uint]int64, error) {
+	if cache.RedisAvailable() {
+		err, _ := ensureCatalogIndex(c, db, rdb)
+		if err == nil {
+			var counts map[uint]int64
+			counts, err = catalog.InStockCategoryCounts(c, rdb, groups)
+			if err == nil {
+				return counts, nil
+			}
+		}
+		log.Println("Redis error: ", err)
+	}
+	return categoryCountsInDB(db, groups)
+}
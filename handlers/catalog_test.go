@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"Backend/catalog"
+	"Backend/models"
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 建立測試用的SQLite資料庫，只使用一個連線讓記憶體資料庫在查詢間共用
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// 建立測試用的Redis，有設定TEST_REDIS_ADDR時使用該Redis(會清空資料)，否則使用miniredis
+// miniredis不支援SORT，real為false時須略過使用名稱排序的測試
+func newTestRedis(t *testing.T) (rdb *redis.Client, real bool) {
+	t.Helper()
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		addr = miniredis.RunT(t).Addr()
+	}
+	rdb = redis.NewClient(&redis.Options{Addr: addr})
+	if err := rdb.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("flush redis: %v", err)
+	}
+	t.Cleanup(func() {
+		rdb.FlushDB(context.Background())
+		rdb.Close()
+	})
+	return rdb, os.Getenv("TEST_REDIS_ADDR") != ""
+}
+
+// 比較商品ID，nil與空的slice視為相同
+func equalIDs(a, b []uint) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// 標籤: 1電子(子標籤2耳機、3手機)、4服飾、5特價
+func seedCatalog(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	err := db.AutoMigrate(&models.Category{}, &models.Product{})
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	parentID := uint(1)
+	categories := []models.Category{
+		{Model: gorm.Model{ID: 1}, Name: "電子"},
+		{Model: gorm.Model{ID: 2}, Name: "耳機", ParentID: &parentID},
+		{Model: gorm.Model{ID: 3}, Name: "手機", ParentID: &parentID},
+		{Model: gorm.Model{ID: 4}, Name: "服飾"},
+		{Model: gorm.Model{ID: 5}, Name: "特價"},
+	}
+	if err := db.Create(&categories).Error; err != nil {
+		t.Fatalf("create categories: %v", err)
+	}
+
+	byID := func(ids ...uint) []models.Category {
+		var result []models.Category
+		for _, id := range ids {
+			result = append(result, categories[id-1])
+		}
+		return result
+	}
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	products := []struct {
+		name       string
+		price      uint
+		stock      uint
+		categories []uint
+	}{
+		{"Cable", 300, 5, []uint{1}},
+		{"Earbuds", 1500, 0, []uint{2, 5}},
+		{"Headphones", 4200, 3, []uint{2}},
+		{"Phone", 12000, 2, []uint{3}},
+		{"Shirt", 500, 10, []uint{4, 5}},
+		{"Jacket", 2500, 0, []uint{4}},
+		{"Adapter", 300, 8, []uint{1, 5}},
+		{"Case", 800, 4, []uint{3, 5}},
+		{"Bag", 1500, 6, nil},
+	}
+	for i, data := range products {
+		product := models.Product{
+			Model:      gorm.Model{ID: uint(i + 1), CreatedAt: created.AddDate(0, 0, i)},
+			Name:       data.name,
+			Price:      data.price,
+			Stock:      data.stock,
+			Categories: byID(data.categories...),
+		}
+		if err := db.Create(&product).Error; err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+	}
+}
+
+func uintPtr(value uint) *uint {
+	return &value
+}
+
+// Redis索引與資料庫對同樣的查詢須回傳相同的商品ID、總數及各標籤與價格區間的數量
+func TestFindProductsRedisMatchesDB(t *testing.T) {
+	db := newTestDB(t)
+	rdb, realRedis := newTestRedis(t)
+	seedCatalog(t, db)
+
+	ctx := context.Background()
+	if err, msg := RebuildCatalogIndex(ctx, db, rdb); err != nil {
+		t.Fatalf("%s: %v", msg, err)
+	}
+
+	facets := map[uint][]uint{1: {1, 2, 3}, 2: {2}, 3: {3}, 4: {4}, 5: {5}}
+	buckets := priceBuckets(defaultPriceBucketBounds)
+
+	//needsRedis為使用miniredis不支援的SORT(名稱排序)
+	tests := []struct {
+		name       string
+		query      catalog.Query
+		wantIDs    []uint
+		wantTotal  int64
+		needsRedis bool
+	}{
+		{
+			name:      "全部商品依ID排序",
+			query:     catalog.Query{Sort: catalog.SortByID},
+			wantIDs:   []uint{1, 2, 3, 4, 5, 6, 7, 8, 9},
+			wantTotal: 9,
+		},
+		{
+			name:      "分頁",
+			query:     catalog.Query{Sort: catalog.SortByID, Offset: 7, Limit: 5},
+			wantIDs:   []uint{8, 9},
+			wantTotal: 9,
+		},
+		{
+			name: "符合任一標籤依價格遞增",
+			query: catalog.Query{
+				Categories:       [][]uint{{2}, {4}},
+				MatchAnyCategory: true,
+				Sort:             catalog.SortByPriceAsc,
+			},
+			wantIDs:   []uint{5, 2, 6, 3},
+			wantTotal: 4,
+		},
+		{
+			name: "符合全部標籤含子標籤",
+			query: catalog.Query{
+				Categories: [][]uint{{1, 2, 3}, {5}},
+				Sort:       catalog.SortByID,
+			},
+			wantIDs:   []uint{2, 7, 8},
+			wantTotal: 3,
+		},
+		{
+			name: "符合任一標籤並排除標籤",
+			query: catalog.Query{
+				Categories:        [][]uint{{1, 2, 3}},
+				MatchAnyCategory:  true,
+				ExcludeCategories: []uint{5},
+				Sort:              catalog.SortByID,
+			},
+			wantIDs:   []uint{1, 3, 4},
+			wantTotal: 3,
+		},
+		{
+			name: "有庫存並排除標籤依價格遞減",
+			query: catalog.Query{
+				InStockOnly:       true,
+				ExcludeCategories: []uint{5},
+				Sort:              catalog.SortByPriceDesc,
+			},
+			wantIDs:   []uint{4, 3, 9, 1},
+			wantTotal: 4,
+		},
+		{
+			name: "價格範圍包含上下限依最新排序",
+			query: catalog.Query{
+				MinPrice: uintPtr(500),
+				MaxPrice: uintPtr(2500),
+				Sort:     catalog.SortByNewest,
+			},
+			wantIDs:   []uint{9, 8, 6, 5, 2},
+			wantTotal: 5,
+		},
+		{
+			name:      "價格相同時依ID遞增",
+			query:     catalog.Query{Sort: catalog.SortByPriceAsc},
+			wantIDs:   []uint{1, 7, 5, 8, 2, 9, 6, 3, 4},
+			wantTotal: 9,
+		},
+		{
+			name:      "價格相同時依ID遞減",
+			query:     catalog.Query{Sort: catalog.SortByPriceDesc},
+			wantIDs:   []uint{4, 3, 6, 9, 2, 8, 5, 7, 1},
+			wantTotal: 9,
+		},
+		{
+			name:       "依名稱排序並分頁",
+			query:      catalog.Query{Sort: catalog.SortByName, Offset: 2, Limit: 3},
+			wantIDs:    []uint{1, 8, 2},
+			wantTotal:  9,
+			needsRedis: true,
+		},
+		{
+			name:       "依名稱排序",
+			query:      catalog.Query{Sort: catalog.SortByName},
+			wantIDs:    []uint{7, 9, 1, 8, 2, 3, 6, 4, 5},
+			wantTotal:  9,
+			needsRedis: true,
+		},
+		{
+			name: "沒有符合的商品",
+			query: catalog.Query{
+				Categories: [][]uint{{3}, {4}},
+				Sort:       catalog.SortByID,
+			},
+			wantIDs:   nil,
+			wantTotal: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.query
+			if query.Limit == 0 {
+				query.Limit = 20
+			}
+			query.FacetCategories = facets
+			query.PriceBuckets = buckets
+
+			dbResult, err := findProductsInDB(db, query)
+			if err != nil {
+				t.Fatalf("findProductsInDB: %v", err)
+			}
+			if !equalIDs(dbResult.ProductIDs, tt.wantIDs) {
+				t.Errorf("db ProductIDs = %v, want %v", dbResult.ProductIDs, tt.wantIDs)
+			}
+			if dbResult.Total != tt.wantTotal {
+				t.Errorf("db Total = %d, want %d", dbResult.Total, tt.wantTotal)
+			}
+
+			if tt.needsRedis && !realRedis {
+				t.Skip("miniredis不支援SORT，設定TEST_REDIS_ADDR以Redis比對")
+			}
+			redisResult, err := catalog.Find(ctx, rdb, query)
+			if err != nil {
+				t.Fatalf("catalog.Find: %v", err)
+			}
+			if !equalIDs(redisResult.ProductIDs, tt.wantIDs) {
+				t.Errorf("redis ProductIDs = %v, want %v", redisResult.ProductIDs, tt.wantIDs)
+			}
+			if redisResult.Total != tt.wantTotal {
+				t.Errorf("redis Total = %d, want %d", redisResult.Total, tt.wantTotal)
+			}
+			//資料庫不會回傳數量為0的標籤
+			for categoryID := range facets {
+				if redisResult.CategoryCounts[categoryID] != dbResult.CategoryCounts[categoryID] {
+					t.Errorf("CategoryCounts[%d] redis = %d, db = %d", categoryID,
+						redisResult.CategoryCounts[categoryID], dbResult.CategoryCounts[categoryID])
+				}
+			}
+			if !reflect.DeepEqual(redisResult.PriceBucketCounts, dbResult.PriceBucketCounts) {
+				t.Errorf("PriceBucketCounts redis = %v, db = %v", redisResult.PriceBucketCounts, dbResult.PriceBucketCounts)
+			}
+		})
+	}
+}
+
+func TestFindProductsFacetCounts(t *testing.T) {
+	db := newTestDB(t)
+	rdb, _ := newTestRedis(t)
+	seedCatalog(t, db)
+
+	ctx := context.Background()
+	if err, msg := RebuildCatalogIndex(ctx, db, rdb); err != nil {
+		t.Fatalf("%s: %v", msg, err)
+	}
+
+	query := catalog.Query{
+		Sort:            catalog.SortByID,
+		Limit:           20,
+		FacetCategories: map[uint][]uint{1: {1, 2, 3}, 2: {2}, 3: {3}, 4: {4}, 5: {5}},
+		PriceBuckets:    priceBuckets(defaultPriceBucketBounds),
+	}
+	wantCategoryCounts := map[uint]int64{1: 6, 2: 2, 3: 2, 4: 2, 5: 4}
+	wantBucketCounts := []int64{2, 2, 2, 2, 1}
+
+	redisResult, err := catalog.Find(ctx, rdb, query)
+	if err != nil {
+		t.Fatalf("catalog.Find: %v", err)
+	}
+	dbResult, err := findProductsInDB(db, query)
+	if err != nil {
+		t.Fatalf("findProductsInDB: %v", err)
+	}
+
+	for name, result := range map[string]catalog.Result{"redis": redisResult, "db": dbResult} {
+		if !reflect.DeepEqual(result.CategoryCounts, wantCategoryCounts) {
+			t.Errorf("%s CategoryCounts = %v, want %v", name, result.CategoryCounts, wantCategoryCounts)
+		}
+		if !reflect.DeepEqual(result.PriceBucketCounts, wantBucketCounts) {
+			t.Errorf("%s PriceBucketCounts = %v, want %v", name, result.PriceBucketCounts, wantBucketCounts)
+		}
+	}
+}
+
+// 價格、建立時間或名稱相同時，Redis與資料庫都依商品ID排序，ID位數不同時也是如此
+func TestFindProductsTieBreakByID(t *testing.T) {
+	db := newTestDB(t)
+	rdb, realRedis := newTestRedis(t)
+	if err := db.AutoMigrate(&models.Category{}, &models.Product{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	//商品9、10、11及2、12的價格、建立時間及名稱相同
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for id := uint(1); id <= 12; id++ {
+		price, name, createdAt := 1000*id, "Z"+string(rune('A'+id)), created.AddDate(0, 0, int(id))
+		switch id {
+		case 9, 10, 11:
+			price, name, createdAt = 500, "Same", created
+		case 2, 12:
+			price, name, createdAt = 200, "Pair", created.AddDate(0, 0, 20)
+		}
+		product := models.Product{Model: gorm.Model{ID: id, CreatedAt: createdAt}, Name: name, Price: price, Stock: 1}
+		if err := db.Create(&product).Error; err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	if err, msg := RebuildCatalogIndex(ctx, db, rdb); err != nil {
+		t.Fatalf("%s: %v", msg, err)
+	}
+
+	tests := []struct {
+		name       string
+		sort       catalog.SortOrder
+		wantIDs    []uint
+		needsRedis bool
+	}{
+		{"價格遞增", catalog.SortByPriceAsc, []uint{2, 12, 9, 10, 11, 1, 3, 4, 5, 6, 7, 8}, false},
+		{"價格遞減", catalog.SortByPriceDesc, []uint{8, 7, 6, 5, 4, 3, 1, 11, 10, 9, 12, 2}, false},
+		{"最新", catalog.SortByNewest, []uint{12, 2, 8, 7, 6, 5, 4, 3, 1, 11, 10, 9}, false},
+		{"名稱", catalog.SortByName, []uint{2, 12, 9, 10, 11, 1, 3, 4, 5, 6, 7, 8}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := catalog.Query{Sort: tt.sort, Limit: 20}
+			dbResult, err := findProductsInDB(db, query)
+			if err != nil {
+				t.Fatalf("findProductsInDB: %v", err)
+			}
+			if !equalIDs(dbResult.ProductIDs, tt.wantIDs) {
+				t.Errorf("db ProductIDs = %v, want %v", dbResult.ProductIDs, tt.wantIDs)
+			}
+
+			if tt.needsRedis && !realRedis {
+				t.Skip("miniredis不支援SORT，設定TEST_REDIS_ADDR以Redis比對")
+			}
+			redisResult, err := catalog.Find(ctx, rdb, query)
+			if err != nil {
+				t.Fatalf("catalog.Find: %v", err)
+			}
+			if !equalIDs(redisResult.ProductIDs, tt.wantIDs) {
+				t.Errorf("redis ProductIDs = %v, want %v", redisResult.ProductIDs, tt.wantIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"Backend/cache"
+	"Backend/models"
+	"gorm.io/gorm"
+	"sort"
+	"strconv"
+)
+
+// 保留給路由使用的代稱，避免與/api/v1/categories/tree衝突
+var reservedCategorySlugs = map[string]bool{
+	"tree": true,
+}
+
+// 所有分類的父子關係，用於展開子分類及檢查移動是否形成循環
+type categoryTree struct {
+	categories map[uint]models.Category
+	//上層分類ID對應的子分類ID，最上層分類的上層為0，子分類依SortOrder及ID排序
+	children map[uint][]uint
+}
+
+func loadCategoryTree(db *gorm.DB) (*categoryTree, error) {
+	var categories []models.Category
+	err := db.
+		Select("id", "name", "slug", "description", "parent_id", "sort_order", "updated_at").
+		Order("sort_order ASC, id ASC").
+		Find(&categories).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &categoryTree{
+		categories: make(map[uint]models.Category, len(categories)),
+		children:   make(map[uint][]uint),
+	}
+	for _, category := range categories {
+		tree.categories[category.ID] = category
+	}
+	for _, category := range categories {
+		//上層分類已不存在時視為最上層分類
+		parentID := uint(0)
+		if category.ParentID != nil {
+			if _, ok := tree.categories[*category.ParentID]; ok {
+				parentID = *category.ParentID
+			}
+		}
+		tree.children[parentID] = append(tree.children[parentID], category.ID)
+	}
+	return tree, nil
+}
+
+// 分類本身及所有子分類的ID，分類不存在時只回傳本身
+// 資料中若已有循環仍會結束
+func (tree *categoryTree) descendantIDs(categoryID uint) []uint {
+	ids := []uint{categoryID}
+	seen := map[uint]bool{categoryID: true}
+	for i := 0; i < len(ids); i++ {
+		for _, childID := range tree.children[ids[i]] {
+			if !seen[childID] {
+				seen[childID] = true
+				ids = append(ids, childID)
+			}
+		}
+	}
+	return ids
+}
+
+// categoryID是否為ancestorID本身或其子分類
+func (tree *categoryTree) isDescendant(categoryID, ancestorID uint) bool {
+	for _, id := range tree.descendantIDs(ancestorID) {
+		if id == categoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// 由最上層到上層分類的路徑，不含分類本身
+func (tree *categoryTree) ancestors(categoryID uint) []models.Category {
+	var path []models.Category
+	seen := map[uint]bool{categoryID: true}
+	category, ok := tree.categories[categoryID]
+	for ok && category.ParentID != nil && !seen[*category.ParentID] {
+		seen[*category.ParentID] = true
+		category, ok = tree.categories[*category.ParentID]
+		if ok {
+			path = append(path, category)
+		}
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// 每個分類對應其本身及所有子分類的ID，用於計算分類的商品數量
+func (tree *categoryTree) facetGroups() map[uint][]uint {
+	groups := make(map[uint][]uint, len(tree.categories))
+	for categoryID := range tree.categories {
+		groups[categoryID] = tree.descendantIDs(categoryID)
+	}
+	return groups
+}
+
+// 依ID排序的所有分類
+func (tree *categoryTree) sorted() []models.Category {
+	categories := make([]models.Category, 0, len(tree.categories))
+	for _, category := range tree.categories {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	return categories
+}
+
+// 所有分類的ID及更新時間，回應包含分類資料時加入ETag，分類變更時ETag隨之改變
+func (tree *categoryTree) etagValues() []int64 {
+	categories := tree.sorted()
+	values := make([]int64, 0, len(categories)*2)
+	for _, category := range categories {
+		values = append(values, int64(category.ID), category.UpdatedAt.UnixMilli())
+	}
+	return values
+}
+
+// 將每個分類展開為其本身及所有子分類
+func (tree *categoryTree) expand(categoryIDs []uint) [][]uint {
+	groups := make([][]uint, len(categoryIDs))
+	for i, categoryID := range categoryIDs {
+		groups[i] = tree.descendantIDs(categoryID)
+	}
+	return groups
+}
+
+// 將分類及其所有子分類展開為一個不重複的列表
+func (tree *categoryTree) flatten(categoryIDs []uint) []uint {
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, categoryID := range categoryIDs {
+		for _, id := range tree.descendantIDs(categoryID) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// 巢狀的分類樹，子分類依SortOrder及ID排序
+func (tree *categoryTree) nodes(parentID uint) []categoryNode {
+	nodes := []categoryNode{}
+	for _, categoryID := range tree.children[parentID] {
+		category := tree.categories[categoryID]
+		nodes = append(nodes, categoryNode{
+			ID:          category.ID,
+			Name:        category.Name,
+			Slug:        category.Slug,
+			Description: category.Description,
+			SortOrder:   category.SortOrder,
+			Children:    tree.nodes(category.ID),
+		})
+	}
+	return nodes
+}
+
+type categoryNode struct {
+	ID          uint
+	Name        string
+	Slug        string
+	Description string
+	SortOrder   int
+	Children    []categoryNode
+}
+
+// 產生不重複的分類代稱，已使用時依序加上-2、-3，已刪除的分類的代稱也不會重複使用
+func uniqueCategorySlug(db *gorm.DB, name string, excludeID uint) (string, error) {
+	base := models.Slugify(name)
+	if base == "" {
+		base = "category"
+	}
+
+	slug := base
+	for i := 2; ; i++ {
+		if !reservedCategorySlugs[slug] {
+			var count int64
+			err := db.
+				Unscoped().
+				Model(&models.Category{}).
+				Where("slug = ? AND id <> ?", slug, excludeID).
+				Count(&count).
+				Error
+			if err != nil {
+				return "", err
+			}
+			if count == 0 {
+				return slug, nil
+			}
+		}
+		slug = base + "-" + strconv.Itoa(i)
+	}
+}
+
+// 依名稱查詢分類，不存在的分類以最上層分類建立
+// 同名的分類有多個時使用ID最小的分類
+func findOrCreateCategories(tx *gorm.DB, names []string) ([]models.Category, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var existing []models.Category
+	err := tx.Where("name IN ?", names).Order("id DESC").Find(&existing).Error
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]models.Category, len(existing))
+	for _, category := range existing {
+		byName[category.Name] = category
+	}
+
+	var categories []models.Category
+	added := make(map[string]bool, len(names))
+	for _, name := range names {
+		if added[name] {
+			continue
+		}
+		added[name] = true
+
+		category, ok := byName[name]
+		if !ok {
+			slug, err := uniqueCategorySlug(tx, name, 0)
+			if err != nil {
+				return nil, err
+			}
+			category = models.Category{Name: name, Slug: slug}
+			err = tx.Create(&category).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+		categories = append(categories, category)
+	}
+	return categories, nil
+}
+
+// 更新直接含有這些分類的商品的快取版本，回傳商品ID供事務提交後更新快取
+// 商品快取中含有分類資料，分類改名、移動或合併時都須更新
+func touchCategoryProducts(tx *gorm.DB, categoryIDs ...uint) ([]uint, error) {
+	var productIDs []uint
+	err := tx.
+		Table("category_products").
+		Where("category_id IN ?", categoryIDs).
+		Distinct().
+		Pluck("product_id", &productIDs).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(productIDs, func(i, j int) bool { return productIDs[i] < productIDs[j] })
+	return productIDs, cache.Touch(tx, productIDs...)
+}
+
+// 檢查管理員指定的分類代稱，須為Slugify後的格式、不是保留字且未被其他分類(含已刪除)使用
+func checkCategorySlug(db *gorm.DB, slug string, excludeID uint) (err error, msg string) {
+	if slug == "" || models.Slugify(slug) != slug {
+		return nil, "分類代稱只能包含小寫文字、數字及-"
+	}
+	if reservedCategorySlugs[slug] {
+		return nil, "分類代稱為保留字"
+	}
+
+	var count int64
+	err = db.
+		Unscoped().
+		Model(&models.Category{}).
+		Where("slug = ? AND id <> ?", slug, excludeID).
+		Count(&count).
+		Error
+	if err != nil {
+		return err, "檢查分類代稱失敗"
+	}
+	if count > 0 {
+		return nil, "分類代稱已被使用"
+	}
+	return nil, ""
+}
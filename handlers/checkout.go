@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"Backend/models"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// 結帳請求中的單一商品，價格一律由伺服器計算，有規格的商品須指定VariantID
+type checkoutItemReq struct {
+	ProductID uint  `json:"productID" binding:"required"`
+	VariantID *uint `json:"variantID"`
+	Quantity  uint  `json:"quantity"`
+}
+
+func (item checkoutItemReq) key() stockKey {
+	return stockKey{ProductID: item.ProductID, VariantID: variantIDValue(item.VariantID)}
+}
+
+// 結帳明細中的單一商品
+type checkoutLine struct {
+	ProductID   uint   `json:"productID"`
+	VariantID   uint   `json:"variantID,omitempty"`
+	SKU         string `json:"sku,omitempty"`
+	Name        string `json:"name"`
+	OptionLabel string `json:"optionLabel,omitempty"`
+	ImageURL    string `json:"imageURL"`
+	UnitPrice   uint   `json:"unitPrice"`
+	Quantity    uint   `json:"quantity"`
+	LineTotal   uint   `json:"lineTotal"`
+}
+
+// 依商品及規格建立結帳明細
+func newCheckoutLine(product models.Product, variant *models.ProductVariant, quantity uint) checkoutLine {
+	line := checkoutLine{
+		ProductID: product.ID,
+		Name:      product.Name,
+		ImageURL:  product.ImageURL,
+		UnitPrice: product.Price,
+		Quantity:  quantity,
+	}
+	if variant != nil {
+		line.VariantID = variant.ID
+		line.SKU = variant.SKU
+		line.OptionLabel = variant.OptionsLabel()
+		line.UnitPrice = variant.EffectivePrice(product)
+	}
+	line.LineTotal = line.UnitPrice * quantity
+	return line
+}
+
+// 結帳金額明細，Total = Subtotal - Discount + ShippingFee
+type checkoutBreakdown struct {
+	Items          []checkoutLine `json:"items"`
+	Subtotal       uint           `json:"subtotal"`
+	CouponCode     string         `json:"couponCode,omitempty"`
+	Discount       uint           `json:"discount"`
+	ShippingMethod string         `json:"shippingMethod,omitempty"`
+	ShippingFee    uint           `json:"shippingFee"`
+	FreeShipping   bool           `json:"freeShipping"`
+	Total          uint           `json:"total"`
+}
+
+// 依各項金額重新計算總計
+func (b *checkoutBreakdown) recalculate() {
+	b.Total = b.Subtotal - b.Discount + b.ShippingFee
+}
+
+// 結帳過程的錯誤，Status為回傳給客戶端的HTTP狀態碼
+type checkoutError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *checkoutError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func newCheckoutError(status int, message string, err error) *checkoutError {
+	return &checkoutError{Status: status, Message: message, Err: err}
+}
+
+func respondCheckoutError(c *gin.Context, checkoutErr *checkoutError) {
+	response := gin.H{
+		"message": checkoutErr.Message,
+	}
+	if checkoutErr.Err != nil {
+		response["error"] = checkoutErr.Err.Error()
+	}
+	c.JSON(checkoutErr.Status, response)
+}
+
+// 檢查結帳商品數量大於0且沒有重複商品
+func validateCheckoutItems(items []checkoutItemReq) *checkoutError {
+	if len(items) == 0 {
+		return newCheckoutError(http.StatusBadRequest, "訂單沒有商品", nil)
+	}
+
+	seen := make(map[stockKey]bool, len(items))
+	for _, item := range items {
+		if item.Quantity == 0 {
+			return newCheckoutError(http.StatusBadRequest, fmt.Sprintf("商品%d數量必須大於0", item.ProductID), nil)
+		}
+		if seen[item.key()] {
+			return newCheckoutError(http.StatusBadRequest, fmt.Sprintf("商品%d重複", item.ProductID), nil)
+		}
+		seen[item.key()] = true
+	}
+
+	return nil
+}
+
+// 檢查結帳商品都在購物車內且數量不超過購物車數量
+func checkItemsInCart(db *gorm.DB, userID interface{}, items []checkoutItemReq) *checkoutError {
+	var cart models.Cart
+	err := db.
+		Where("user_id = ?", userID).
+		Preload("CartItems").
+		First(&cart).
+		Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return newCheckoutError(http.StatusBadRequest, "購物車沒有商品", nil)
+		}
+		return newCheckoutError(http.StatusInternalServerError, "查詢購物車失敗", err)
+	}
+
+	cartQuantities := make(map[stockKey]uint, len(cart.CartItems))
+	for _, cartItem := range cart.CartItems {
+		key := stockKey{ProductID: cartItem.ProductID, VariantID: variantIDValue(cartItem.VariantID)}
+		cartQuantities[key] = cartItem.Quantity
+	}
+
+	for _, item := range items {
+		cartQuantity, ok := cartQuantities[item.key()]
+		if !ok {
+			return newCheckoutError(http.StatusBadRequest, fmt.Sprintf("商品%d不在購物車內", item.ProductID), nil)
+		}
+		if item.Quantity > cartQuantity {
+			return newCheckoutError(http.StatusBadRequest, fmt.Sprintf("商品%d數量超過購物車數量", item.ProductID), nil)
+		}
+	}
+
+	return nil
+}
+
+// 鎖定結帳商品並依資料庫價格計算金額，tx應在事務中
+// 可購買數量為庫存扣除其他使用者保留的數量
+// 回傳的庫存單位與明細順序相同，依商品ID及規格ID排序以避免與其他事務互相等待
+func priceCheckoutItems(tx *gorm.DB, userID uint, items []checkoutItemReq) (checkoutBreakdown, []lockedStockUnit, *checkoutError) {
+	var breakdown checkoutBreakdown
+
+	quantities := make(map[stockKey]uint, len(items))
+	keys := make([]stockKey, len(items))
+	for i, item := range items {
+		keys[i] = item.key()
+		quantities[item.key()] = item.Quantity
+	}
+
+	units, failedKey, err := lockStockUnits(tx, keys, userID)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			return breakdown, nil, newCheckoutError(http.StatusBadRequest, fmt.Sprintf("商品%d不存在", failedKey.ProductID), nil)
+		case ErrVariantRequired, ErrVariantNotFound:
+			return breakdown, nil, newCheckoutError(http.StatusBadRequest, fmt.Sprintf("商品%d: %s", failedKey.ProductID, err.Error()), nil)
+		}
+		return breakdown, nil, newCheckoutError(http.StatusInternalServerError, "查詢庫存失敗", err)
+	}
+
+	for _, unit := range units {
+		quantity := quantities[unit.Key]
+		if unit.Available < quantity {
+			return breakdown, nil, newCheckoutError(http.StatusBadRequest, fmt.Sprintf("商品%s庫存不足", unit.Name()), nil)
+		}
+
+		line := newCheckoutLine(unit.Product, unit.Variant, quantity)
+		breakdown.Items = append(breakdown.Items, line)
+		breakdown.Subtotal += line.LineTotal
+	}
+
+	breakdown.recalculate()
+
+	return breakdown, units, nil
+}
+
+// 查詢啟用中的運送方式並將運費加入結帳明細，須在套用優惠券後呼叫
+// 折扣後金額達免運門檻或使用免運優惠券時運費為0
+func applyShippingMethod(db *gorm.DB, code string, breakdown *checkoutBreakdown) (*models.ShippingMethod, *checkoutError) {
+	var shippingMethod models.ShippingMethod
+	err := db.Where("code = ? AND enabled = ?", code, true).First(&shippingMethod).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, newCheckoutError(http.StatusBadRequest, "不支援的運送方式", nil)
+		}
+		return nil, newCheckoutError(http.StatusInternalServerError, "查詢運送方式失敗", err)
+	}
+
+	reachThreshold := shippingMethod.FreeShippingThreshold > 0 &&
+		breakdown.Subtotal-breakdown.Discount >= shippingMethod.FreeShippingThreshold
+	if reachThreshold {
+		breakdown.FreeShipping = true
+	}
+
+	breakdown.ShippingMethod = shippingMethod.Name
+	breakdown.ShippingFee = shippingMethod.Fee
+	if breakdown.FreeShipping {
+		breakdown.ShippingFee = 0
+	}
+	breakdown.recalculate()
+
+	return &shippingMethod, nil
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"Backend/models"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 統一優惠券代碼格式
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// 計算優惠券對商品金額的折扣，不會超過商品金額
+func couponDiscount(coupon *models.Coupon, subtotal uint) uint {
+	var discount uint
+	switch coupon.Type {
+	case models.CouponTypePercentage:
+		discount = subtotal * coupon.Value / 100
+		if coupon.MaxDiscount > 0 && discount > coupon.MaxDiscount {
+			discount = coupon.MaxDiscount
+		}
+	case models.CouponTypeFixedAmount:
+		discount = coupon.Value
+	}
+
+	if discount > subtotal {
+		discount = subtotal
+	}
+	return discount
+}
+
+// 檢查優惠券是否可用於此金額，userID為0代表未登入，不檢查每人使用次數
+func checkCouponUsable(db *gorm.DB, coupon *models.Coupon, userID uint, subtotal uint) *checkoutError {
+	now := time.Now()
+	if !coupon.Enabled {
+		return newCheckoutError(http.StatusBadRequest, "優惠券已停用", nil)
+	}
+	if coupon.StartsAt != nil && now.Before(*coupon.StartsAt) {
+		return newCheckoutError(http.StatusBadRequest, "優惠券尚未開始", nil)
+	}
+	if coupon.EndsAt != nil && now.After(*coupon.EndsAt) {
+		return newCheckoutError(http.StatusBadRequest, "優惠券已過期", nil)
+	}
+	if subtotal < coupon.MinSpend {
+		return newCheckoutError(http.StatusBadRequest, fmt.Sprintf("未達優惠券最低消費%d元", coupon.MinSpend), nil)
+	}
+	if coupon.UsageLimit > 0 && coupon.UsedCount >= coupon.UsageLimit {
+		return newCheckoutError(http.StatusBadRequest, "優惠券已達使用上限", nil)
+	}
+
+	if coupon.PerUserLimit > 0 && userID != 0 {
+		var userUsedCount int64
+		err := db.
+			Model(&models.CouponRedemption{}).
+			Where("coupon_id = ? AND user_id = ?", coupon.ID, userID).
+			Count(&userUsedCount).
+			Error
+		if err != nil {
+			return newCheckoutError(http.StatusInternalServerError, "查詢優惠券使用紀錄失敗", err)
+		}
+		if uint(userUsedCount) >= coupon.PerUserLimit {
+			return newCheckoutError(http.StatusBadRequest, "已達此優惠券每人使用上限", nil)
+		}
+	}
+
+	return nil
+}
+
+// 查詢優惠券並套用至結帳明細，lock為true時鎖定優惠券直到事務結束
+func applyCoupon(db *gorm.DB, code string, userID uint, breakdown *checkoutBreakdown, lock bool) (*models.Coupon, *checkoutError) {
+	query := db
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	var coupon models.Coupon
+	err := query.Where("code = ?", normalizeCouponCode(code)).First(&coupon).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, newCheckoutError(http.StatusBadRequest, "查無此優惠券", nil)
+		}
+		return nil, newCheckoutError(http.StatusInternalServerError, "查詢優惠券失敗", err)
+	}
+
+	if checkoutErr := checkCouponUsable(db, &coupon, userID, breakdown.Subtotal); checkoutErr != nil {
+		return nil, checkoutErr
+	}
+
+	breakdown.CouponCode = coupon.Code
+	breakdown.Discount = couponDiscount(&coupon, breakdown.Subtotal)
+	breakdown.FreeShipping = coupon.Type == models.CouponTypeFreeShipping
+	breakdown.recalculate()
+
+	return &coupon, nil
+}
+
+// 記錄優惠券使用並增加使用次數，tx應在事務中且coupon已由applyCoupon鎖定
+func redeemCoupon(tx *gorm.DB, coupon *models.Coupon, userID uint, orderID uint, discount uint) error {
+	err := tx.Create(&models.CouponRedemption{
+		CouponID: coupon.ID,
+		UserID:   userID,
+		OrderID:  orderID,
+		Discount: discount,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.
+		Model(coupon).
+		UpdateColumn("used_count", gorm.Expr("used_count + ?", 1)).
+		Error
+}
+
+// 訂單取消時歸還優惠券使用次數，tx應在事務中
+func releaseOrderCoupon(tx *gorm.DB, order *models.Order) error {
+	var redemptions []models.CouponRedemption
+	err := tx.Where("order_id = ?", order.ID).Find(&redemptions).Error
+	if err != nil {
+		return err
+	}
+
+	for _, redemption := range redemptions {
+		err = tx.
+			Model(&models.Coupon{}).
+			Where("id = ? AND used_count > 0", redemption.CouponID).
+			UpdateColumn("used_count", gorm.Expr("used_count - ?", 1)).
+			Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(&redemption).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"Backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"testing"
+)
+
+// 建立折抵100元的優惠券
+func createTestCoupon(t *testing.T, db *gorm.DB, code string, usageLimit uint, perUserLimit uint) models.Coupon {
+	t.Helper()
+	coupon := models.Coupon{
+		Code:         code,
+		Type:         models.CouponTypeFixedAmount,
+		Value:        100,
+		UsageLimit:   usageLimit,
+		PerUserLimit: perUserLimit,
+		Enabled:      true,
+	}
+	if err := db.Create(&coupon).Error; err != nil {
+		t.Fatalf("create coupon: %v", err)
+	}
+	return coupon
+}
+
+// 查詢優惠券的使用次數及使用紀錄數量
+func couponUsage(t *testing.T, db *gorm.DB, couponID uint) (uint, int64) {
+	t.Helper()
+	var coupon models.Coupon
+	if err := db.First(&coupon, couponID).Error; err != nil {
+		t.Fatalf("find coupon: %v", err)
+	}
+	var redemptions int64
+	db.Model(&models.CouponRedemption{}).Where("coupon_id = ?", couponID).Count(&redemptions)
+	return coupon.UsedCount, redemptions
+}
+
+// 使用者最近一筆訂單
+func latestTestOrder(t *testing.T, db *gorm.DB, userID uint) models.Order {
+	t.Helper()
+	var order models.Order
+	if err := db.Where("user_id = ?", userID).Order("id DESC").First(&order).Error; err != nil {
+		t.Fatalf("find order: %v", err)
+	}
+	return order
+}
+
+// 優惠券達總使用上限後不可使用，取消訂單歸還使用次數後可再使用
+func TestCouponUsageLimit(t *testing.T) {
+	db, rdb := newTestStore(t)
+	product := createTestProduct(t, db, "Cable", 300, 10)
+	createTestShippingMethod(t, db)
+	coupon := createTestCoupon(t, db, "ONCE", 1, 0)
+	for _, userID := range []uint{1, 2} {
+		createTestCart(t, db, userID, models.CartItem{ProductID: product.ID, Quantity: 1})
+	}
+	item := gin.H{"productID": product.ID, "quantity": 1}
+
+	recorder := sendTestOrder(t, db, rdb, 1, "once", item)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("order status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	order := latestTestOrder(t, db, 1)
+	if order.Discount != 100 || order.CouponCode != "ONCE" {
+		t.Errorf("order discount = %d, coupon = %q, want 100 and ONCE", order.Discount, order.CouponCode)
+	}
+	if usedCount, redemptions := couponUsage(t, db, coupon.ID); usedCount != 1 || redemptions != 1 {
+		t.Fatalf("usage after order = %d, %d redemptions, want 1, 1", usedCount, redemptions)
+	}
+
+	recorder = sendTestOrder(t, db, rdb, 2, "ONCE", item)
+	if recorder.Code != http.StatusBadRequest || decodeResponse(t, recorder)["message"] != "優惠券已達使用上限" {
+		t.Fatalf("order over usage limit status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	//取消訂單歸還使用次數並刪除使用紀錄
+	if recorder := cancelTestOrder(t, db, rdb, 1, order.ID); recorder.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if usedCount, redemptions := couponUsage(t, db, coupon.ID); usedCount != 0 || redemptions != 0 {
+		t.Fatalf("usage after cancel = %d, %d redemptions, want 0, 0", usedCount, redemptions)
+	}
+
+	recorder = sendTestOrder(t, db, rdb, 2, "ONCE", item)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("order after coupon returned status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+// 每人使用上限只計算該使用者的使用紀錄，取消訂單後可再使用
+func TestCouponPerUserLimit(t *testing.T) {
+	db, rdb := newTestStore(t)
+	product := createTestProduct(t, db, "Cable", 300, 10)
+	createTestShippingMethod(t, db)
+	coupon := createTestCoupon(t, db, "WELCOME", 0, 1)
+	createTestCart(t, db, 1, models.CartItem{ProductID: product.ID, Quantity: 3})
+	createTestCart(t, db, 2, models.CartItem{ProductID: product.ID, Quantity: 1})
+	item := gin.H{"productID": product.ID, "quantity": 1}
+
+	if recorder := sendTestOrder(t, db, rdb, 1, "WELCOME", item); recorder.Code != http.StatusOK {
+		t.Fatalf("first order status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	first := latestTestOrder(t, db, 1)
+
+	recorder := sendTestOrder(t, db, rdb, 1, "WELCOME", item)
+	if recorder.Code != http.StatusBadRequest || decodeResponse(t, recorder)["message"] != "已達此優惠券每人使用上限" {
+		t.Fatalf("second order status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	//其他使用者不受影響
+	if recorder := sendTestOrder(t, db, rdb, 2, "WELCOME", item); recorder.Code != http.StatusOK {
+		t.Errorf("order of other user status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := cancelTestOrder(t, db, rdb, 1, first.ID); recorder.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if usedCount, redemptions := couponUsage(t, db, coupon.ID); usedCount != 1 || redemptions != 1 {
+		t.Errorf("usage after cancel = %d, %d redemptions, want 1, 1", usedCount, redemptions)
+	}
+	if recorder := sendTestOrder(t, db, rdb, 1, "WELCOME", item); recorder.Code != http.StatusOK {
+		t.Errorf("order after cancel status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"Backend/models"
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// 建立已建立所有資料表的測試資料庫及Redis
+func newTestStore(t *testing.T) (*gorm.DB, *redis.Client) {
+	t.Helper()
+	db := newTestDB(t)
+	err := db.AutoMigrate(
+		&models.User{},
+		&models.LoginToken{},
+		&models.RefreshToken{},
+		&models.Product{},
+		&models.ProductVariant{},
+		&models.ProductImage{},
+		&models.Category{},
+		&models.Order{},
+		&models.OrderItem{},
+		&models.Cart{},
+		&models.CartItem{},
+		&models.Payment{},
+		&models.OrderStatusHistory{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.ShippingMethod{},
+		&models.StockReservation{},
+	)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	rdb, _ := newTestRedis(t)
+	return db, rdb
+}
+
+// 以指定使用者身分執行handler，userID為0代表未登入，body為nil時不傳送內容
+func performRequest(t *testing.T, handler gin.HandlerFunc, userID uint, body interface{}, params ...gin.Param) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", reader)
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+	if userID != 0 {
+		c.Set("UserID", userID)
+	}
+	handler(c)
+	//沒有回應內容時(如304)須自行寫入狀態碼
+	c.Writer.WriteHeaderNow()
+	return recorder
+}
+
+// 以GET請求執行handler，target可包含查詢參數，header為請求的標頭
+func performGet(t *testing.T, handler gin.HandlerFunc, target string, header http.Header, params ...gin.Param) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		c.Request.Header[name] = values
+	}
+	c.Params = params
+	handler(c)
+	//沒有回應內容時(如304)須自行寫入狀態碼
+	c.Writer.WriteHeaderNow()
+	return recorder
+}
+
+// 解析JSON回應
+func decodeResponse(t *testing.T, recorder *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var response map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response %q: %v", recorder.Body.String(), err)
+	}
+	return response
+}
+
+// 建立商品，variantStocks不為空時建立對應的規格且商品庫存為規格庫存總和
+func createTestProduct(t *testing.T, db *gorm.DB, name string, price uint, stock uint, variantStocks ...uint) models.Product {
+	t.Helper()
+	product := models.Product{Name: name, Price: price, Stock: stock, ImageURL: "/images/" + name}
+	if len(variantStocks) > 0 {
+		product.Stock = 0
+		for i, variantStock := range variantStocks {
+			product.Variants = append(product.Variants, models.ProductVariant{
+				SKU:     name + "-" + string(rune('A'+i)),
+				Options: map[string]string{"尺寸": string(rune('A' + i))},
+				Stock:   variantStock,
+			})
+			product.Stock += variantStock
+		}
+	}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+	return product
+}
+
+// 建立使用者的購物車
+func createTestCart(t *testing.T, db *gorm.DB, userID uint, items ...models.CartItem) models.Cart {
+	t.Helper()
+	cart := models.Cart{UserID: userID, AnonymousCartUUID: "cart-" + string(rune('a'+userID)), CartItems: items}
+	if err := db.Create(&cart).Error; err != nil {
+		t.Fatalf("create cart: %v", err)
+	}
+	return cart
+}
+
+// 建立免運費門檻為0的運送方式
+func createTestShippingMethod(t *testing.T, db *gorm.DB) models.ShippingMethod {
+	t.Helper()
+	method := models.ShippingMethod{Code: "home", Name: "宅配", Fee: 100, Enabled: true}
+	if err := db.Create(&method).Error; err != nil {
+		t.Fatalf("create shipping method: %v", err)
+	}
+	return method
+}
+
+// 建立使用者待處理的訂單，total為應付金額
+func createTestOrder(t *testing.T, db *gorm.DB, userID uint, total uint, items ...models.OrderItem) models.Order {
+	t.Helper()
+	order := models.Order{
+		UserID:         userID,
+		OrderItems:     items,
+		Subtotal:       total,
+		Total:          total,
+		ShippingMethod: "宅配",
+		Name:           "tester",
+		Address:        "address",
+		Phone:          "0912345678",
+		Status:         models.OrderStatusPending,
+	}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	return order
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"Backend/models"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 商品詳細資料的ETag，由商品ID、更新時間及商品與各規格被保留的數量組成
+// 保留過期到被清除前不會更新商品的updated_at，因此保留數量須包含在ETag中
+func productETag(product *models.Product, reserved uint, reservedVariants map[uint]uint) string {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d,", reserved)
+	for _, variant := range product.Variants {
+		fmt.Fprintf(hash, "%d-%d,", variant.ID, reservedVariants[variant.ID])
+	}
+	return fmt.Sprintf(`W/"p%d-%d-%x"`, product.ID, product.UpdatedAt.UnixMilli(), hash.Sum64())
+}
+
+// 商品列表的ETag，由列表中的商品ID、更新時間、保留數量及列表以外會影響回應的數值(如總數、各區間數量)組成
+func productListETag(products []models.Product, reserved map[uint]uint, extra ...int64) string {
+	hash := fnv.New64a()
+	for _, product := range products {
+		fmt.Fprintf(hash, "%d-%d-%d,", product.ID, product.UpdatedAt.UnixMilli(), reserved[product.ID])
+	}
+	for _, value := range extra {
+		fmt.Fprintf(hash, "%d,", value)
+	}
+	return fmt.Sprintf(`W/"l%x"`, hash.Sum64())
+}
+
+// 弱比較，忽略W/前綴
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// 設定ETag及Last-Modified，請求的If-None-Match或If-Modified-Since符合時回應304並回傳true
+// 有If-None-Match時優先使用，lastModified為零值時不設定Last-Modified(列表的成員變更不會反映在更新時間)
+// 商品資料不因使用者而不同，Cache-Control為public, no-cache讓瀏覽器及CDN儲存並每次都帶條件重新驗證
+func respondNotModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, no-cache")
+	//Last-Modified只精確到秒，同一秒內可能再次變更，因此最後變更在目前這一秒時不提供
+	if lastModified.Truncate(time.Second).Equal(time.Now().Truncate(time.Second)) {
+		lastModified = time.Time{}
+	}
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+
+	if ifModifiedSince := c.GetHeader("If-Modified-Since"); ifModifiedSince != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err == nil && !lastModified.Truncate(time.Second).After(since) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
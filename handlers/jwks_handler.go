@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"Backend/jwt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// 公開驗證Token用的公鑰(JWKS)，其他服務可依Token標頭的kid選擇公鑰驗證
+// 金鑰輪替時新舊公鑰會同時列出，允許快取5分鐘
+func GetJWKSHandler(c *gin.Context) {
+	jwks, err := jwt.PublicJWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取JWT金鑰",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"Backend/models"
+	"Backend/reservation"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"log"
 	"net/http"
 )
@@ -19,33 +21,32 @@ func SendOrderHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 	}
 
 	var orderReq struct {
-		Name           string             `json:"name" binding:"required"`
-		Address        string             `json:"address" binding:"required"`
-		Phone          string             `json:"phone" binding:"required"`
-		ShippingMethod string             `json:"shippingMethod" binding:"required"`
-		OrderItems     []models.OrderItem `json:"orderItems" binding:"required"`
+		Name           string            `json:"name" binding:"required"`
+		Address        string            `json:"address" binding:"required"`
+		Phone          string            `json:"phone" binding:"required"`
+		ShippingMethod string            `json:"shippingMethod" binding:"required"`
+		OrderItems     []checkoutItemReq `json:"orderItems" binding:"required,dive"`
+		CouponCode     string            `json:"couponCode"`
 	}
 
 	err := c.ShouldBindJSON(&orderReq)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "取得訂單資料錯誤",
 			"error":   err.Error(),
 		})
 		return
 	}
 
-	newOrder := models.Order{
-		UserID:     userID.(uint),
-		OrderItems: orderReq.OrderItems,
-		Name:       orderReq.Name,
-		Address:    orderReq.Address,
-		Phone:      orderReq.Phone,
-		Status:     "待處理",
+	if checkoutErr := validateCheckoutItems(orderReq.OrderItems); checkoutErr != nil {
+		respondCheckoutError(c, checkoutErr)
+		return
 	}
 
-	var orderProductIDs []uint
-	totalPrice := uint(0)
+	if checkoutErr := checkItemsInCart(db, userID, orderReq.OrderItems); checkoutErr != nil {
+		respondCheckoutError(c, checkoutErr)
+		return
+	}
 
 	tx := db.Begin()
 	defer func() {
@@ -62,31 +63,38 @@ func SendOrderHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
-	for _, orderItem := range orderReq.OrderItems {
-		var product models.Product
-		if err := tx.
-			Set("gorm:query_option", "FOR UPDATE").
-			Where("id = ?", orderItem.ProductID).
-			First(&product).
-			Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "查詢庫存失敗",
-				"error":   err.Error(),
-			})
-			return
-		}
+	breakdown, units, checkoutErr := priceCheckoutItems(tx, userID.(uint), orderReq.OrderItems)
+	if checkoutErr != nil {
+		tx.Rollback()
+		respondCheckoutError(c, checkoutErr)
+		return
+	}
 
-		if product.Stock < orderItem.Quantity {
+	var coupon *models.Coupon
+	if orderReq.CouponCode != "" {
+		coupon, checkoutErr = applyCoupon(tx, orderReq.CouponCode, userID.(uint), &breakdown, true)
+		if checkoutErr != nil {
 			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "商品庫存不足",
-			})
+			respondCheckoutError(c, checkoutErr)
 			return
 		}
+	}
+
+	shippingMethod, checkoutErr := applyShippingMethod(tx, orderReq.ShippingMethod, &breakdown)
+	if checkoutErr != nil {
+		tx.Rollback()
+		respondCheckoutError(c, checkoutErr)
+		return
+	}
 
-		product.Stock -= orderItem.Quantity
-		if err := tx.Save(&product).Error; err != nil {
+	var orderItems []models.OrderItem
+	var orderProductIDs []uint
+	var orderKeys []stockKey
+	var orderedItems []reservation.Item
+	var orderQuantities []uint
+	for i, line := range breakdown.Items {
+		unit := units[i]
+		if err := adjustStock(tx, &unit.Product, unit.Variant, -int(line.Quantity)); err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "更新庫存失敗",
@@ -95,21 +103,40 @@ func SendOrderHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 			return
 		}
 
-		err, msg := UpdateProductToRedis(c, rdb, &product)
-		if err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": msg,
-				"error":   err.Error(),
-			})
-			return
+		//記錄結帳當下的商品名稱、規格、單價及圖片
+		orderItems = append(orderItems, models.OrderItem{
+			ProductID:   line.ProductID,
+			VariantID:   variantIDPtr(line.VariantID),
+			ProductName: line.Name,
+			SKU:         line.SKU,
+			OptionLabel: line.OptionLabel,
+			UnitPrice:   line.UnitPrice,
+			ImageURL:    line.ImageURL,
+			Quantity:    line.Quantity,
+		})
+		if i == 0 || units[i-1].Key.ProductID != unit.Key.ProductID {
+			orderProductIDs = append(orderProductIDs, line.ProductID)
 		}
-
-		orderProductIDs = append(orderProductIDs, orderItem.ProductID)
-		totalPrice += product.Price
+		orderKeys = append(orderKeys, unit.Key)
+		orderQuantities = append(orderQuantities, line.Quantity)
+		orderedItems = append(orderedItems, reservation.Item{ProductID: unit.Key.ProductID, VariantID: unit.Key.VariantID})
 	}
 
-	newOrder.Total = totalPrice
+	newOrder := models.Order{
+		UserID:           userID.(uint),
+		OrderItems:       orderItems,
+		Subtotal:         breakdown.Subtotal,
+		Discount:         breakdown.Discount,
+		CouponCode:       breakdown.CouponCode,
+		ShippingFee:      breakdown.ShippingFee,
+		Total:            breakdown.Total,
+		ShippingMethodID: shippingMethod.ID,
+		ShippingMethod:   shippingMethod.Name,
+		Name:             orderReq.Name,
+		Address:          orderReq.Address,
+		Phone:            orderReq.Phone,
+		Status:           models.OrderStatusPending,
+	}
 
 	err = tx.Create(&newOrder).Error
 	if err != nil {
@@ -122,6 +149,29 @@ func SendOrderHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
+	//結帳完成，釋放此使用者對訂單中商品的保留，購物車中其他商品仍保留
+	err = reservation.ReleaseItems(tx, userID.(uint), orderedItems)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "釋放保留庫存失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if coupon != nil {
+		err = redeemCoupon(tx, coupon, userID.(uint), newOrder.ID, breakdown.Discount)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "使用優惠券失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -131,33 +181,76 @@ func SendOrderHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 		return
 	}
 
+	//同一商品的多個規格只需更新一次快取
+	refreshProductCache(c, db, rdb, orderProductIDs...)
+
 	var cart models.Cart
 	err = db.Where("user_id = ?", userID).First(&cart).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "訂單已送出，但清除購物車對應商品失敗",
-			"error":   err.Error(),
+			"message":   "訂單已送出，但清除購物車對應商品失敗",
+			"error":     err.Error(),
+			"orderID":   newOrder.ID,
+			"breakdown": breakdown,
 		})
 		return
 	}
 
-	err = db.
-		Where("cart_id = ? AND product_id IN ?", cart.ID, orderProductIDs).
-		Delete(&models.CartItem{}).
-		Error
+	err = removeOrderedCartItems(db, cart.ID, orderKeys, orderQuantities)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "訂單已送出，但清除購物車對應商品失敗",
-			"error":   err.Error(),
+			"message":   "訂單已送出，但清除購物車對應商品失敗",
+			"error":     err.Error(),
+			"orderID":   newOrder.ID,
+			"breakdown": breakdown,
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "訂單已送出，成功清除購物車對應商品",
+		"message":   "訂單已送出，成功清除購物車對應商品",
+		"orderID":   newOrder.ID,
+		"breakdown": breakdown,
 	})
 }
 
+// 從購物車扣除已下單的數量，數量歸零的商品才從購物車移除
+func removeOrderedCartItems(db *gorm.DB, cartID uint, keys []stockKey, quantities []uint) error {
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for i, key := range keys {
+		err := tx.
+			Model(&models.CartItem{}).
+			Where("cart_id = ?", cartID).
+			Scopes(matchStockKeys([]stockKey{key})).
+			Update("quantity", gorm.Expr("CASE WHEN quantity > ? THEN quantity - ? ELSE 0 END", quantities[i], quantities[i])).
+			Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err := tx.
+		Where("cart_id = ? AND quantity = 0", cartID).
+		Scopes(matchStockKeys(keys)).
+		Delete(&models.CartItem{}).
+		Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
 func GetOrderListHandler(c *gin.Context, db *gorm.DB) {
 	userID, ok := c.Get("UserID")
 	if !ok {
@@ -208,7 +301,9 @@ func GetOrderDataHandler(c *gin.Context, db *gorm.DB) {
 	err := db.
 		Where("id = ? AND user_id = ?", orderID, userID).
 		Preload("OrderItems").
-		Preload("OrderItems.Product").
+		Preload("OrderItems.Product", func(db *gorm.DB) *gorm.DB {
+			return db.Unscoped()
+		}).
 		First(&order).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -220,12 +315,17 @@ func GetOrderDataHandler(c *gin.Context, db *gorm.DB) {
 
 	var orderItemsData []gin.H
 	for _, orderItem := range order.OrderItems {
+		name, unitPrice, imageURL := orderItem.Snapshot()
 		orderItemsData = append(orderItemsData, gin.H{
-			"ProductID": orderItem.Product.ID,
-			"Name":      orderItem.Product.Name,
-			"Price":     orderItem.Product.Price,
-			"ImageURL":  orderItem.Product.ImageURL,
-			"Quantity":  orderItem.Quantity,
+			"ProductID":   orderItem.ProductID,
+			"VariantID":   orderItem.VariantID,
+			"SKU":         orderItem.SKU,
+			"OptionLabel": orderItem.OptionLabel,
+			"Name":        name,
+			"Price":       unitPrice,
+			"ImageURL":    imageURL,
+			"Quantity":    orderItem.Quantity,
+			"Subtotal":    orderItem.LineTotal(),
 		})
 	}
 
@@ -236,9 +336,109 @@ func GetOrderDataHandler(c *gin.Context, db *gorm.DB) {
 		"Address":        order.Address,
 		"Phone":          order.Phone,
 		"ShippingMethod": order.ShippingMethod,
+		"Subtotal":       order.Subtotal,
+		"CouponCode":     order.CouponCode,
+		"Discount":       order.Discount,
+		"ShippingFee":    order.ShippingFee,
 		"Total":          order.Total,
 		"OrderTime":      order.CreatedAt,
 		"Status":         order.Status,
 		"orderItemsData": orderItemsData,
 	})
 }
+
+// 取消尚未付款的訂單並將商品數量加回庫存
+func CancelOrderHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	orderID := c.Param("orderID")
+	userID, ok := c.Get("UserID")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法取得使用者ID",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	var order models.Order
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND user_id = ?", orderID, userID).
+		Preload("OrderItems").
+		First(&order).
+		Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此訂單",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢訂單失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//使用者只能取消尚未付款的訂單，其餘須由管理員處理
+	if order.Status != models.OrderStatusPending && order.Status != models.OrderStatusPaymentFailed {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "此訂單狀態無法取消",
+			"status":  order.Status,
+		})
+		return
+	}
+
+	err = changeOrderStatus(tx, &order, models.OrderStatusCancelled, userID.(uint), models.OrderActorUser, "使用者取消訂單")
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "更新訂單狀態失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	err, msg := releaseCancelledOrder(tx, &order)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": msg,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	refreshProductCache(c, db, rdb, orderItemProductIDs(&order)...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功取消訂單",
+		"OrderID": order.ID,
+		"Status":  order.Status,
+	})
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"Backend/models"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 結帳後只釋放訂單中商品的保留，沒有規格的商品以VariantID為0保留
+func TestSendOrderReleasesOrderedReservations(t *testing.T) {
+	db, rdb := newTestStore(t)
+	const userID = 1
+
+	plain := createTestProduct(t, db, "Cable", 300, 10)
+	other := createTestProduct(t, db, "Adapter", 500, 10)
+	sized := createTestProduct(t, db, "Shirt", 800, 0, 5, 5)
+	variantID := sized.Variants[0].ID
+	createTestCart(t, db, userID,
+		models.CartItem{ProductID: plain.ID, Quantity: 2},
+		models.CartItem{ProductID: other.ID, Quantity: 1},
+		models.CartItem{ProductID: sized.ID, VariantID: &variantID, Quantity: 3},
+	)
+	createTestShippingMethod(t, db)
+
+	recorder := performRequest(t, func(c *gin.Context) { ReserveCheckoutHandler(c, db, rdb) }, userID, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("reserve status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = sendTestOrder(t, db, rdb, userID, "",
+		gin.H{"productID": plain.ID, "quantity": 2},
+		gin.H{"productID": sized.ID, "variantID": variantID, "quantity": 1},
+	)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("order status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var reservations []models.StockReservation
+	db.Where("user_id = ?", userID).Order("product_id ASC").Find(&reservations)
+	if len(reservations) != 1 || reservations[0].ProductID != other.ID {
+		t.Fatalf("reservations after checkout = %+v, want only product %d", reservations, other.ID)
+	}
+
+	var product models.Product
+	db.First(&product, plain.ID)
+	if product.Stock != 8 {
+		t.Errorf("stock of ordered product = %d, want 8", product.Stock)
+	}
+
+	//購物車扣除已下單的數量，數量歸零的商品才移除
+	var cartItems []models.CartItem
+	db.Order("product_id ASC").Find(&cartItems)
+	if len(cartItems) != 2 {
+		t.Fatalf("cart items = %+v, want 2 items", cartItems)
+	}
+	if cartItems[0].ProductID != other.ID || cartItems[0].Quantity != 1 {
+		t.Errorf("cart item = %+v, want product %d quantity 1", cartItems[0], other.ID)
+	}
+	if cartItems[1].ProductID != sized.ID || cartItems[1].Quantity != 2 {
+		t.Errorf("cart item = %+v, want product %d quantity 2", cartItems[1], sized.ID)
+	}
+}
+
+// 取消訂單時已刪除的規格不歸還庫存，商品庫存維持為規格庫存的總和
+func TestCancelOrderSkipsDeletedVariant(t *testing.T) {
+	db, rdb := newTestStore(t)
+	const userID = 1
+
+	//下單時已扣除規格A的2個及規格B的1個
+	product := createTestProduct(t, db, "Shirt", 800, 0, 3, 4)
+	removed, kept := product.Variants[0], product.Variants[1]
+	order := createTestOrder(t, db, userID, 2400,
+		models.OrderItem{ProductID: product.ID, VariantID: &removed.ID, Quantity: 2, UnitPrice: 800},
+		models.OrderItem{ProductID: product.ID, VariantID: &kept.ID, Quantity: 1, UnitPrice: 800},
+	)
+
+	//刪除規格A，商品庫存只剩規格B的庫存
+	db.Delete(&removed)
+	db.Model(&product).Update("stock", kept.Stock)
+
+	recorder := cancelTestOrder(t, db, rdb, userID, order.ID)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var variants []models.ProductVariant
+	db.Unscoped().Where("product_id = ?", product.ID).Order("id ASC").Find(&variants)
+	if variants[0].Stock != 3 || !variants[0].DeletedAt.Valid {
+		t.Errorf("deleted variant = %+v, should stay deleted with stock 3", variants[0])
+	}
+	if variants[1].Stock != 5 {
+		t.Errorf("stock of kept variant = %d, want 5", variants[1].Stock)
+	}
+	var stored models.Product
+	db.First(&stored, product.ID)
+	if stored.Stock != 5 {
+		t.Errorf("product stock = %d, want 5 (sum of remaining variants)", stored.Stock)
+	}
+}
+
+// 以使用者身分結帳，couponCode為空時不使用優惠券
+func sendTestOrder(t *testing.T, db *gorm.DB, rdb *redis.Client, userID uint, couponCode string, items ...gin.H) *httptest.ResponseRecorder {
+	t.Helper()
+	return performRequest(t, func(c *gin.Context) { SendOrderHandler(c, db, rdb) }, userID, gin.H{
+		"name":           "tester",
+		"address":        "address",
+		"phone":          "0912345678",
+		"shippingMethod": "home",
+		"orderItems":     items,
+		"couponCode":     couponCode,
+	})
+}
+
+// 取消使用者的訂單
+func cancelTestOrder(t *testing.T, db *gorm.DB, rdb *redis.Client, userID uint, orderID uint) *httptest.ResponseRecorder {
+	t.Helper()
+	return performRequest(t, func(c *gin.Context) { CancelOrderHandler(c, db, rdb) }, userID, nil,
+		gin.Param{Key: "orderID", Value: fmt.Sprint(orderID)})
+}
+
+// 取消訂單時加回商品及規格的庫存，已取消或其他使用者的訂單不可取消
+func TestCancelOrderRestoresStock(t *testing.T) {
+	db, rdb := newTestStore(t)
+	const userID = 1
+
+	plain := createTestProduct(t, db, "Cable", 300, 10)
+	sized := createTestProduct(t, db, "Shirt", 800, 0, 5, 5)
+	variantID := sized.Variants[1].ID
+	createTestCart(t, db, userID,
+		models.CartItem{ProductID: plain.ID, Quantity: 3},
+		models.CartItem{ProductID: sized.ID, VariantID: &variantID, Quantity: 2},
+	)
+	createTestShippingMethod(t, db)
+
+	recorder := sendTestOrder(t, db, rdb, userID, "",
+		gin.H{"productID": plain.ID, "quantity": 3},
+		gin.H{"productID": sized.ID, "variantID": variantID, "quantity": 2},
+	)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("order status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	var order models.Order
+	db.Where("user_id = ?", userID).First(&order)
+
+	stockOf := func() (uint, uint, uint) {
+		var product, variantProduct models.Product
+		var variant models.ProductVariant
+		db.First(&product, plain.ID)
+		db.First(&variantProduct, sized.ID)
+		db.First(&variant, variantID)
+		return product.Stock, variantProduct.Stock, variant.Stock
+	}
+	if plainStock, sizedStock, variantStock := stockOf(); plainStock != 7 || sizedStock != 8 || variantStock != 3 {
+		t.Fatalf("stock after order = %d, %d, %d, want 7, 8, 3", plainStock, sizedStock, variantStock)
+	}
+
+	//其他使用者無法取消
+	if recorder := cancelTestOrder(t, db, rdb, 2, order.ID); recorder.Code != http.StatusNotFound {
+		t.Errorf("cancel by other user status = %d, want 404", recorder.Code)
+	}
+
+	if recorder := cancelTestOrder(t, db, rdb, userID, order.ID); recorder.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if plainStock, sizedStock, variantStock := stockOf(); plainStock != 10 || sizedStock != 10 || variantStock != 5 {
+		t.Errorf("stock after cancel = %d, %d, %d, want 10, 10, 5", plainStock, sizedStock, variantStock)
+	}
+
+	var history models.OrderStatusHistory
+	db.Where("order_id = ?", order.ID).Last(&history)
+	if history.ToStatus != models.OrderStatusCancelled || history.ChangedByRole != models.OrderActorUser {
+		t.Errorf("status history = %+v, want cancelled by user", history)
+	}
+
+	//已取消的訂單不可再取消，庫存不會重複加回
+	if recorder := cancelTestOrder(t, db, rdb, userID, order.ID); recorder.Code != http.StatusBadRequest {
+		t.Errorf("second cancel status = %d, want 400", recorder.Code)
+	}
+	if plainStock, sizedStock, variantStock := stockOf(); plainStock != 10 || sizedStock != 10 || variantStock != 5 {
+		t.Errorf("stock after second cancel = %d, %d, %d, want 10, 10, 5", plainStock, sizedStock, variantStock)
+	}
+}
+
+// 訂單金額依資料庫價格及數量計算，不使用請求中的價格
+func TestSendOrderPricesItemsFromDatabase(t *testing.T) {
+	db, rdb := newTestStore(t)
+	const userID = 1
+
+	plain := createTestProduct(t, db, "Cable", 300, 10)
+	sized := createTestProduct(t, db, "Shirt", 800, 0, 5, 5)
+	variantPrice := uint(950)
+	db.Model(&sized.Variants[1]).Update("price", variantPrice)
+	variantID := sized.Variants[1].ID
+	createTestCart(t, db, userID,
+		models.CartItem{ProductID: plain.ID, Quantity: 3},
+		models.CartItem{ProductID: sized.ID, VariantID: &variantID, Quantity: 2},
+	)
+	createTestShippingMethod(t, db)
+
+	recorder := sendTestOrder(t, db, rdb, userID, "",
+		gin.H{"productID": plain.ID, "quantity": 3, "price": 1},
+		gin.H{"productID": sized.ID, "variantID": variantID, "quantity": 2, "price": 1},
+	)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("order status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var order models.Order
+	db.Preload("OrderItems").Where("user_id = ?", userID).First(&order)
+	if order.Subtotal != 300*3+950*2 || order.ShippingFee != 100 || order.Total != order.Subtotal+100 {
+		t.Errorf("subtotal = %d, shipping = %d, total = %d, want 2800, 100, 2900", order.Subtotal, order.ShippingFee, order.Total)
+	}
+	if len(order.OrderItems) != 2 || order.OrderItems[0].UnitPrice != 300 || order.OrderItems[1].UnitPrice != 950 {
+		t.Errorf("order items = %+v, want unit prices 300 and 950", order.OrderItems)
+	}
+}
+
+// 數量為0、重複、不在購物車內或超過購物車數量的商品不可結帳
+func TestSendOrderRejectsInvalidItems(t *testing.T) {
+	db, rdb := newTestStore(t)
+	const userID = 1
+
+	inCart := createTestProduct(t, db, "Cable", 300, 10)
+	notInCart := createTestProduct(t, db, "Adapter", 500, 10)
+	createTestCart(t, db, userID, models.CartItem{ProductID: inCart.ID, Quantity: 2})
+	createTestShippingMethod(t, db)
+
+	cases := []struct {
+		name  string
+		items []gin.H
+	}{
+		{"數量為0", []gin.H{{"productID": inCart.ID, "quantity": 0}}},
+		{"重複商品", []gin.H{{"productID": inCart.ID, "quantity": 1}, {"productID": inCart.ID, "quantity": 1}}},
+		{"不在購物車內", []gin.H{{"productID": notInCart.ID, "quantity": 1}}},
+		{"超過購物車數量", []gin.H{{"productID": inCart.ID, "quantity": 3}}},
+		{"沒有商品", []gin.H{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := sendTestOrder(t, db, rdb, userID, "", tc.items...)
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400, body = %s", recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	var orders int64
+	db.Model(&models.Order{}).Count(&orders)
+	if orders != 0 {
+		t.Errorf("orders = %d, want 0", orders)
+	}
+	var product models.Product
+	db.First(&product, inCart.ID)
+	if product.Stock != 10 {
+		t.Errorf("stock = %d, want 10", product.Stock)
+	}
+}
+
+// 訂單顯示結帳當下的商品名稱、單價及圖片，商品之後修改或刪除不影響
+func TestOrderDataKeepsCheckoutSnapshot(t *testing.T) {
+	db, rdb := newTestStore(t)
+	const userID = 1
+
+	product := createTestProduct(t, db, "Cable", 300, 10)
+	createTestCart(t, db, userID, models.CartItem{ProductID: product.ID, Quantity: 2})
+	createTestShippingMethod(t, db)
+	if recorder := sendTestOrder(t, db, rdb, userID, "", gin.H{"productID": product.ID, "quantity": 2}); recorder.Code != http.StatusOK {
+		t.Fatalf("order status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	var order models.Order
+	db.Where("user_id = ?", userID).First(&order)
+
+	db.Model(&product).Updates(models.Product{Name: "USB Cable", Price: 500, ImageURL: "/images/new"})
+	db.Delete(&product)
+
+	recorder := performRequest(t, func(c *gin.Context) { GetOrderDataHandler(c, db) }, userID, nil,
+		gin.Param{Key: "orderID", Value: fmt.Sprint(order.ID)})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("order data status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	response := decodeResponse(t, recorder)
+	item := response["orderItemsData"].([]interface{})[0].(map[string]interface{})
+	if item["Name"] != "Cable" || item["Price"] != float64(300) || item["ImageURL"] != "/images/Cable" {
+		t.Errorf("order item = %v, want the product at checkout", item)
+	}
+	if item["Subtotal"] != float64(600) || response["Subtotal"] != float64(600) {
+		t.Errorf("item subtotal = %v, order subtotal = %v, want 600", item["Subtotal"], response["Subtotal"])
+	}
+}
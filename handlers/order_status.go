@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"Backend/models"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+)
+
+var ErrInvalidOrderStatusTransition = errors.New("不允許的訂單狀態變更")
+
+// 變更訂單狀態並寫入狀態紀錄，所有訂單狀態變更都須經過此函式
+// 付款結果只能由金流回呼設定，tx應在事務中且order已上鎖
+func changeOrderStatus(tx *gorm.DB, order *models.Order, toStatus string, changedBy uint, changedByRole string, note string) error {
+	if !models.CanActorTransitionOrderStatus(order.Status, toStatus, changedByRole) {
+		return ErrInvalidOrderStatusTransition
+	}
+
+	fromStatus := order.Status
+	err := tx.Model(order).Update("status", toStatus).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Create(&models.OrderStatusHistory{
+		OrderID:       order.ID,
+		FromStatus:    fromStatus,
+		ToStatus:      toStatus,
+		ChangedBy:     changedBy,
+		ChangedByRole: changedByRole,
+		Note:          note,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	order.Status = toStatus
+	return nil
+}
+
+// 將訂單商品數量加回庫存，事務提交後須以orderItemProductIDs更新商品快取
+// tx應在事務中，order須已載入OrderItems
+func restoreOrderStock(tx *gorm.DB, order *models.Order) (err error, msg string) {
+	//依商品ID及規格ID順序上鎖，避免與其他事務互相等待
+	quantities := make(map[stockKey]uint, len(order.OrderItems))
+	keys := make([]stockKey, 0, len(order.OrderItems))
+	for _, orderItem := range order.OrderItems {
+		key := stockKey{ProductID: orderItem.ProductID, VariantID: variantIDValue(orderItem.VariantID)}
+		if _, ok := quantities[key]; !ok {
+			keys = append(keys, key)
+		}
+		quantities[key] += orderItem.Quantity
+	}
+	sortStockKeys(keys)
+
+	for _, key := range keys {
+		var product models.Product
+		err = tx.
+			Unscoped().
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&product, key.ProductID).
+			Error
+		if err != nil {
+			return err, "查詢訂單商品失敗"
+		}
+
+		//規格已被刪除時不歸還庫存，商品庫存須維持為所有規格庫存的總和
+		var variant *models.ProductVariant
+		if key.VariantID != 0 {
+			var productVariant models.ProductVariant
+			err = tx.
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("product_id = ?", key.ProductID).
+				First(&productVariant, key.VariantID).
+				Error
+			if err == gorm.ErrRecordNotFound {
+				log.Printf("訂單%d的商品%d規格%d已刪除，不歸還庫存%d\n", order.ID, key.ProductID, key.VariantID, quantities[key])
+				continue
+			}
+			if err != nil {
+				return err, "查詢訂單商品規格失敗"
+			}
+			variant = &productVariant
+		}
+
+		err = adjustStock(tx, &product, variant, int(quantities[key]))
+		if err != nil {
+			return err, "更新庫存失敗"
+		}
+	}
+
+	return nil, ""
+}
+
+// 訂單取消時加回庫存並歸還優惠券使用次數
+// tx應在事務中，order須已載入OrderItems
+func releaseCancelledOrder(tx *gorm.DB, order *models.Order) (err error, msg string) {
+	err, msg = restoreOrderStock(tx, order)
+	if err != nil {
+		return err, msg
+	}
+
+	err = releaseOrderCoupon(tx, order)
+	if err != nil {
+		return err, "歸還優惠券失敗"
+	}
+
+	return nil, ""
+}
+
+// 訂單中的商品ID，不重複，用於事務提交後更新商品快取
+func orderItemProductIDs(order *models.Order) []uint {
+	seen := make(map[uint]bool, len(order.OrderItems))
+	var productIDs []uint
+	for _, orderItem := range order.OrderItems {
+		if !seen[orderItem.ProductID] {
+			seen[orderItem.ProductID] = true
+			productIDs = append(productIDs, orderItem.ProductID)
+		}
+	}
+	return productIDs
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"Backend/models"
+	"Backend/payment"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func generateTradeNo() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// 開始付款訂單
+func StartPaymentHandler(c *gin.Context, db *gorm.DB) {
+	orderID := c.Param("orderID")
+	userID, ok := c.Get("UserID")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法取得使用者ID",
+		})
+		return
+	}
+
+	var paymentReq struct {
+		Provider string `json:"provider" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&paymentReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	provider, err := payment.GetProvider(paymentReq.Provider)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "不支援的付款方式",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var order models.Order
+	err = db.Where("id = ? AND user_id = ?", orderID, userID).First(&order).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此訂單",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢訂單失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//只有可變更為已付款的訂單可以付款
+	if !models.CanTransitionOrderStatus(order.Status, models.OrderStatusPaid) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "此訂單狀態無法付款",
+			"status":  order.Status,
+		})
+		return
+	}
+
+	newPayment := models.Payment{
+		OrderID:  order.ID,
+		Provider: provider.Name(),
+		TradeNo:  generateTradeNo(),
+		Amount:   order.Total,
+		Status:   models.PaymentStatusPending,
+	}
+	err = db.Create(&newPayment).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "建立付款失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	session, err := provider.CreatePayment(&newPayment)
+	if err != nil {
+		newPayment.Status = models.PaymentStatusFailed
+		newPayment.FailReason = err.Error()
+		if err := db.Save(&newPayment).Error; err != nil {
+			log.Printf("更新付款狀態失敗 PaymentID: %d, Error: %v", newPayment.ID, err)
+		}
+		c.JSON(http.StatusBadGateway, gin.H{
+			"message": "金流建立付款失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	newPayment.TransactionID = session.TransactionID
+	err = db.Save(&newPayment).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "儲存付款資料失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "成功建立付款",
+		"paymentID":     newPayment.ID,
+		"tradeNo":       newPayment.TradeNo,
+		"transactionID": newPayment.TransactionID,
+		"amount":        newPayment.Amount,
+		"paymentURL":    session.PaymentURL,
+	})
+}
+
+// 接收金流供應商付款結果回呼
+func PaymentCallbackHandler(c *gin.Context, db *gorm.DB) {
+	provider, err := payment.GetProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := provider.ParseCallback(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "解析付款回呼失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	var targetPayment models.Payment
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("trade_no = ? AND provider = ?", result.TradeNo, provider.Name()).
+		First(&targetPayment).
+		Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此付款",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢付款失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//金流可能重複回呼，已處理過的付款直接回傳結果
+	if targetPayment.Status != models.PaymentStatusPending {
+		tx.Rollback()
+		c.JSON(http.StatusOK, gin.H{
+			"message": "付款已處理",
+			"status":  targetPayment.Status,
+		})
+		return
+	}
+
+	var order models.Order
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&order, targetPayment.OrderID).
+		Error
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢訂單失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if result.TransactionID != "" {
+		targetPayment.TransactionID = result.TransactionID
+	}
+
+	//付款成功但金額與付款不符時視為付款失敗，可能是部分付款或回呼遭竄改
+	if result.Success && result.Amount != targetPayment.Amount {
+		log.Printf("付款金額不符 OrderID: %d, TradeNo: %s, 應付: %d, 實付: %d", order.ID, targetPayment.TradeNo, targetPayment.Amount, result.Amount)
+		result.Success = false
+		result.Message = "付款金額不符"
+	}
+
+	var toStatus string
+	if result.Success {
+		now := time.Now()
+		targetPayment.Status = models.PaymentStatusPaid
+		targetPayment.PaidAt = &now
+		toStatus = models.OrderStatusPaid
+	} else {
+		targetPayment.Status = models.PaymentStatusFailed
+		targetPayment.FailReason = result.Message
+		toStatus = models.OrderStatusPaymentFailed
+	}
+
+	err = tx.Save(&targetPayment).Error
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "更新付款狀態失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if models.CanTransitionOrderStatus(order.Status, toStatus) {
+		err = changeOrderStatus(tx, &order, toStatus, 0, models.OrderActorSystem, "金流回呼:"+provider.Name()+" "+targetPayment.TradeNo)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "更新訂單狀態失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	} else if result.Success {
+		//訂單已不在可付款狀態(例如已取消)，需人工退款
+		log.Printf("訂單狀態為%s但收到付款成功 OrderID: %d, TradeNo: %s", order.Status, order.ID, targetPayment.TradeNo)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "成功處理付款結果",
+		"status":      targetPayment.Status,
+		"orderStatus": order.Status,
+	})
+}
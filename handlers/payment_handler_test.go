@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"Backend/models"
+	"Backend/payment"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"testing"
+)
+
+func init() {
+	payment.Register(payment.NewLocalProvider())
+}
+
+// 以本地金流開始付款訂單，回傳交易編號
+func startTestPayment(t *testing.T, db *gorm.DB, order models.Order) string {
+	t.Helper()
+	recorder := performRequest(t, func(c *gin.Context) { StartPaymentHandler(c, db) }, order.UserID,
+		gin.H{"provider": "local"},
+		gin.Param{Key: "orderID", Value: fmt.Sprint(order.ID)})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("start payment status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	return decodeResponse(t, recorder)["tradeNo"].(string)
+}
+
+// 送出本地金流的付款結果回呼
+func sendTestCallback(t *testing.T, db *gorm.DB, tradeNo string, amount uint, success bool) map[string]interface{} {
+	t.Helper()
+	recorder := performRequest(t, func(c *gin.Context) { PaymentCallbackHandler(c, db) }, 0,
+		gin.H{"tradeNo": tradeNo, "amount": amount, "success": success},
+		gin.Param{Key: "provider", Value: "local"})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("callback status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	return decodeResponse(t, recorder)
+}
+
+// 付款成功但金額不符時視為付款失敗，訂單不會變為已付款
+func TestPaymentCallbackAmountMismatch(t *testing.T) {
+	db, _ := newTestStore(t)
+	order := createTestOrder(t, db, 1, 1000)
+	tradeNo := startTestPayment(t, db, order)
+
+	response := sendTestCallback(t, db, tradeNo, 900, true)
+	if response["status"] != models.PaymentStatusFailed || response["orderStatus"] != models.OrderStatusPaymentFailed {
+		t.Errorf("response = %v, want payment and order failed", response)
+	}
+
+	var stored models.Payment
+	db.Where("trade_no = ?", tradeNo).First(&stored)
+	if stored.Status != models.PaymentStatusFailed || stored.PaidAt != nil {
+		t.Errorf("payment = %+v, want failed without PaidAt", stored)
+	}
+	if stored.FailReason != "付款金額不符" {
+		t.Errorf("fail reason = %q, want 付款金額不符", stored.FailReason)
+	}
+
+	//金額不符的付款失敗後可重新付款
+	tradeNo = startTestPayment(t, db, order)
+	response = sendTestCallback(t, db, tradeNo, 1000, true)
+	if response["orderStatus"] != models.OrderStatusPaid {
+		t.Errorf("order status after paying the full amount = %v, want %s", response["orderStatus"], models.OrderStatusPaid)
+	}
+}
+
+// 重複的回呼不會再次變更付款及訂單狀態
+func TestPaymentCallbackDuplicate(t *testing.T) {
+	db, _ := newTestStore(t)
+	order := createTestOrder(t, db, 1, 1000)
+	tradeNo := startTestPayment(t, db, order)
+
+	response := sendTestCallback(t, db, tradeNo, 1000, true)
+	if response["status"] != models.PaymentStatusPaid || response["orderStatus"] != models.OrderStatusPaid {
+		t.Fatalf("response = %v, want paid", response)
+	}
+
+	//重複的成功及之後的失敗回呼都只回傳已處理的結果
+	for _, success := range []bool{true, false} {
+		response = sendTestCallback(t, db, tradeNo, 1000, success)
+		if response["message"] != "付款已處理" || response["status"] != models.PaymentStatusPaid {
+			t.Errorf("duplicate callback (success=%v) response = %v, want already processed", success, response)
+		}
+	}
+
+	var stored models.Order
+	db.First(&stored, order.ID)
+	if stored.Status != models.OrderStatusPaid {
+		t.Errorf("order status = %q, want %s", stored.Status, models.OrderStatusPaid)
+	}
+	var histories int64
+	db.Model(&models.OrderStatusHistory{}).Where("order_id = ?", order.ID).Count(&histories)
+	if histories != 1 {
+		t.Errorf("status history count = %d, want 1", histories)
+	}
+}
+
+// 訂單取消後才收到付款成功時記錄付款，但訂單維持已取消
+func TestPaymentCallbackAfterCancel(t *testing.T) {
+	db, rdb := newTestStore(t)
+	product := createTestProduct(t, db, "Cable", 1000, 9)
+	order := createTestOrder(t, db, 1, 1000, models.OrderItem{ProductID: product.ID, Quantity: 1, UnitPrice: 1000})
+	tradeNo := startTestPayment(t, db, order)
+
+	recorder := performRequest(t, func(c *gin.Context) { CancelOrderHandler(c, db, rdb) }, order.UserID, nil,
+		gin.Param{Key: "orderID", Value: fmt.Sprint(order.ID)})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	response := sendTestCallback(t, db, tradeNo, 1000, true)
+	if response["status"] != models.PaymentStatusPaid {
+		t.Errorf("payment status = %v, want %s", response["status"], models.PaymentStatusPaid)
+	}
+	if response["orderStatus"] != models.OrderStatusCancelled {
+		t.Errorf("order status = %v, want %s", response["orderStatus"], models.OrderStatusCancelled)
+	}
+
+	//已取消訂單的庫存不會因付款而再次扣除
+	var stored models.Product
+	db.First(&stored, product.ID)
+	if stored.Stock != 10 {
+		t.Errorf("stock = %d, want 10", stored.Stock)
+	}
+}
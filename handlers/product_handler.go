@@ -1,180 +1,227 @@
 package handlers
 
 import (
+	"Backend/cache"
+	"Backend/catalog"
 	"Backend/models"
-	"encoding/json"
-	"fmt"
+	"Backend/reservation"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 )
 
-func min(x, y int) int {
-	if x < y {
-		return x
+// 查詢商品列表，可依價格、庫存及標籤篩選並排序，回傳各標籤及價格區間的商品數量
+// 回應帶有ETag，列表內容及可購買庫存未變更時回應304
+func GetProductListHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	limit := c.DefaultQuery("limit", "10")
+	limitInt, err := strconv.Atoi(limit)
+	if err != nil || limitInt < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "查詢數量輸入錯誤",
+		})
+		return
 	}
-	return y
-}
-
-// 重新將商品列表加入Redis
-func ReAddAllProductsToRedis(c *gin.Context, db *gorm.DB, rdb *redis.Client) (err error, message string) {
-	var products []models.Product
-	err = db.Preload("Categories").Find(&products).Error
-	if err != nil {
-		return err, "無法讀取商品列表"
+	//限制最高查詢數量為50
+	if limitInt > 50 {
+		limitInt = 50
 	}
 
-	pipe := rdb.TxPipeline()
-
-	pipe.Del(c, "products")
-
-	var zProducts = make([]redis.Z, len(products))
-	for _, product := range products {
-		productJSON, err := json.Marshal(product)
-		if err != nil {
-			fmt.Printf("無法序列化商品資料: %v\n", err)
-			continue
-		}
-
-		zProducts = append(zProducts, redis.Z{
-			Score:  float64(product.ID),
-			Member: productJSON,
+	offset := c.DefaultQuery("offset", "0")
+	offsetInt, err := strconv.Atoi(offset)
+	if err != nil || offsetInt < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "offset輸入錯誤",
 		})
+		return
 	}
 
-	pipe.ZAdd(c, "products", zProducts...)
+	query := catalog.Query{
+		InStockOnly:      c.Query("inStock") == "true",
+		MatchAnyCategory: c.DefaultQuery("categoryMatch", "all") == "any",
+		Sort:             catalog.SortOrder(c.DefaultQuery("sort", string(catalog.SortByID))),
+		Offset:           offsetInt,
+		Limit:            limitInt,
+	}
+	if !catalog.IsValidSortOrder(query.Sort) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "排序方式輸入錯誤",
+			"sort":    query.Sort,
+		})
+		return
+	}
 
-	_, err = pipe.Exec(c)
+	query.MinPrice, err = parseOptionalUint(c.Query("minPrice"))
 	if err != nil {
-		return err, "無法重新將商品列表加入Redis"
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "最低價格輸入錯誤",
+		})
+		return
 	}
-
-	return nil, ""
-}
-
-func UpdateProductToRedis(c *gin.Context, rdb *redis.Client, product *models.Product) (err error, msg string) {
-	productJSON, err := json.Marshal(product)
+	query.MaxPrice, err = parseOptionalUint(c.Query("maxPrice"))
 	if err != nil {
-		return err, "無法序列化商品資料"
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "最高價格輸入錯誤",
+		})
+		return
 	}
-
-	score := strconv.Itoa(int(product.ID))
-
-	pipe := rdb.TxPipeline()
-
-	pipe.ZRemRangeByScore(c, "products", score, score)
-	pipe.ZAdd(c, "products", redis.Z{
-		Score:  float64(product.ID),
-		Member: productJSON,
-	})
-
-	_, err = pipe.Exec(c)
+	categoryIDs, err := parseUintList(c.Query("categories"))
 	if err != nil {
-		return err, "無法更新商品至Redis"
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "標籤輸入錯誤",
+		})
+		return
+	}
+	excludeCategoryIDs, err := parseUintList(c.Query("excludeCategories"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "排除標籤輸入錯誤",
+		})
+		return
 	}
 
-	return nil, ""
-}
+	bucketBounds := defaultPriceBucketBounds
+	if c.Query("priceBuckets") != "" {
+		bucketBounds, err = parseUintList(c.Query("priceBuckets"))
+		if err != nil || !sort.SliceIsSorted(bucketBounds, func(i, j int) bool { return bucketBounds[i] < bucketBounds[j] }) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "價格區間輸入錯誤",
+			})
+			return
+		}
+	}
+	buckets := priceBuckets(bucketBounds)
+	query.PriceBuckets = buckets
 
-// 查詢商品列表
-func GetProductListHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
-	limit := c.DefaultQuery("limit", "10")
-	limitInt, err := strconv.Atoi(limit)
+	tree, err := loadCategoryTree(db)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "查詢數量輸入錯誤",
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取商品標籤列表",
 			"error":   err.Error(),
 		})
+		return
 	}
-	//限制最高查詢數量為50
-	if limitInt > 50 {
-		limitInt = 50
+	//上層分類包含所有子分類的商品
+	query.Categories = tree.expand(categoryIDs)
+	query.ExcludeCategories = tree.flatten(excludeCategoryIDs)
+	query.FacetCategories = tree.facetGroups()
+	categories := tree.sorted()
+
+	//Redis無法使用時改由資料庫查詢
+	result, err := findProducts(c, db, rdb, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取商品列表",
+			"error":   err.Error(),
+		})
+		return
 	}
 
-	offset := c.DefaultQuery("offset", "0")
-	offsetInt, err := strconv.Atoi(offset)
+	products, err := loadProductsByIDs(c, db, rdb, result.ProductIDs)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "offset輸入錯誤",
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取商品資料",
 			"error":   err.Error(),
 		})
+		return
 	}
 
-	//嘗試從Redis讀取商品列表，如失敗則從資料庫讀取並儲存至Redis
-	redisProducts, err := rdb.ZRange(c, "products", int64(offsetInt), int64(offsetInt+limitInt-1)).Result()
-	if err != nil || rdb.ZCard(c, "products").Val() == 0 {
-		log.Println("Redis error: ", err)
-
-		err, message := ReAddAllProductsToRedis(c, db, rdb)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": message,
-				"error":   err.Error(),
-			})
-			return
-		}
+	//庫存扣除結帳中保留的數量
+	reserved := getReservedQuantities(db, result.ProductIDs)
 
-		//再次嘗試從Redis讀取商品列表
-		redisProducts, err = rdb.ZRange(c, "products", int64(offsetInt), int64(offsetInt+limitInt-1)).Result()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "無法從Redis讀取商品列表",
-				"error":   err.Error(),
-			})
-			return
-		}
+	//商品、保留數量、總數、分類、各標籤與價格區間的數量皆未變更時回應304
+	etagValues := []int64{result.Total}
+	for _, category := range categories {
+		etagValues = append(etagValues, int64(category.ID), result.CategoryCounts[category.ID])
+	}
+	etagValues = append(etagValues, result.PriceBucketCounts...)
+	etagValues = append(etagValues, tree.etagValues()...)
+	if respondNotModified(c, productListETag(products, reserved, etagValues...), time.Time{}) {
+		return
 	}
 
-	var productsData []struct {
-		ID       uint
-		Name     string
-		Price    uint
-		Stock    uint
-		ImageURL string
+	type categoryData struct {
+		ID   uint
+		Name string
+		Slug string
+	}
+	type productData struct {
+		ID         uint
+		Name       string
+		Price      uint
+		Stock      uint
+		ImageURL   string
+		Categories []categoryData
+	}
+	productsData := make([]productData, 0, len(products))
+	for _, product := range products {
+		categoriesData := make([]categoryData, len(product.Categories))
+		for i, category := range product.Categories {
+			categoriesData[i] = categoryData{
+				ID:   category.ID,
+				Name: category.Name,
+				Slug: category.Slug,
+			}
+		}
+		productsData = append(productsData, productData{
+			ID:         product.ID,
+			Name:       product.Name,
+			Price:      product.Price,
+			Stock:      availableStock(product.Stock, reserved[product.ID]),
+			ImageURL:   product.ImageURL,
+			Categories: categoriesData,
+		})
 	}
 
-	for _, redisProduct := range redisProducts {
-		var productUnmarshal models.Product
-		err = json.Unmarshal([]byte(redisProduct), &productUnmarshal)
-		if err != nil {
-			fmt.Printf("無法反序列化商品資料: %v\n", err)
+	var categoryFacets []gin.H
+	for _, category := range categories {
+		count := result.CategoryCounts[category.ID]
+		if count == 0 {
 			continue
 		}
-
-		productsData = append(productsData, struct {
-			ID       uint
-			Name     string
-			Price    uint
-			Stock    uint
-			ImageURL string
-		}{
-			ID:       productUnmarshal.ID,
-			Name:     productUnmarshal.Name,
-			Price:    productUnmarshal.Price,
-			Stock:    productUnmarshal.Stock,
-			ImageURL: productUnmarshal.ImageURL,
+		categoryFacets = append(categoryFacets, gin.H{
+			"ID":       category.ID,
+			"Name":     category.Name,
+			"Slug":     category.Slug,
+			"ParentID": category.ParentID,
+			"Count":    count,
 		})
 	}
 
+	priceFacets := make([]gin.H, len(buckets))
+	for i, bucket := range buckets {
+		priceFacets[i] = gin.H{
+			"Min":   bucket.Min,
+			"Max":   bucket.Max,
+			"Count": result.PriceBucketCounts[i],
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "成功讀取商品列表",
 		"products":   productsData,
-		"totalCount": rdb.ZCard(c, "products").Val(),
+		"totalCount": result.Total,
+		"facets": gin.H{
+			"categories":   categoryFacets,
+			"priceBuckets": priceFacets,
+		},
 	})
 }
 
-// 搜尋完整包含標籤的所有商品
+// 搜尋完整包含標籤的所有商品，標籤以查詢參數categories=1,2傳入
+// 以Redis中各標籤的商品集合取交集，不需讀取整個商品列表，Redis無法使用時改由資料庫查詢
 func GetProductsFromCategoriesHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
 	limit := c.DefaultQuery("limit", "10")
 	limitInt, err := strconv.Atoi(limit)
-	if err != nil {
+	if err != nil || limitInt < 1 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "查詢數量輸入錯誤",
-			"error":   err.Error(),
 		})
+		return
 	}
 	//限制最高查詢數量為50
 	if limitInt > 50 {
@@ -183,159 +230,364 @@ func GetProductsFromCategoriesHandler(c *gin.Context, db *gorm.DB, rdb *redis.Cl
 
 	offset := c.DefaultQuery("offset", "0")
 	offsetInt, err := strconv.Atoi(offset)
-	if err != nil {
+	if err != nil || offsetInt < 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "offset輸入錯誤",
+		})
+		return
+	}
+
+	categoryIDs, err := parseUintList(c.Query("categories"))
+	if err != nil || len(categoryIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "請以categories輸入標籤ID",
+		})
+		return
+	}
+
+	tree, err := loadCategoryTree(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取商品標籤列表",
 			"error":   err.Error(),
 		})
+		return
 	}
 
-	var categoriesReq []struct {
-		CategoryID uint `json:"categoryID" binding:"required"`
+	//上層分類包含所有子分類的商品，Redis無法使用時改由資料庫查詢
+	result, err := findProducts(c, db, rdb, catalog.Query{
+		Categories: tree.expand(categoryIDs),
+		Sort:       catalog.SortByID,
+		Offset:     offsetInt,
+		Limit:      limitInt,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取商品列表",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//預防offset超出搜尋結果
+	if result.Total > 0 && int64(offsetInt) >= result.Total {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message":    "offset超過商品數量",
+			"totalCount": result.Total,
+		})
+		return
 	}
-	err = c.ShouldBindJSON(&categoriesReq)
+
+	products, err := loadProductsByIDs(c, db, rdb, result.ProductIDs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "綁定請求資料錯誤",
+			"message": "無法讀取商品資料",
 			"error":   err.Error(),
 		})
 		return
 	}
 
-	redisProducts, err := rdb.ZRange(c, "products", 0, -1).Result()
-	if err != nil || rdb.ZCard(c, "products").Val() == 0 {
-		log.Println("Redis error: ", err)
+	//庫存扣除結帳中保留的數量
+	reserved := getReservedQuantities(db, result.ProductIDs)
 
-		err, message := ReAddAllProductsToRedis(c, db, rdb)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": message,
-				"error":   err.Error(),
-			})
-			return
+	if respondNotModified(c, productListETag(products, reserved, result.Total), time.Time{}) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "成功讀取商品列表",
+		"products":   categoryProductsData(products, reserved),
+		"totalCount": result.Total,
+	})
+}
+
+// 依分類查詢商品時回應的商品資料，庫存扣除保留的數量
+func categoryProductsData(products []models.Product, reserved map[uint]uint) []gin.H {
+	productsData := []gin.H{}
+	for _, product := range products {
+		categoriesData := make([]gin.H, len(product.Categories))
+		for i, category := range product.Categories {
+			categoriesData[i] = gin.H{
+				"name": category.Name,
+				"slug": category.Slug,
+				"ID":   category.ID,
+			}
 		}
+		productsData = append(productsData, gin.H{
+			"ID":         product.ID,
+			"name":       product.Name,
+			"price":      product.Price,
+			"stock":      availableStock(product.Stock, reserved[product.ID]),
+			"imageURL":   product.ImageURL,
+			"Categories": categoriesData,
+		})
+	}
+	return productsData
+}
+
+// 查詢商品詳細資料，優先從Redis商品快取讀取，快取中沒有或Redis無法使用時從資料庫讀取
+// 回應帶有ETag，商品及可購買庫存未變更時回應304
+func GetProductDataHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	productID, err := strconv.ParseUint(c.Param("productID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "商品ID輸入錯誤",
+		})
+		return
+	}
 
-		//再次嘗試從Redis讀取商品列表
-		redisProducts, err = rdb.ZRange(c, "products", 0, -1).Result()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": "無法從Redis讀取商品列表",
-				"error":   err.Error(),
+	product, err := cache.NewProductCache(db, rdb).GetDetail(c, uint(productID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "查無此商品",
 			})
 			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢商品資料失敗",
+			"error":   err.Error(),
+		})
+		return
 	}
 
-	//遍歷從Redis讀出的商品列表，找出含有所有標籤的商品
-	var productsData []gin.H
-	for _, redisProduct := range redisProducts {
-		var productUnmarshal models.Product
-		err = json.Unmarshal([]byte(redisProduct), &productUnmarshal)
-		if err != nil {
-			fmt.Printf("無法反序列化商品資料: %v\n", err)
-			continue
-		}
+	//庫存扣除結帳中保留的數量
+	reserved := getReservedQuantities(db, []uint{product.ID})
 
-		hasALLTags := true
+	variantIDs := make([]uint, len(product.Variants))
+	for i, variant := range product.Variants {
+		variantIDs[i] = variant.ID
+	}
+	reservedVariants, err := reservation.ReservedVariantQuantities(db, variantIDs, 0)
+	if err != nil {
+		log.Printf("查詢保留庫存失敗: %v\n", err)
+		reservedVariants = map[uint]uint{}
+	}
 
-		for _, categoryReq := range categoriesReq {
-			found := false
-			for _, productCategory := range productUnmarshal.Categories {
-				if productCategory.ID == categoryReq.CategoryID {
-					found = true
-					break
-				}
-			}
-			if !found {
-				hasALLTags = false
-				break
-			}
-		}
+	//保留的建立、釋放及清除都會更新商品的updated_at，已過期但未清除的保留以過期時間為準
+	lastModified := product.UpdatedAt
+	latestExpiry, err := reservation.LatestExpiry(db, []uint{product.ID})
+	if err != nil {
+		log.Printf("查詢保留庫存失敗: %v\n", err)
+	} else if latestExpiry[product.ID].After(lastModified) {
+		lastModified = latestExpiry[product.ID]
+	}
 
-		if hasALLTags == true {
-			categoriesData := make([]gin.H, len(productUnmarshal.Categories))
-			for i, category := range productUnmarshal.Categories {
-				categoriesData[i] = gin.H{
-					"name": category.Name,
-					"ID":   category.ID,
-				}
-			}
-			productsData = append(productsData, gin.H{
-				"name":       productUnmarshal.Name,
-				"price":      productUnmarshal.Price,
-				"stock":      productUnmarshal.Stock,
-				"imageURL":   productUnmarshal.ImageURL,
-				"Categories": categoriesData,
-			})
-		}
+	if respondNotModified(c, productETag(&product, reserved[product.ID], reservedVariants), lastModified) {
+		return
 	}
 
-	totalCount := len(productsData)
+	var variantsData []gin.H
+	for _, variant := range product.Variants {
+		variantsData = append(variantsData, gin.H{
+			"ID":          variant.ID,
+			"SKU":         variant.SKU,
+			"Options":     variant.Options,
+			"OptionLabel": variant.OptionsLabel(),
+			"Price":       variant.EffectivePrice(product),
+			"Stock":       availableStock(variant.Stock, reservedVariants[variant.ID]),
+		})
+	}
 
-	//預防offset跟limit超出搜尋結果切片
-	if offsetInt >= totalCount {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message":    "offset超過商品數量",
-			"totalCount": len(productsData),
+	var imagesData []gin.H
+	for _, image := range product.Images {
+		imagesData = append(imagesData, gin.H{
+			"ID":        image.ID,
+			"URL":       image.URL,
+			"AltText":   image.AltText,
+			"IsPrimary": image.IsPrimary,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功查詢商品資料",
+		"product": gin.H{
+			"ID":          product.ID,
+			"Name":        product.Name,
+			"Price":       product.Price,
+			"Stock":       availableStock(product.Stock, reserved[product.ID]),
+			"Description": product.Description,
+			"ImageURL":    product.ImageURL,
+		},
+		"variants": variantsData,
+		"images":   imagesData,
+	})
+}
+
+// 查詢商品標籤列表
+func GetCategoryListHandler(c *gin.Context, db *gorm.DB) {
+	var categories []models.Category
+	err := db.Order("id ASC").Find(&categories).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取商品標籤列表",
+			"error":   err.Error(),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":    "成功讀取商品列表",
-		"products":   productsData[offsetInt:min(offsetInt+limitInt, totalCount)],
-		"totalCount": len(productsData),
+		"message":    "成功讀取商品標籤列表",
+		"categories": categories,
 	})
 }
 
-// 查詢商品詳細資料
-func GetProductDataHandler(c *gin.Context, db *gorm.DB) {
-	productID := c.Param("productID")
+// 查詢所有分類及各分類(含子分類)未刪除且有庫存的商品數量，用於前台建立導覽
+func GetPublicCategoryListHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	tree, err := loadCategoryTree(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取分類列表",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	counts, err := findCategoryCounts(c, db, rdb, tree.facetGroups())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取分類商品數量",
+			"error":   err.Error(),
+		})
+		return
+	}
 
-	var product struct {
-		ID          uint
-		Name        string
-		Price       uint
-		Stock       uint
-		Description string
-		ImageURL    string
+	categoriesData := []gin.H{}
+	for _, category := range tree.sorted() {
+		categoriesData = append(categoriesData, gin.H{
+			"ID":           category.ID,
+			"name":         category.Name,
+			"slug":         category.Slug,
+			"parentID":     category.ParentID,
+			"sortOrder":    category.SortOrder,
+			"productCount": counts[category.ID],
+		})
 	}
-	err := db.Model(&models.Product{}).Where("id = ?", productID).First(&product).Error
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "成功讀取分類列表",
+		"categories": categoriesData,
+	})
+}
+
+// 查詢巢狀的分類樹，同一層的分類依SortOrder及ID排序
+func GetCategoryTreeHandler(c *gin.Context, db *gorm.DB) {
+	tree, err := loadCategoryTree(db)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "查詢商品資料失敗",
+			"message": "無法讀取分類列表",
 			"error":   err.Error(),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "成功查詢商品資料",
-		"product": product,
+		"message":    "成功讀取分類列表",
+		"categories": tree.nodes(0),
 	})
 }
 
-// 查詢商品標籤列表
-func GetCategoryListHandler(c *gin.Context, db *gorm.DB) {
-	var categories []struct {
-		Id   uint
-		Name string
+// 依代稱查詢分類頁面，包含上層分類路徑、子分類及分類(含所有子分類)的商品
+func GetCategoryPageHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	limit := c.DefaultQuery("limit", "10")
+	limitInt, err := strconv.Atoi(limit)
+	if err != nil || limitInt < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "查詢數量輸入錯誤",
+		})
+		return
+	}
+	//限制最高查詢數量為50
+	if limitInt > 50 {
+		limitInt = 50
+	}
+
+	offset := c.DefaultQuery("offset", "0")
+	offsetInt, err := strconv.Atoi(offset)
+	if err != nil || offsetInt < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "offset輸入錯誤",
+		})
+		return
+	}
+
+	var category models.Category
+	err = db.Where("slug = ?", c.Param("slug")).First(&category).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "查無此分類",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢分類失敗",
+			"error":   err.Error(),
+		})
+		return
 	}
-	err := db.
-		Model(&models.Category{}).
-		Select("Id", "Name").
-		Find(&categories).
-		Error
+
+	tree, err := loadCategoryTree(db)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "無法讀取商品標籤列表",
+			"message": "無法讀取分類列表",
 			"error":   err.Error(),
 		})
 		return
 	}
 
+	result, err := findProducts(c, db, rdb, catalog.Query{
+		Categories: tree.expand([]uint{category.ID}),
+		Sort:       catalog.SortByID,
+		Offset:     offsetInt,
+		Limit:      limitInt,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取商品列表",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	products, err := loadProductsByIDs(c, db, rdb, result.ProductIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取商品資料",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//庫存扣除結帳中保留的數量
+	reserved := getReservedQuantities(db, result.ProductIDs)
+
+	//商品、保留數量、總數及分類皆未變更時回應304
+	etagValues := append([]int64{result.Total}, tree.etagValues()...)
+	if respondNotModified(c, productListETag(products, reserved, etagValues...), time.Time{}) {
+		return
+	}
+
+	breadcrumbs := []gin.H{}
+	for _, ancestor := range tree.ancestors(category.ID) {
+		breadcrumbs = append(breadcrumbs, gin.H{
+			"ID":   ancestor.ID,
+			"name": ancestor.Name,
+			"slug": ancestor.Slug,
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message":  "成功讀取商品標籤列表",
-		"products": categories,
+		"message": "成功讀取分類",
+		"category": gin.H{
+			"ID":          category.ID,
+			"name":        category.Name,
+			"slug":        category.Slug,
+			"description": category.Description,
+		},
+		"breadcrumbs": breadcrumbs,
+		"children":    tree.nodes(category.ID),
+		"products":    categoryProductsData(products, reserved),
+		"totalCount":  result.Total,
 	})
 }
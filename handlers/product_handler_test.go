@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"Backend/models"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 建立含一個商品的分類，商品庫存為10且被另一位使用者保留3個
+func seedReservedProduct(t *testing.T, db *gorm.DB) (models.Product, models.Category) {
+	t.Helper()
+	category := models.Category{Name: "電子", Slug: "electronics"}
+	if err := db.Create(&category).Error; err != nil {
+		t.Fatalf("create category: %v", err)
+	}
+	product := createTestProduct(t, db, "Cable", 300, 10)
+	if err := db.Model(&product).Association("Categories").Append(&category); err != nil {
+		t.Fatalf("append category: %v", err)
+	}
+	reservation := models.StockReservation{UserID: 2, ProductID: product.ID, Quantity: 3, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&reservation).Error; err != nil {
+		t.Fatalf("create reservation: %v", err)
+	}
+	return product, category
+}
+
+// 商品列表、標籤商品查詢、分類頁面及商品詳細資料的庫存都扣除所有使用者的保留，且不因使用者而不同
+func TestProductEndpointsReportSameAvailableStock(t *testing.T) {
+	db, rdb := newTestStore(t)
+	product, category := seedReservedProduct(t, db)
+
+	stockOf := func(data interface{}) interface{} {
+		product := data.(map[string]interface{})
+		if stock, ok := product["Stock"]; ok {
+			return stock
+		}
+		return product["stock"]
+	}
+	endpoints := []struct {
+		name    string
+		handler gin.HandlerFunc
+		target  string
+		params  []gin.Param
+		stock   func(response map[string]interface{}) interface{}
+	}{
+		{
+			name:    "商品列表",
+			handler: func(c *gin.Context) { GetProductListHandler(c, db, rdb) },
+			target:  "/products",
+			stock: func(response map[string]interface{}) interface{} {
+				return stockOf(response["products"].([]interface{})[0])
+			},
+		},
+		{
+			name:    "標籤商品查詢",
+			handler: func(c *gin.Context) { GetProductsFromCategoriesHandler(c, db, rdb) },
+			target:  fmt.Sprintf("/products/categories?categories=%d", category.ID),
+			stock: func(response map[string]interface{}) interface{} {
+				return stockOf(response["products"].([]interface{})[0])
+			},
+		},
+		{
+			name:    "分類頁面",
+			handler: func(c *gin.Context) { GetCategoryPageHandler(c, db, rdb) },
+			target:  "/categories/" + category.Slug,
+			params:  []gin.Param{{Key: "slug", Value: category.Slug}},
+			stock: func(response map[string]interface{}) interface{} {
+				return stockOf(response["products"].([]interface{})[0])
+			},
+		},
+		{
+			name:    "商品詳細資料",
+			handler: func(c *gin.Context) { GetProductDataHandler(c, db, rdb) },
+			target:  fmt.Sprintf("/products/%d", product.ID),
+			params:  []gin.Param{{Key: "productID", Value: fmt.Sprint(product.ID)}},
+			stock: func(response map[string]interface{}) interface{} {
+				return stockOf(response["product"])
+			},
+		},
+	}
+
+	for _, endpoint := range endpoints {
+		t.Run(endpoint.name, func(t *testing.T) {
+			recorder := performGet(t, endpoint.handler, endpoint.target, nil, endpoint.params...)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+			}
+			if stock := endpoint.stock(decodeResponse(t, recorder)); stock != float64(7) {
+				t.Errorf("stock = %v, want 7", stock)
+			}
+			if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != "public, no-cache" {
+				t.Errorf("Cache-Control = %q, want public, no-cache", cacheControl)
+			}
+			etag := recorder.Header().Get("ETag")
+			if etag == "" {
+				t.Fatal("response should have an ETag")
+			}
+
+			recorder = performGet(t, endpoint.handler, endpoint.target, http.Header{"If-None-Match": {etag}}, endpoint.params...)
+			if recorder.Code != http.StatusNotModified {
+				t.Errorf("status with matching If-None-Match = %d, want 304", recorder.Code)
+			}
+
+			//保留數量改變時ETag隨之改變
+			db.Model(&models.StockReservation{}).Where("product_id = ?", product.ID).Update("quantity", 4)
+			defer db.Model(&models.StockReservation{}).Where("product_id = ?", product.ID).Update("quantity", 3)
+			recorder = performGet(t, endpoint.handler, endpoint.target, http.Header{"If-None-Match": {etag}}, endpoint.params...)
+			if recorder.Code != http.StatusOK {
+				t.Errorf("status after reservation changed = %d, want 200", recorder.Code)
+			}
+		})
+	}
+}
+
+// 商品詳細資料以Last-Modified及If-Modified-Since重新驗證，保留的建立與釋放都會更新Last-Modified
+func TestGetProductDataLastModified(t *testing.T) {
+	db, rdb := newTestStore(t)
+	product := createTestProduct(t, db, "Cable", 300, 10)
+	createTestCart(t, db, 1, models.CartItem{ProductID: product.ID, Quantity: 2})
+
+	//商品在數秒前更新，避免與請求在同一秒
+	modified := time.Now().Add(-10 * time.Second)
+	db.Model(&product).UpdateColumn("updated_at", modified)
+
+	handler := func(c *gin.Context) { GetProductDataHandler(c, db, rdb) }
+	target := fmt.Sprintf("/products/%d", product.ID)
+	params := []gin.Param{{Key: "productID", Value: fmt.Sprint(product.ID)}}
+
+	recorder := performGet(t, handler, target, nil, params...)
+	lastModified := recorder.Header().Get("Last-Modified")
+	if lastModified != modified.UTC().Format(http.TimeFormat) {
+		t.Fatalf("Last-Modified = %q, want %q", lastModified, modified.UTC().Format(http.TimeFormat))
+	}
+
+	recorder = performGet(t, handler, target, http.Header{"If-Modified-Since": {lastModified}}, params...)
+	if recorder.Code != http.StatusNotModified {
+		t.Fatalf("status with If-Modified-Since = %d, want 304", recorder.Code)
+	}
+
+	//保留庫存後商品的updated_at更新，If-Modified-Since不再符合
+	recorder = performRequest(t, func(c *gin.Context) { ReserveCheckoutHandler(c, db, rdb) }, 1, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("reserve status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = performGet(t, handler, target, http.Header{"If-Modified-Since": {lastModified}}, params...)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status after reservation = %d, want 200", recorder.Code)
+	}
+	if stock := decodeResponse(t, recorder)["product"].(map[string]interface{})["Stock"]; stock != float64(8) {
+		t.Errorf("stock after reservation = %v, want 8", stock)
+	}
+
+	//釋放保留同樣更新updated_at
+	db.Model(&product).UpdateColumn("updated_at", modified)
+	recorder = performRequest(t, func(c *gin.Context) { ReleaseCheckoutHandler(c, db, rdb) }, 1, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("release status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	var released models.Product
+	db.First(&released, product.ID)
+	if !released.UpdatedAt.After(modified) {
+		t.Errorf("updated_at after release = %v, should be after %v", released.UpdatedAt, modified)
+	}
+}
+
+// 保留過期但尚未清除時，Last-Modified以過期時間為準
+func TestGetProductDataLastModifiedUsesExpiry(t *testing.T) {
+	db, rdb := newTestStore(t)
+	product := createTestProduct(t, db, "Cable", 300, 10)
+	modified := time.Now().Add(-time.Hour)
+	db.Model(&product).UpdateColumn("updated_at", modified)
+
+	expiresAt := time.Now().Add(-10 * time.Second)
+	reservation := models.StockReservation{UserID: 2, ProductID: product.ID, Quantity: 3, ExpiresAt: expiresAt}
+	if err := db.Create(&reservation).Error; err != nil {
+		t.Fatalf("create reservation: %v", err)
+	}
+
+	recorder := performGet(t, func(c *gin.Context) { GetProductDataHandler(c, db, rdb) }, "/", nil,
+		gin.Param{Key: "productID", Value: fmt.Sprint(product.ID)})
+	if lastModified := recorder.Header().Get("Last-Modified"); lastModified != expiresAt.UTC().Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %q", lastModified, expiresAt.UTC().Format(http.TimeFormat))
+	}
+	if stock := decodeResponse(t, recorder)["product"].(map[string]interface{})["Stock"]; stock != float64(10) {
+		t.Errorf("stock = %v, expired reservation should not be subtracted", stock)
+	}
+}
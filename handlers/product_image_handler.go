@@ -0,0 +1,656 @@
+package handlers
+
+import (
+	"Backend/cache"
+	"Backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 商品圖片上傳的資料夾及對外網址前綴
+const (
+	uploadsDir       = "./uploads"
+	uploadsURLPrefix = "/uploads/"
+)
+
+// 依排序讀取商品圖片，用於Preload("Images", orderProductImages)
+func orderProductImages(db *gorm.DB) *gorm.DB {
+	return db.Order("sort_order ASC, id ASC")
+}
+
+// 將圖片網址轉為uploads資料夾中的檔案路徑，不是上傳的圖片時回傳false
+func uploadedImagePath(imageURL string) (string, bool) {
+	if !strings.HasPrefix(imageURL, uploadsURLPrefix) {
+		return "", false
+	}
+	name := filepath.Base(imageURL)
+	if name == "." || name == "/" || name == ".." {
+		return "", false
+	}
+	return filepath.Join(uploadsDir, name), true
+}
+
+// 檢查圖片是否仍被商品(包含已刪除的商品)或訂單快照使用
+func isImageReferenced(db *gorm.DB, imageURL string) (bool, error) {
+	var count int64
+	err := db.Model(&models.ProductImage{}).Where("url = ?", imageURL).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	err = db.Unscoped().Model(&models.Product{}).Where("image_url = ?", imageURL).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	err = db.Model(&models.OrderItem{}).Where("image_url = ?", imageURL).Count(&count).Error
+	return count > 0, err
+}
+
+// 圖片不再被使用時刪除檔案，檔案不存在時忽略
+func removeImageFileIfOrphaned(db *gorm.DB, imageURL string) error {
+	filePath, ok := uploadedImagePath(imageURL)
+	if !ok {
+		return nil
+	}
+
+	referenced, err := isImageReferenced(db, imageURL)
+	if err != nil || referenced {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 將圖片設為商品主圖並同步Product.ImageURL，tx應在事務中且商品已上鎖
+func setPrimaryProductImage(tx *gorm.DB, product *models.Product, image *models.ProductImage) error {
+	err := tx.
+		Model(&models.ProductImage{}).
+		Where("product_id = ? AND id <> ?", product.ID, image.ID).
+		Update("is_primary", false).
+		Error
+	if err != nil {
+		return err
+	}
+
+	image.IsPrimary = true
+	err = tx.Model(image).Update("is_primary", true).Error
+	if err != nil {
+		return err
+	}
+
+	product.ImageURL = image.URL
+	return tx.Model(product).Update("image_url", product.ImageURL).Error
+}
+
+// 以網址設定商品主圖，商品尚未有此圖片時新增為第一張圖片
+// 用於新增及修改商品時直接提供imageURL，tx應在事務中
+func setPrimaryProductImageByURL(tx *gorm.DB, product *models.Product, imageURL string) error {
+	var image models.ProductImage
+	err := tx.Where("product_id = ? AND url = ?", product.ID, imageURL).First(&image).Error
+	if err == gorm.ErrRecordNotFound {
+		var minSortOrder int
+		err = tx.
+			Model(&models.ProductImage{}).
+			Where("product_id = ?", product.ID).
+			Select("COALESCE(MIN(sort_order), 0)").
+			Scan(&minSortOrder).
+			Error
+		if err != nil {
+			return err
+		}
+
+		image = models.ProductImage{
+			ProductID: product.ID,
+			URL:       imageURL,
+			SortOrder: minSortOrder - 1,
+		}
+		err = tx.Create(&image).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	return setPrimaryProductImage(tx, product, &image)
+}
+
+// 鎖定商品並查詢圖片，查詢失敗時回應錯誤並回傳false
+func lockProductForImages(c *gin.Context, tx *gorm.DB, productID string) (models.Product, bool) {
+	var product models.Product
+	err := tx.
+		Scopes(lockForUpdate(true)).
+		Preload("Images", orderProductImages).
+		First(&product, productID).
+		Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此商品",
+			})
+			return product, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢商品失敗",
+			"error":   err.Error(),
+		})
+		return product, false
+	}
+	return product, true
+}
+
+// 更新商品快取版本並提交事務，提交後更新商品快取，失敗時回應錯誤並回傳false
+func commitProductImages(c *gin.Context, db *gorm.DB, tx *gorm.DB, rdb *redis.Client, productID uint) bool {
+	err := cache.Touch(tx, productID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "更新商品資料失敗",
+			"error":   err.Error(),
+		})
+		return false
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return false
+	}
+
+	refreshProductCache(c, db, rdb, productID)
+	return true
+}
+
+// 將已上傳的圖片加入商品
+func AttachProductImageHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	productID := c.Param("productID")
+
+	var imageReq struct {
+		ImagePath string `json:"imagePath" binding:"required"`
+		AltText   string `json:"altText"`
+		Primary   bool   `json:"primary"`
+	}
+	err := c.ShouldBindJSON(&imageReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//只能加入透過上傳圖片取得的路徑
+	filePath, ok := uploadedImagePath(imageReq.ImagePath)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "圖片路徑錯誤",
+		})
+		return
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "查無此圖片檔案",
+			"error":   err.Error(),
+		})
+		return
+	}
+	imageURL := "/" + filepath.ToSlash(filePath)
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	product, ok := lockProductForImages(c, tx, productID)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	sortOrder := 0
+	for _, image := range product.Images {
+		if image.URL == imageURL {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "商品已有此圖片",
+				"imageID": image.ID,
+			})
+			return
+		}
+		if image.SortOrder >= sortOrder {
+			sortOrder = image.SortOrder + 1
+		}
+	}
+
+	image := models.ProductImage{
+		ProductID: product.ID,
+		URL:       imageURL,
+		AltText:   imageReq.AltText,
+		SortOrder: sortOrder,
+	}
+	err = tx.Create(&image).Error
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "新增商品圖片失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//商品的第一張圖片自動設為主圖
+	if imageReq.Primary || len(product.Images) == 0 {
+		err = setPrimaryProductImage(tx, &product, &image)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "設定商品主圖失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if !commitProductImages(c, db, tx, rdb, product.ID) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "成功新增商品圖片",
+		"image":   image,
+	})
+}
+
+// 修改商品圖片的替代文字或設為主圖
+func UpdateProductImageHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	productID := c.Param("productID")
+	imageID := c.Param("imageID")
+
+	var imageReq struct {
+		AltText *string `json:"altText"`
+		Primary *bool   `json:"primary"`
+	}
+	err := c.ShouldBindJSON(&imageReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//主圖只能改為其他圖片，不能直接取消
+	if imageReq.Primary != nil && !*imageReq.Primary {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "請將其他圖片設為主圖",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	product, ok := lockProductForImages(c, tx, productID)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	var image models.ProductImage
+	err = tx.Where("product_id = ?", product.ID).First(&image, imageID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此商品圖片",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢商品圖片失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if imageReq.AltText != nil {
+		image.AltText = *imageReq.AltText
+		err = tx.Model(&image).Update("alt_text", image.AltText).Error
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "修改商品圖片失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if imageReq.Primary != nil {
+		err = setPrimaryProductImage(tx, &product, &image)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "設定商品主圖失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if !commitProductImages(c, db, tx, rdb, product.ID) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功修改商品圖片",
+		"image":   image,
+	})
+}
+
+// 重新排序商品圖片，須提供商品所有圖片的ID
+func ReorderProductImagesHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	productID := c.Param("productID")
+
+	var orderReq struct {
+		ImageIDs []uint `json:"imageIDs" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&orderReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	product, ok := lockProductForImages(c, tx, productID)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	imageIDs := make(map[uint]bool, len(product.Images))
+	for _, image := range product.Images {
+		imageIDs[image.ID] = true
+	}
+	seen := make(map[uint]bool, len(orderReq.ImageIDs))
+	for _, imageID := range orderReq.ImageIDs {
+		if !imageIDs[imageID] || seen[imageID] {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "圖片ID錯誤或重複",
+				"imageID": imageID,
+			})
+			return
+		}
+		seen[imageID] = true
+	}
+	if len(seen) != len(imageIDs) {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "須提供商品所有圖片的ID",
+		})
+		return
+	}
+
+	for i, imageID := range orderReq.ImageIDs {
+		err = tx.
+			Model(&models.ProductImage{}).
+			Where("id = ?", imageID).
+			Update("sort_order", i).
+			Error
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "排序商品圖片失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if !commitProductImages(c, db, tx, rdb, product.ID) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "成功排序商品圖片",
+		"imageIDs": orderReq.ImageIDs,
+	})
+}
+
+// 從商品移除圖片，移除主圖時將排序最前的圖片設為主圖，圖片不再被使用時一併刪除檔案
+func DetachProductImageHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	productID := c.Param("productID")
+	imageID, err := strconv.ParseUint(c.Param("imageID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "圖片ID輸入錯誤",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	product, ok := lockProductForImages(c, tx, productID)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	var image models.ProductImage
+	var remainingImages []models.ProductImage
+	for _, productImage := range product.Images {
+		if productImage.ID == uint(imageID) {
+			image = productImage
+		} else {
+			remainingImages = append(remainingImages, productImage)
+		}
+	}
+	if image.ID == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "查無此商品圖片",
+		})
+		return
+	}
+
+	err = tx.Delete(&image).Error
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "移除商品圖片失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if image.IsPrimary {
+		if len(remainingImages) > 0 {
+			err = setPrimaryProductImage(tx, &product, &remainingImages[0])
+		} else {
+			product.ImageURL = ""
+			err = tx.Model(&product).Update("image_url", product.ImageURL).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "設定商品主圖失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if !commitProductImages(c, db, tx, rdb, product.ID) {
+		return
+	}
+
+	//檔案刪除失敗不影響移除結果，可再由清除未使用圖片處理
+	if err := removeImageFileIfOrphaned(db, image.URL); err != nil {
+		log.Printf("刪除圖片檔案失敗: %v\n", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功移除商品圖片",
+		"imageID": image.ID,
+	})
+}
+
+// 清除uploads資料夾中沒有被任何商品或訂單使用的圖片
+// 為避免刪除剛上傳尚未加入商品的圖片，只清除超過minAgeHours(預設24)小時的檔案
+// dryRun=true時只列出會被刪除的檔案
+func CleanupOrphanedImagesHandler(c *gin.Context, db *gorm.DB) {
+	minAgeHours, err := strconv.Atoi(c.DefaultQuery("minAgeHours", "24"))
+	if err != nil || minAgeHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "minAgeHours輸入錯誤",
+		})
+		return
+	}
+	dryRun := c.Query("dryRun") == "true"
+
+	entries, err := os.ReadDir(uploadsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusOK, gin.H{
+				"message":      "沒有需要清除的圖片",
+				"removedFiles": []string{},
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "讀取uploads資料夾失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//一次查出所有被使用的圖片網址，避免逐一查詢
+	referencedURLs := make(map[string]bool)
+	referenceSources := []struct {
+		query  *gorm.DB
+		column string
+	}{
+		{db.Model(&models.ProductImage{}), "url"},
+		{db.Unscoped().Model(&models.Product{}), "image_url"},
+		{db.Model(&models.OrderItem{}), "image_url"},
+	}
+	for _, source := range referenceSources {
+		var urls []string
+		err = source.query.Distinct().Pluck(source.column, &urls).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "查詢使用中的圖片失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+		for _, url := range urls {
+			referencedURLs[url] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-time.Duration(minAgeHours) * time.Hour)
+	removedFiles := []string{}
+	var failedFiles []gin.H
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		imageURL := uploadsURLPrefix + entry.Name()
+		if referencedURLs[imageURL] {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		if !dryRun {
+			err = os.Remove(filepath.Join(uploadsDir, entry.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				failedFiles = append(failedFiles, gin.H{
+					"file":  imageURL,
+					"error": err.Error(),
+				})
+				continue
+			}
+		}
+		removedFiles = append(removedFiles, imageURL)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "成功清除未使用的圖片",
+		"dryRun":       dryRun,
+		"removedFiles": removedFiles,
+		"failedFiles":  failedFiles,
+	})
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"Backend/models"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 批次匯入及匯出的檔案格式
+const (
+	productFileCSV  = "csv"
+	productFileJSON = "json"
+)
+
+// 單次匯入的最大筆數
+const maxImportRows = 5000
+
+// CSV的欄位，標籤名稱以|分隔
+var productCSVHeader = []string{"id", "sku", "name", "price", "stock", "description", "imageURL", "categories"}
+
+const productCSVCategorySeparator = "|"
+
+// 匯入及匯出的商品資料，CSV與JSON使用相同的欄位
+// 有SKU時以SKU新增或更新商品，沒有SKU時以ID更新既有商品
+// Stock、Description、ImageURL、Categories為nil(CSV沒有該欄位或JSON沒有該鍵)時保持不變
+// 有規格的商品庫存為規格庫存總和，Stock會被忽略
+type productImportRow struct {
+	ID          uint      `json:"id,omitempty"`
+	SKU         string    `json:"sku"`
+	Name        string    `json:"name"`
+	Price       uint      `json:"price"`
+	Stock       *uint     `json:"stock"`
+	Description *string   `json:"description"`
+	ImageURL    *string   `json:"imageURL"`
+	Categories  *[]string `json:"categories"`
+}
+
+// 單筆資料的處理結果，Row為CSV的行號(標題為第1行)或JSON陣列的第幾筆(由1開始)
+type productImportResult struct {
+	Row       int    `json:"row"`
+	SKU       string `json:"sku,omitempty"`
+	ProductID uint   `json:"productID,omitempty"`
+	Action    string `json:"action,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// 解析後的一筆資料，Err為解析失敗的原因
+type parsedImportRow struct {
+	Row  int
+	Data productImportRow
+	Err  string
+}
+
+func newProductImportRow(product *models.Product) productImportRow {
+	description := product.Description
+	imageURL := product.ImageURL
+	categories := []string{}
+	row := productImportRow{
+		ID:          product.ID,
+		Name:        product.Name,
+		Price:       product.Price,
+		Description: &description,
+		ImageURL:    &imageURL,
+		Categories:  &categories,
+	}
+	if product.SKU != nil {
+		row.SKU = *product.SKU
+	}
+	stock := product.Stock
+	row.Stock = &stock
+	for _, category := range product.Categories {
+		categories = append(categories, category.Name)
+	}
+	return row
+}
+
+// 轉為CSV的一行
+func (row productImportRow) csvRecord() []string {
+	stock := ""
+	if row.Stock != nil {
+		stock = strconv.FormatUint(uint64(*row.Stock), 10)
+	}
+	description := ""
+	if row.Description != nil {
+		description = *row.Description
+	}
+	imageURL := ""
+	if row.ImageURL != nil {
+		imageURL = *row.ImageURL
+	}
+	var categories []string
+	if row.Categories != nil {
+		categories = *row.Categories
+	}
+	return []string{
+		strconv.FormatUint(uint64(row.ID), 10),
+		row.SKU,
+		row.Name,
+		strconv.FormatUint(uint64(row.Price), 10),
+		stock,
+		description,
+		imageURL,
+		strings.Join(categories, productCSVCategorySeparator),
+	}
+}
+
+// 解析CSV，第一行須為標題，欄位順序不限但不可有未知欄位
+// 沒有的欄位在商品更新時保持不變
+// 單行的格式錯誤只記錄於該行，標題錯誤時回傳錯誤訊息
+func parseProductCSV(r io.Reader) ([]parsedImportRow, string) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, "無法讀取CSV標題"
+	}
+	known := make(map[string]bool, len(productCSVHeader))
+	for _, name := range productCSVHeader {
+		known[name] = true
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !known[name] {
+			return nil, fmt.Sprintf("未知的CSV欄位: %s", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, "CSV缺少name欄位"
+	}
+
+	var rows []parsedImportRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows) >= maxImportRows {
+			return nil, fmt.Sprintf("單次最多匯入%d筆", maxImportRows)
+		}
+		row := parsedImportRow{Row: line}
+		if err != nil {
+			row.Err = "CSV格式錯誤: " + err.Error()
+			rows = append(rows, row)
+			//引號未結束等錯誤無法繼續讀取
+			if _, ok := err.(*csv.ParseError); !ok {
+				break
+			}
+			continue
+		}
+
+		value := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row.Data.SKU = value("sku")
+		row.Data.Name = value("name")
+		if _, ok := columns["description"]; ok {
+			description := value("description")
+			row.Data.Description = &description
+		}
+		if _, ok := columns["imageURL"]; ok {
+			imageURL := value("imageURL")
+			row.Data.ImageURL = &imageURL
+		}
+		if id := value("id"); id != "" {
+			parsed, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				row.Err = "id格式錯誤"
+			}
+			row.Data.ID = uint(parsed)
+		}
+		if price := value("price"); price != "" {
+			parsed, err := strconv.ParseUint(price, 10, 32)
+			if err != nil {
+				row.Err = "price格式錯誤"
+			}
+			row.Data.Price = uint(parsed)
+		}
+		if stock := value("stock"); stock != "" {
+			parsed, err := strconv.ParseUint(stock, 10, 32)
+			if err != nil {
+				row.Err = "stock格式錯誤"
+			}
+			stockValue := uint(parsed)
+			row.Data.Stock = &stockValue
+		}
+		if _, ok := columns["categories"]; ok {
+			categories := []string{}
+			for _, name := range strings.Split(value("categories"), productCSVCategorySeparator) {
+				if name = strings.TrimSpace(name); name != "" {
+					categories = append(categories, name)
+				}
+			}
+			row.Data.Categories = &categories
+		}
+		rows = append(rows, row)
+	}
+	return rows, ""
+}
+
+// 解析JSON陣列，每個元素的格式錯誤只記錄於該筆
+func parseProductJSON(r io.Reader) ([]parsedImportRow, string) {
+	var records []json.RawMessage
+	err := json.NewDecoder(r).Decode(&records)
+	if err != nil {
+		return nil, "JSON須為商品資料的陣列"
+	}
+	if len(records) > maxImportRows {
+		return nil, fmt.Sprintf("單次最多匯入%d筆", maxImportRows)
+	}
+
+	rows := make([]parsedImportRow, len(records))
+	for i, record := range records {
+		rows[i].Row = i + 1
+		err := json.Unmarshal(record, &rows[i].Data)
+		if err != nil {
+			rows[i].Err = "JSON格式錯誤: " + err.Error()
+			continue
+		}
+		rows[i].Data.SKU = strings.TrimSpace(rows[i].Data.SKU)
+		rows[i].Data.Name = strings.TrimSpace(rows[i].Data.Name)
+	}
+	return rows, ""
+}
+
+// 檢查商品SKU，不可超過64字且未被其他商品(含已刪除)使用
+func checkProductSKU(db *gorm.DB, sku string, excludeID uint) (err error, msg string) {
+	if sku == "" {
+		return nil, "SKU不得為空"
+	}
+	if len(sku) > 64 {
+		return nil, "SKU不得超過64字"
+	}
+
+	var count int64
+	err = db.
+		Unscoped().
+		Model(&models.Product{}).
+		Where("sku = ? AND id <> ?", sku, excludeID).
+		Count(&count).
+		Error
+	if err != nil {
+		return err, "檢查SKU失敗"
+	}
+	if count > 0 {
+		return nil, "SKU已被其他商品使用"
+	}
+	return nil, ""
+}
+
+// 在事務中新增或更新一筆商品，回傳處理方式(created、updated、restored)
+// 資料錯誤時回傳錯誤訊息，資料庫錯誤時回傳err
+func importProductRow(tx *gorm.DB, data productImportRow) (product models.Product, action string, err error, msg string) {
+	if data.Name == "" {
+		return product, "", nil, "name不得為空"
+	}
+	if data.Price == 0 {
+		return product, "", nil, "price須大於0"
+	}
+	if data.SKU == "" && data.ID == 0 {
+		return product, "", nil, "須提供sku或id"
+	}
+
+	//以SKU查詢商品，已刪除的商品會被還原
+	found := false
+	if data.SKU != "" {
+		err = tx.Unscoped().Where("sku = ?", data.SKU).First(&product).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return product, "", err, "查詢商品失敗"
+		}
+		found = err == nil
+		if found && data.ID != 0 && product.ID != data.ID {
+			return product, "", nil, "sku與id不符"
+		}
+	}
+	if !found && data.ID != 0 {
+		err = tx.First(&product, data.ID).Error
+		if err == gorm.ErrRecordNotFound {
+			return product, "", nil, "查無此商品"
+		}
+		if err != nil {
+			return product, "", err, "查詢商品失敗"
+		}
+		found = true
+		//為既有商品設定或更換SKU
+		if data.SKU != "" {
+			err, msg = checkProductSKU(tx, data.SKU, product.ID)
+			if err != nil || msg != "" {
+				return product, "", err, msg
+			}
+		}
+	}
+
+	var categories []models.Category
+	if data.Categories != nil {
+		categories, err = findOrCreateCategories(tx, *data.Categories)
+		if err != nil {
+			return product, "", err, "查詢標籤失敗"
+		}
+	}
+
+	if !found {
+		if data.Stock == nil {
+			return product, "", nil, "新商品須提供stock"
+		}
+		if data.ImageURL == nil || *data.ImageURL == "" {
+			return product, "", nil, "新商品須提供imageURL"
+		}
+		if len(data.SKU) > 64 {
+			return product, "", nil, "SKU不得超過64字"
+		}
+		sku := data.SKU
+		description := ""
+		if data.Description != nil {
+			description = *data.Description
+		}
+		product = models.Product{
+			SKU:         &sku,
+			Name:        data.Name,
+			Price:       data.Price,
+			Stock:       *data.Stock,
+			Description: description,
+			ImageURL:    *data.ImageURL,
+			Categories:  categories,
+			Images: []models.ProductImage{{
+				URL:       *data.ImageURL,
+				IsPrimary: true,
+			}},
+		}
+		err = tx.Create(&product).Error
+		if err != nil {
+			return product, "", err, "新增商品失敗"
+		}
+		return product, "created", nil, ""
+	}
+
+	action = "updated"
+	if product.DeletedAt.Valid {
+		action = "restored"
+		product.DeletedAt = gorm.DeletedAt{}
+	}
+	if data.SKU != "" {
+		sku := data.SKU
+		product.SKU = &sku
+	}
+	product.Name = data.Name
+	product.Price = data.Price
+	if data.Description != nil {
+		product.Description = *data.Description
+	}
+
+	//有規格的商品庫存為規格庫存總和
+	var variantCount int64
+	err = tx.Model(&models.ProductVariant{}).Where("product_id = ?", product.ID).Count(&variantCount).Error
+	if err != nil {
+		return product, "", err, "查詢商品規格失敗"
+	}
+	if variantCount == 0 && data.Stock != nil {
+		product.Stock = *data.Stock
+	}
+
+	err = tx.Unscoped().Save(&product).Error
+	if err != nil {
+		return product, "", err, "修改商品失敗"
+	}
+
+	if data.Categories != nil {
+		err = tx.Model(&product).Association("Categories").Replace(categories)
+		if err != nil {
+			return product, "", err, "修改商品標籤失敗"
+		}
+		product.Categories = categories
+	}
+
+	if data.ImageURL != nil && *data.ImageURL != "" && *data.ImageURL != product.ImageURL {
+		err = setPrimaryProductImageByURL(tx, &product, *data.ImageURL)
+		if err != nil {
+			return product, "", err, "設定商品主圖失敗"
+		}
+	}
+	return product, action, nil, ""
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"Backend/models"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// 新增及修改商品時的規格資料，修改時有ID代表修改既有規格，沒有則新增
+type productVariantReq struct {
+	ID      *uint             `json:"id"`
+	SKU     string            `json:"sku" binding:"required"`
+	Options map[string]string `json:"options"`
+	Price   *uint             `json:"price"`
+	Stock   uint              `json:"stock"`
+}
+
+// 檢查規格資料，SKU不得重複(包含已刪除的規格)，productID為0代表新增商品
+func validateProductVariants(db *gorm.DB, productID uint, variantsReq []productVariantReq) (err error, msg string) {
+	skus := make([]string, 0, len(variantsReq))
+	seenSKUs := make(map[string]bool, len(variantsReq))
+	seenIDs := make(map[uint]bool, len(variantsReq))
+	for _, variantReq := range variantsReq {
+		sku := strings.TrimSpace(variantReq.SKU)
+		if sku == "" {
+			return nil, "規格SKU不得為空"
+		}
+		if seenSKUs[sku] {
+			return nil, fmt.Sprintf("規格SKU %s 重複", sku)
+		}
+		seenSKUs[sku] = true
+		skus = append(skus, sku)
+
+		if variantReq.ID != nil {
+			if productID == 0 {
+				return nil, "新增商品時規格不得指定ID"
+			}
+			if seenIDs[*variantReq.ID] {
+				return nil, fmt.Sprintf("規格%d重複", *variantReq.ID)
+			}
+			seenIDs[*variantReq.ID] = true
+		}
+	}
+
+	if len(seenIDs) > 0 {
+		ids := make([]uint, 0, len(seenIDs))
+		for id := range seenIDs {
+			ids = append(ids, id)
+		}
+		var count int64
+		err = db.
+			Model(&models.ProductVariant{}).
+			Where("id IN ? AND product_id = ?", ids, productID).
+			Count(&count).
+			Error
+		if err != nil {
+			return err, "查詢商品規格失敗"
+		}
+		if int(count) != len(ids) {
+			return nil, "查無此商品規格"
+		}
+	}
+
+	if len(skus) == 0 {
+		return nil, ""
+	}
+
+	//SKU不得被其他商品或已刪除的規格使用
+	var usedVariants []models.ProductVariant
+	err = db.
+		Unscoped().
+		Where("sku IN ?", skus).
+		Find(&usedVariants).
+		Error
+	if err != nil {
+		return err, "檢查規格SKU失敗"
+	}
+	for _, usedVariant := range usedVariants {
+		if usedVariant.DeletedAt.Valid || usedVariant.ProductID != productID {
+			return nil, fmt.Sprintf("規格SKU %s 已被使用", usedVariant.SKU)
+		}
+		for _, variantReq := range variantsReq {
+			if strings.TrimSpace(variantReq.SKU) == usedVariant.SKU && (variantReq.ID == nil || *variantReq.ID != usedVariant.ID) {
+				return nil, fmt.Sprintf("規格SKU %s 已被使用", usedVariant.SKU)
+			}
+		}
+	}
+
+	return nil, ""
+}
+
+// 將規格資料轉為新增商品用的規格
+func newProductVariants(variantsReq []productVariantReq) []models.ProductVariant {
+	variants := make([]models.ProductVariant, len(variantsReq))
+	for i, variantReq := range variantsReq {
+		variants[i] = models.ProductVariant{
+			SKU:     strings.TrimSpace(variantReq.SKU),
+			Options: variantReq.Options,
+			Price:   variantReq.Price,
+			Stock:   variantReq.Stock,
+		}
+	}
+	return variants
+}
+
+// 規格庫存總和
+func sumVariantStock(variants []models.ProductVariant) uint {
+	var stock uint
+	for _, variant := range variants {
+		stock += variant.Stock
+	}
+	return stock
+}
+
+// 以請求資料取代商品的所有規格，未列出的規格會被刪除並移出購物車，tx應在事務中
+// 完成後product.Variants為最新的規格，有規格時商品庫存更新為規格庫存總和
+func syncProductVariants(tx *gorm.DB, product *models.Product, variantsReq []productVariantReq) error {
+	var existingVariants []models.ProductVariant
+	err := tx.Where("product_id = ?", product.ID).Find(&existingVariants).Error
+	if err != nil {
+		return err
+	}
+
+	keepIDs := make(map[uint]bool, len(variantsReq))
+	for _, variantReq := range variantsReq {
+		variant := models.ProductVariant{
+			ProductID: product.ID,
+			SKU:       strings.TrimSpace(variantReq.SKU),
+			Options:   variantReq.Options,
+			Price:     variantReq.Price,
+			Stock:     variantReq.Stock,
+		}
+		if variantReq.ID == nil {
+			err = tx.Create(&variant).Error
+		} else {
+			keepIDs[*variantReq.ID] = true
+			err = tx.
+				Model(&models.ProductVariant{}).
+				Where("id = ?", *variantReq.ID).
+				Select("sku", "options", "price", "stock").
+				Updates(&variant).
+				Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var removedIDs []uint
+	for _, existingVariant := range existingVariants {
+		if !keepIDs[existingVariant.ID] {
+			removedIDs = append(removedIDs, existingVariant.ID)
+		}
+	}
+	if len(removedIDs) > 0 {
+		err = tx.Where("variant_id IN ?", removedIDs).Delete(&models.CartItem{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Delete(&models.ProductVariant{}, removedIDs).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Where("product_id = ?", product.ID).Order("id ASC").Find(&product.Variants).Error
+	if err != nil {
+		return err
+	}
+	if len(product.Variants) == 0 {
+		return nil
+	}
+
+	//商品改為有規格後，購物車內未選擇規格的商品無法結帳
+	err = tx.Where("product_id = ? AND variant_id IS NULL", product.ID).Delete(&models.CartItem{}).Error
+	if err != nil {
+		return err
+	}
+
+	product.Stock = sumVariantStock(product.Variants)
+	return tx.Model(product).Update("stock", product.Stock).Error
+}
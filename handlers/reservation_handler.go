@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"Backend/models"
+	"Backend/reservation"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+)
+
+// 查詢商品被所有使用者保留的數量，用於公開顯示的可購買庫存，查詢失敗時不扣除保留數量
+// 公開的商品資料不因登入的使用者而不同，才能由瀏覽器及CDN共用快取，使用者自己的保留只在加入購物車及結帳時排除
+func getReservedQuantities(db *gorm.DB, productIDs []uint) map[uint]uint {
+	reserved, err := reservation.ReservedQuantities(db, productIDs, 0)
+	if err != nil {
+		log.Printf("查詢保留庫存失敗: %v\n", err)
+		return map[uint]uint{}
+	}
+	return reserved
+}
+
+// 取得目前登入的使用者ID，未登入回傳0
+func currentUserID(c *gin.Context) uint {
+	userID, ok := c.Get("UserID")
+	if !ok {
+		return 0
+	}
+	return userID.(uint)
+}
+
+// 開始結帳，保留購物車內所有商品的庫存
+func ReserveCheckoutHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	userID, ok := c.Get("UserID")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法取得使用者ID",
+		})
+		return
+	}
+
+	var cart models.Cart
+	err := db.
+		Where("user_id = ?", userID).
+		Preload("CartItems").
+		First(&cart).
+		Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢購物車失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if len(cart.CartItems) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "購物車沒有商品",
+		})
+		return
+	}
+
+	cartQuantities := make(map[stockKey]uint, len(cart.CartItems))
+	keys := make([]stockKey, 0, len(cart.CartItems))
+	for _, cartItem := range cart.CartItems {
+		key := stockKey{ProductID: cartItem.ProductID, VariantID: variantIDValue(cartItem.VariantID)}
+		cartQuantities[key] = cartItem.Quantity
+		keys = append(keys, key)
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	units, failedKey, err := lockStockUnits(tx, keys, userID.(uint))
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound || err == ErrVariantRequired || err == ErrVariantNotFound {
+			c.JSON(http.StatusConflict, gin.H{
+				"message":   "購物車內有已下架或規格錯誤的商品",
+				"productID": failedKey.ProductID,
+				"variantID": failedKey.VariantID,
+				"error":     err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢庫存失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var items []reservation.Item
+	var unavailableItems []gin.H
+	for _, unit := range units {
+		quantity := cartQuantities[unit.Key]
+		if quantity > unit.Available {
+			unavailableItems = append(unavailableItems, gin.H{
+				"productID": unit.Key.ProductID,
+				"variantID": unit.Key.VariantID,
+				"name":      unit.Name(),
+				"quantity":  quantity,
+				"available": unit.Available,
+			})
+			continue
+		}
+		items = append(items, reservation.Item{
+			ProductID: unit.Key.ProductID,
+			VariantID: unit.Key.VariantID,
+			Quantity:  quantity,
+		})
+	}
+
+	if len(unavailableItems) > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{
+			"message":          "部分商品庫存不足",
+			"unavailableItems": unavailableItems,
+		})
+		return
+	}
+
+	expiresAt, productIDs, err := reservation.Replace(tx, userID.(uint), items)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "保留庫存失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//保留數量改變商品的可購買庫存
+	refreshProductCache(c, db, rdb, productIDs...)
+
+	var reservedItems []gin.H
+	for _, item := range items {
+		reservedItems = append(reservedItems, gin.H{
+			"productID": item.ProductID,
+			"variantID": item.VariantID,
+			"quantity":  item.Quantity,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "成功保留庫存",
+		"expiresAt": expiresAt,
+		"items":     reservedItems,
+	})
+}
+
+// 取消結帳，釋放使用者保留的所有庫存
+func ReleaseCheckoutHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	userID, ok := c.Get("UserID")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法取得使用者ID",
+		})
+		return
+	}
+
+	productIDs, err := reservation.Release(db, userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "釋放保留庫存失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	refreshProductCache(c, db, rdb, productIDs...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功釋放保留庫存",
+	})
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"Backend/models"
+	"Backend/reservation"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 查詢使用者的保留
+func userReservations(t *testing.T, db *gorm.DB, userID uint) []models.StockReservation {
+	t.Helper()
+	var reservations []models.StockReservation
+	if err := db.Where("user_id = ?", userID).Order("product_id ASC").Find(&reservations).Error; err != nil {
+		t.Fatalf("find reservations: %v", err)
+	}
+	return reservations
+}
+
+// 保留數量不可超過其他使用者保留後的庫存
+func TestReserveCheckoutExcludesOtherReservations(t *testing.T) {
+	db, rdb := newTestStore(t)
+	product := createTestProduct(t, db, "Cable", 300, 10)
+	createTestCart(t, db, 1, models.CartItem{ProductID: product.ID, Quantity: 8})
+	cart := createTestCart(t, db, 2, models.CartItem{ProductID: product.ID, Quantity: 3})
+	reserve := func(c *gin.Context) { ReserveCheckoutHandler(c, db, rdb) }
+
+	if recorder := performRequest(t, reserve, 1, nil); recorder.Code != http.StatusOK {
+		t.Fatalf("reserve status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder := performRequest(t, reserve, 2, nil)
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("reserve over available status = %d, want 409, body = %s", recorder.Code, recorder.Body.String())
+	}
+	unavailable := decodeResponse(t, recorder)["unavailableItems"].([]interface{})[0].(map[string]interface{})
+	if unavailable["available"] != float64(2) {
+		t.Errorf("available = %v, want 2", unavailable["available"])
+	}
+	if reservations := userReservations(t, db, 2); len(reservations) != 0 {
+		t.Errorf("failed reservation should not be stored, found %+v", reservations)
+	}
+
+	db.Model(&models.CartItem{}).Where("cart_id = ?", cart.ID).Update("quantity", 2)
+	if recorder := performRequest(t, reserve, 2, nil); recorder.Code != http.StatusOK {
+		t.Errorf("reserve within available status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+// 再次保留時取代原本的保留並延長有效時間，自己的保留不會扣除可保留數量
+func TestReserveCheckoutExtendsReservation(t *testing.T) {
+	db, rdb := newTestStore(t)
+	product := createTestProduct(t, db, "Cable", 300, 10)
+	cart := createTestCart(t, db, 1, models.CartItem{ProductID: product.ID, Quantity: 6})
+	reserve := func(c *gin.Context) { ReserveCheckoutHandler(c, db, rdb) }
+
+	if recorder := performRequest(t, reserve, 1, nil); recorder.Code != http.StatusOK {
+		t.Fatalf("reserve status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	almostExpired := time.Now().Add(time.Minute)
+	db.Model(&models.StockReservation{}).Where("user_id = ?", 1).Update("expires_at", almostExpired)
+
+	db.Model(&models.CartItem{}).Where("cart_id = ?", cart.ID).Update("quantity", 9)
+	if recorder := performRequest(t, reserve, 1, nil); recorder.Code != http.StatusOK {
+		t.Fatalf("extend status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	reservations := userReservations(t, db, 1)
+	if len(reservations) != 1 || reservations[0].Quantity != 9 {
+		t.Fatalf("reservations = %+v, want one reservation of 9", reservations)
+	}
+	if !reservations[0].ExpiresAt.After(almostExpired.Add(reservation.TTL() / 2)) {
+		t.Errorf("expires at = %v, should be extended by the reservation TTL", reservations[0].ExpiresAt)
+	}
+}
+
+// 過期的保留不再扣除庫存，清除後刪除並更新商品的updated_at
+func TestSweepExpiredReservations(t *testing.T) {
+	db, rdb := newTestStore(t)
+	expired := createTestProduct(t, db, "Cable", 300, 10)
+	active := createTestProduct(t, db, "Adapter", 500, 10)
+	createTestCart(t, db, 1,
+		models.CartItem{ProductID: expired.ID, Quantity: 8},
+		models.CartItem{ProductID: active.ID, Quantity: 1},
+	)
+	if recorder := performRequest(t, func(c *gin.Context) { ReserveCheckoutHandler(c, db, rdb) }, 1, nil); recorder.Code != http.StatusOK {
+		t.Fatalf("reserve status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	modified := time.Now().Add(-time.Hour)
+	db.Model(&models.Product{}).Where("id IN ?", []uint{expired.ID, active.ID}).UpdateColumn("updated_at", modified)
+	db.Model(&models.StockReservation{}).Where("product_id = ?", expired.ID).Update("expires_at", time.Now().Add(-time.Second))
+
+	//尚未清除時過期的保留已不扣除庫存
+	if reserved := getReservedQuantities(db, []uint{expired.ID, active.ID}); reserved[expired.ID] != 0 || reserved[active.ID] != 1 {
+		t.Errorf("reserved before sweep = %v, want only 1 of product %d", reserved, active.ID)
+	}
+
+	productIDs, err := reservation.Sweep(db)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if len(productIDs) != 1 || productIDs[0] != expired.ID {
+		t.Errorf("swept products = %v, want [%d]", productIDs, expired.ID)
+	}
+
+	reservations := userReservations(t, db, 1)
+	if len(reservations) != 1 || reservations[0].ProductID != active.ID {
+		t.Errorf("reservations after sweep = %+v, want only product %d", reservations, active.ID)
+	}
+	var swept, untouched models.Product
+	db.First(&swept, expired.ID)
+	db.First(&untouched, active.ID)
+	if !swept.UpdatedAt.After(modified) {
+		t.Errorf("updated_at of swept product = %v, should be after %v", swept.UpdatedAt, modified)
+	}
+	if untouched.UpdatedAt.After(modified.Add(time.Second)) {
+		t.Errorf("updated_at of product with active reservation = %v, should not change", untouched.UpdatedAt)
+	}
+
+	//沒有過期的保留時不變更任何商品
+	if productIDs, err := reservation.Sweep(db); err != nil || len(productIDs) != 0 {
+		t.Errorf("second Sweep = %v, %v, want nothing swept", productIDs, err)
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"Backend/cache"
+	"Backend/models"
+	"Backend/search"
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 搜尋結果中描述片段的最大字數
+const searchSnippetLength = 80
+
+// 等待其他請求或其他實例重建搜尋索引的最長時間，逾時改由資料庫查詢
+const searchRebuildWait = 3 * time.Second
+
+// 從資料庫重新建立所有商品的搜尋索引，分批讀取避免一次載入所有商品
+// 完成後刪除已不存在的商品的索引
+func RebuildSearchIndex(ctx context.Context, db *gorm.DB, rdb *redis.Client) (count int, err error, msg string) {
+	staleIDs, err := search.IndexedProductIDs(ctx, rdb)
+	if err != nil {
+		return 0, err, "無法讀取商品搜尋索引"
+	}
+
+	indexedIDs := make(map[uint]bool)
+	var products []models.Product
+	var indexErr error
+	result := db.
+		Select("id", "name", "description").
+		FindInBatches(&products, 500, func(tx *gorm.DB, batch int) error {
+			for i := range products {
+				indexErr = search.IndexProduct(ctx, rdb, cache.SearchDocument(&products[i]))
+				if indexErr != nil {
+					return indexErr
+				}
+				indexedIDs[products[i].ID] = true
+				count++
+			}
+			return nil
+		})
+	if indexErr != nil {
+		return count, indexErr, "無法建立商品搜尋索引"
+	}
+	if result.Error != nil {
+		return count, result.Error, "無法讀取商品列表"
+	}
+
+	for _, productID := range staleIDs {
+		if indexedIDs[productID] {
+			continue
+		}
+		err = search.RemoveProduct(ctx, rdb, productID)
+		if err != nil {
+			return count, err, "無法刪除商品搜尋索引"
+		}
+	}
+	return count, nil, ""
+}
+
+// 以搜尋索引搜尋商品，索引遺失時從資料庫重建，同時只有一個請求執行重建
+// Redis無法使用或搜尋失敗時改以資料庫比對關鍵字，結果依商品ID排序
+func searchProducts(c *gin.Context, db *gorm.DB, rdb *redis.Client, query string, offset, limit int) (search.Result, error) {
+	if cache.RedisAvailable() {
+		result, err := searchIndexedProducts(c, db, rdb, query, offset, limit)
+		if err == nil {
+			return result, nil
+		}
+		log.Println("Redis error: ", err)
+	}
+	return searchProductsInDB(db, query, offset, limit)
+}
+
+func searchIndexedProducts(c *gin.Context, db *gorm.DB, rdb *redis.Client, query string, offset, limit int) (search.Result, error) {
+	indexedCount, err := search.IndexedCount(c, rdb)
+	if err != nil {
+		return search.Result{}, err
+	}
+	if indexedCount == 0 {
+		err = cache.Rebuild(rdb, "search", searchRebuildWait, func(ctx context.Context) error {
+			_, err, _ := RebuildSearchIndex(ctx, db, rdb)
+			return err
+		})
+		if err != nil {
+			return search.Result{}, err
+		}
+	}
+	return search.Search(c, rdb, query, offset, limit)
+}
+
+// 商品名稱或描述須包含每個以空白分隔的關鍵字
+func searchProductsInDB(db *gorm.DB, query string, offset, limit int) (search.Result, error) {
+	var result search.Result
+
+	likeEscaper := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+	filter := func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Model(&models.Product{})
+		for _, keyword := range strings.Fields(query) {
+			pattern := "%" + likeEscaper.Replace(keyword) + "%"
+			tx = tx.Where("name LIKE ? OR description LIKE ?", pattern, pattern)
+		}
+		return tx
+	}
+
+	err := db.Scopes(filter).Count(&result.Total).Error
+	if err != nil {
+		return result, err
+	}
+
+	var productIDs []uint
+	err = db.Scopes(filter).Order("id ASC").Offset(offset).Limit(limit).Pluck("id", &productIDs).Error
+	if err != nil {
+		return result, err
+	}
+	for _, productID := range productIDs {
+		result.Hits = append(result.Hits, search.Hit{ProductID: productID})
+	}
+	return result, nil
+}
+
+// 以關鍵字搜尋商品名稱及描述，依相關度排序並標示符合的文字
+func SearchProductsHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "請輸入搜尋關鍵字",
+		})
+		return
+	}
+
+	limitInt, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limitInt < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "查詢數量輸入錯誤",
+		})
+		return
+	}
+	//限制最高查詢數量為50
+	if limitInt > 50 {
+		limitInt = 50
+	}
+
+	offsetInt, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offsetInt < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "offset輸入錯誤",
+		})
+		return
+	}
+
+	//Redis無法使用時改由資料庫查詢
+	result, err := searchProducts(c, db, rdb, query, offsetInt, limitInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "搜尋商品失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	productIDs := make([]uint, len(result.Hits))
+	for i, hit := range result.Hits {
+		productIDs[i] = hit.ProductID
+	}
+
+	var products []models.Product
+	if len(productIDs) > 0 {
+		err = db.Where("id IN ?", productIDs).Find(&products).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "查詢商品資料失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	productsByID := make(map[uint]models.Product, len(products))
+	for _, product := range products {
+		productsByID[product.ID] = product
+	}
+
+	//庫存扣除結帳中保留的數量
+	reserved := getReservedQuantities(db, productIDs)
+
+	productsData := []gin.H{}
+	for _, hit := range result.Hits {
+		product, ok := productsByID[hit.ProductID]
+		if !ok {
+			continue
+		}
+		productsData = append(productsData, gin.H{
+			"ID":       product.ID,
+			"Name":     product.Name,
+			"Price":    product.Price,
+			"Stock":    availableStock(product.Stock, reserved[product.ID]),
+			"ImageURL": product.ImageURL,
+			"Score":    hit.Score,
+			"Highlight": gin.H{
+				"Name":        search.Highlight(product.Name, query),
+				"Description": search.Snippet(product.Description, query, searchSnippetLength),
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "成功搜尋商品",
+		"products":   productsData,
+		"totalCount": result.Total,
+	})
+}
+
+// 重新建立所有商品的搜尋索引
+// 同時有其他重建時等待其完成，不重複重建
+func ReindexSearchHandler(c *gin.Context, db *gorm.DB, rdb *redis.Client) {
+	rebuilt := false
+	count := 0
+	msg := "無法重建商品搜尋索引"
+	err := cache.Rebuild(rdb, "search", searchRebuildWait, func(ctx context.Context) error {
+		var err error
+		rebuilt = true
+		count, err, msg = RebuildSearchIndex(ctx, db, rdb)
+		return err
+	})
+	if err == cache.ErrRebuildInProgress {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "商品搜尋索引重建中，請稍後再試",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": msg,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if !rebuilt {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "其他程序已完成重建商品搜尋索引",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功重建商品搜尋索引",
+		"count":   count,
+	})
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"Backend/jwt"
+	"Backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+// 回應中的登入資料，currentSessionID為目前請求所屬的登入
+func sessionsData(sessions []models.LoginToken, currentSessionID uint) []gin.H {
+	data := []gin.H{}
+	for _, session := range sessions {
+		data = append(data, gin.H{
+			"ID":         session.ID,
+			"device":     session.Device,
+			"IP":         session.IP,
+			"userAgent":  session.UserAgent,
+			"createdAt":  session.CreatedAt,
+			"lastSeenAt": session.LastSeenAt,
+			"expiresAt":  session.RefreshExpirationTime,
+			"current":    session.ID == currentSessionID,
+		})
+	}
+	return data
+}
+
+// 取得目前請求的使用者ID及登入ID
+func currentSession(c *gin.Context) (userID uint, sessionID uint, ok bool) {
+	userIDValue, ok := c.Get("UserID")
+	if !ok {
+		return 0, 0, false
+	}
+	sessionIDValue, ok := c.Get("SessionID")
+	if !ok {
+		return 0, 0, false
+	}
+	return userIDValue.(uint), sessionIDValue.(uint), true
+}
+
+// 查詢自己所有有效的登入
+func GetSessionListHandler(c *gin.Context, db *gorm.DB) {
+	userID, sessionID, ok := currentSession(c)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法取得使用者ID",
+		})
+		return
+	}
+
+	sessions, err := jwt.ListSessions(db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢登入列表失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "成功查詢登入列表",
+		"sessions": sessionsData(sessions, sessionID),
+	})
+}
+
+// 撤銷自己的一個登入，撤銷目前的登入等同登出
+func RevokeSessionHandler(c *gin.Context, db *gorm.DB) {
+	userID, currentSessionID, ok := currentSession(c)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法取得使用者ID",
+		})
+		return
+	}
+	sessionID, err := strconv.ParseUint(c.Param("sessionID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "登入ID輸入錯誤",
+		})
+		return
+	}
+
+	found, err := jwt.RevokeSession(c, db, userID, uint(sessionID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "撤銷登入失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "找不到此登入或已撤銷",
+		})
+		return
+	}
+
+	if uint(sessionID) == currentSessionID {
+		c.Header("Authorization", "")
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功撤銷登入",
+	})
+}
+
+// 撤銷自己的所有登入，exceptCurrent=true時保留目前的登入
+func RevokeAllSessionsHandler(c *gin.Context, db *gorm.DB) {
+	userID, currentSessionID, ok := currentSession(c)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法取得使用者ID",
+		})
+		return
+	}
+	exceptCurrent := c.Query("exceptCurrent") == "true"
+
+	var exceptSessionID uint
+	if exceptCurrent {
+		exceptSessionID = currentSessionID
+	}
+	count, err := jwt.RevokeUserSessions(c, db, userID, exceptSessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "撤銷登入失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if !exceptCurrent {
+		c.Header("Authorization", "")
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "成功撤銷登入",
+		"revokedCount": count,
+	})
+}
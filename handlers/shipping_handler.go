@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"Backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+// 新增及修改運送方式的請求資料，修改時未提供的欄位保持不變
+type shippingMethodReq struct {
+	Code                  *string `json:"code"`
+	Name                  *string `json:"name"`
+	Description           *string `json:"description"`
+	Fee                   *uint   `json:"fee"`
+	FreeShippingThreshold *uint   `json:"freeShippingThreshold"`
+	Enabled               *bool   `json:"enabled"`
+	SortOrder             *int    `json:"sortOrder"`
+}
+
+// 將請求資料套用至運送方式
+func (req shippingMethodReq) applyTo(shippingMethod *models.ShippingMethod) {
+	if req.Code != nil {
+		shippingMethod.Code = strings.TrimSpace(*req.Code)
+	}
+	if req.Name != nil {
+		shippingMethod.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		shippingMethod.Description = *req.Description
+	}
+	if req.Fee != nil {
+		shippingMethod.Fee = *req.Fee
+	}
+	if req.FreeShippingThreshold != nil {
+		shippingMethod.FreeShippingThreshold = *req.FreeShippingThreshold
+	}
+	if req.Enabled != nil {
+		shippingMethod.Enabled = *req.Enabled
+	}
+	if req.SortOrder != nil {
+		shippingMethod.SortOrder = *req.SortOrder
+	}
+}
+
+// 查詢可使用的運送方式列表
+func GetShippingMethodListHandler(c *gin.Context, db *gorm.DB) {
+	var shippingMethods []models.ShippingMethod
+	err := db.
+		Where("enabled = ?", true).
+		Order("sort_order ASC, id ASC").
+		Find(&shippingMethods).
+		Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取運送方式列表",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var shippingMethodsData []gin.H
+	for _, shippingMethod := range shippingMethods {
+		shippingMethodsData = append(shippingMethodsData, gin.H{
+			"code":                  shippingMethod.Code,
+			"name":                  shippingMethod.Name,
+			"description":           shippingMethod.Description,
+			"fee":                   shippingMethod.Fee,
+			"freeShippingThreshold": shippingMethod.FreeShippingThreshold,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "成功讀取運送方式列表",
+		"shippingMethods": shippingMethodsData,
+	})
+}
+
+// 查詢所有運送方式列表(包含停用)
+func GetAdminShippingMethodListHandler(c *gin.Context, db *gorm.DB) {
+	var shippingMethods []models.ShippingMethod
+	err := db.Order("sort_order ASC, id ASC").Find(&shippingMethods).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "無法讀取運送方式列表",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "成功讀取運送方式列表",
+		"shippingMethods": shippingMethods,
+	})
+}
+
+// 新增運送方式
+func CreateShippingMethodHandler(c *gin.Context, db *gorm.DB) {
+	var newShippingMethodReq shippingMethodReq
+	err := c.ShouldBindJSON(&newShippingMethodReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	//新運送方式預設啟用
+	shippingMethod := models.ShippingMethod{
+		Enabled: true,
+	}
+	newShippingMethodReq.applyTo(&shippingMethod)
+
+	if shippingMethod.Code == "" || shippingMethod.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "運送方式代碼及名稱不得為空",
+		})
+		return
+	}
+
+	var count int64
+	err = db.Unscoped().Model(&models.ShippingMethod{}).Where("code = ?", shippingMethod.Code).Count(&count).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "檢查運送方式代碼失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "運送方式代碼已被使用",
+		})
+		return
+	}
+
+	err = db.Create(&shippingMethod).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "新增運送方式失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":        "成功新增運送方式",
+		"shippingMethod": shippingMethod,
+	})
+}
+
+// 修改運送方式，代碼建立後不可修改
+func UpdateShippingMethodHandler(c *gin.Context, db *gorm.DB) {
+	shippingMethodID := c.Param("shippingMethodID")
+
+	var shippingMethodDataReq shippingMethodReq
+	err := c.ShouldBindJSON(&shippingMethodDataReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
+	}
+	shippingMethodDataReq.Code = nil
+
+	var shippingMethod models.ShippingMethod
+	err = db.First(&shippingMethod, shippingMethodID).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "查無此運送方式",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢運送方式失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	shippingMethodDataReq.applyTo(&shippingMethod)
+	if shippingMethod.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "運送方式名稱不得為空",
+		})
+		return
+	}
+
+	err = db.Save(&shippingMethod).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "修改運送方式失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "成功修改運送方式",
+		"shippingMethod": shippingMethod,
+	})
+}
+
+// 刪除運送方式，已送出的訂單仍保留運送方式名稱
+func DeleteShippingMethodHandler(c *gin.Context, db *gorm.DB) {
+	shippingMethodID := c.Param("shippingMethodID")
+
+	result := db.Delete(&models.ShippingMethod{}, shippingMethodID)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "刪除運送方式失敗",
+			"error":   result.Error.Error(),
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "查無此運送方式",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "成功刪除運送方式",
+	})
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"Backend/cache"
+	"Backend/models"
+	"Backend/reservation"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrVariantRequired = errors.New("請選擇商品規格")
+	ErrVariantNotFound = errors.New("查無此商品規格")
+)
+
+// 庫存單位，VariantID為0代表沒有規格的商品
+type stockKey struct {
+	ProductID uint
+	VariantID uint
+}
+
+// 將資料庫中可為NULL的規格ID轉為stockKey使用的值
+func variantIDValue(variantID *uint) uint {
+	if variantID == nil {
+		return 0
+	}
+	return *variantID
+}
+
+// 將stockKey的規格ID轉為資料庫中可為NULL的值
+func variantIDPtr(variantID uint) *uint {
+	if variantID == 0 {
+		return nil
+	}
+	return &variantID
+}
+
+// 依商品ID及規格ID排序，上鎖時依此順序避免與其他事務互相等待
+func sortStockKeys(keys []stockKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ProductID != keys[j].ProductID {
+			return keys[i].ProductID < keys[j].ProductID
+		}
+		return keys[i].VariantID < keys[j].VariantID
+	})
+}
+
+// 庫存扣除保留數量後的可購買數量
+func availableStock(stock uint, reserved uint) uint {
+	if reserved >= stock {
+		return 0
+	}
+	return stock - reserved
+}
+
+// lock為true時以FOR UPDATE鎖定查詢到的資料
+func lockForUpdate(lock bool) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if !lock {
+			return db
+		}
+		return db.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+}
+
+// 查詢商品及規格，有規格的商品必須指定規格
+// lock為true時鎖定商品及規格直到事務結束
+func findStockUnit(db *gorm.DB, key stockKey, lock bool) (models.Product, *models.ProductVariant, error) {
+	var product models.Product
+	err := db.Scopes(lockForUpdate(lock)).First(&product, key.ProductID).Error
+	if err != nil {
+		return product, nil, err
+	}
+
+	if key.VariantID == 0 {
+		var variantCount int64
+		err = db.Model(&models.ProductVariant{}).Where("product_id = ?", product.ID).Count(&variantCount).Error
+		if err != nil {
+			return product, nil, err
+		}
+		if variantCount > 0 {
+			return product, nil, ErrVariantRequired
+		}
+		return product, nil, nil
+	}
+
+	var variant models.ProductVariant
+	err = db.
+		Scopes(lockForUpdate(lock)).
+		Where("product_id = ?", product.ID).
+		First(&variant, key.VariantID).
+		Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return product, nil, ErrVariantNotFound
+		}
+		return product, nil, err
+	}
+
+	return product, &variant, nil
+}
+
+// 查詢商品或規格的可購買數量，excludeUserID的保留不扣除(未登入為0)
+func getAvailableStock(db *gorm.DB, key stockKey, excludeUserID uint) (uint, error) {
+	product, variant, err := findStockUnit(db, key, false)
+	if err != nil {
+		return 0, err
+	}
+
+	if variant != nil {
+		reserved, err := reservation.ReservedVariantQuantities(db, []uint{variant.ID}, excludeUserID)
+		if err != nil {
+			return 0, err
+		}
+		return availableStock(variant.Stock, reserved[variant.ID]), nil
+	}
+
+	reserved, err := reservation.ReservedQuantities(db, []uint{product.ID}, excludeUserID)
+	if err != nil {
+		return 0, err
+	}
+	return availableStock(product.Stock, reserved[product.ID]), nil
+}
+
+// 回應查詢庫存單位的錯誤，商品或規格不存在及未選擇規格時為請求錯誤
+func respondStockUnitError(c *gin.Context, err error) {
+	switch err {
+	case gorm.ErrRecordNotFound:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "查無此商品",
+		})
+	case ErrVariantRequired, ErrVariantNotFound:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查詢商品庫存錯誤",
+			"error":   err.Error(),
+		})
+	}
+}
+
+// 增減商品及規格的庫存，有規格時商品庫存同步增減以維持為規格庫存總和
+// tx應在事務中且商品及規格已上鎖
+func adjustStock(tx *gorm.DB, product *models.Product, variant *models.ProductVariant, delta int) error {
+	if variant != nil {
+		variant.Stock = uint(int(variant.Stock) + delta)
+		err := tx.Unscoped().Model(variant).Update("stock", variant.Stock).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	product.Stock = uint(int(product.Stock) + delta)
+	return tx.Unscoped().Model(product).Update("stock", product.Stock).Error
+}
+
+// 事務提交後重新從資料庫讀取商品並更新Redis商品快取
+// 資料庫已是正確資料，失敗時只記錄錯誤，由背景協調程序修復快取
+func refreshProductCache(c *gin.Context, db *gorm.DB, rdb *redis.Client, productIDs ...uint) {
+	err := cache.NewProductCache(db, rdb).Refresh(c, productIDs...)
+	if err != nil {
+		log.Printf("更新商品快取失敗，將由背景協調程序修復: %v\n", err)
+	}
+}
+
+// 已上鎖的庫存單位及可購買數量
+type lockedStockUnit struct {
+	Key       stockKey
+	Product   models.Product
+	Variant   *models.ProductVariant
+	Available uint
+}
+
+// 庫存單位的名稱，有規格時包含規格屬性
+func (u lockedStockUnit) Name() string {
+	if u.Variant != nil {
+		return u.Product.Name + " (" + u.Variant.OptionsLabel() + ")"
+	}
+	return u.Product.Name
+}
+
+// 依序鎖定多個庫存單位並計算扣除其他使用者保留後的可購買數量，tx應在事務中
+// 回傳的庫存單位依商品ID及規格ID排序，失敗時一併回傳發生錯誤的庫存單位
+func lockStockUnits(tx *gorm.DB, keys []stockKey, excludeUserID uint) ([]lockedStockUnit, stockKey, error) {
+	sortedKeys := make([]stockKey, len(keys))
+	copy(sortedKeys, keys)
+	sortStockKeys(sortedKeys)
+
+	units := make([]lockedStockUnit, 0, len(sortedKeys))
+	var productIDs, variantIDs []uint
+	for _, key := range sortedKeys {
+		product, variant, err := findStockUnit(tx, key, true)
+		if err != nil {
+			return nil, key, err
+		}
+		units = append(units, lockedStockUnit{
+			Key:     key,
+			Product: product,
+			Variant: variant,
+		})
+		if variant != nil {
+			variantIDs = append(variantIDs, variant.ID)
+		} else {
+			productIDs = append(productIDs, product.ID)
+		}
+	}
+
+	reservedProducts, err := reservation.ReservedQuantities(tx, productIDs, excludeUserID)
+	if err != nil {
+		return nil, stockKey{}, err
+	}
+	reservedVariants, err := reservation.ReservedVariantQuantities(tx, variantIDs, excludeUserID)
+	if err != nil {
+		return nil, stockKey{}, err
+	}
+
+	for i, unit := range units {
+		if unit.Variant != nil {
+			units[i].Available = availableStock(unit.Variant.Stock, reservedVariants[unit.Variant.ID])
+		} else {
+			units[i].Available = availableStock(unit.Product.Stock, reservedProducts[unit.Product.ID])
+		}
+	}
+
+	return units, stockKey{}, nil
+}
+
+// 篩選符合任一庫存單位的購物車商品，沒有規格的商品以variant_id為NULL比對
+func matchStockKeys(keys []stockKey) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(keys) == 0 {
+			return db.Where("1 = 0")
+		}
+
+		conditions := make([]string, 0, len(keys))
+		var args []interface{}
+		for _, key := range keys {
+			if key.VariantID == 0 {
+				conditions = append(conditions, "(product_id = ? AND variant_id IS NULL)")
+				args = append(args, key.ProductID)
+			} else {
+				conditions = append(conditions, "(product_id = ? AND variant_id = ?)")
+				args = append(args, key.ProductID, key.VariantID)
+			}
+		}
+		return db.Where(strings.Join(conditions, " OR "), args...)
+	}
+}
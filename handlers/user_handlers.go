@@ -9,7 +9,6 @@ import (
 	"log"
 	"net/http"
 	"regexp"
-	"time"
 	"unicode"
 )
 
@@ -237,9 +236,8 @@ func LoginHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	//生成JWT Token
-	tokenExpiredTime := time.Now().Add(time.Hour * 24)
-	token, err := jwt.GenerateToken(user.Model.ID, user.Role, tokenExpiredTime.Unix())
+	//建立登入並生成Access Token及Refresh Token
+	tokens, err := jwt.Login(c, db, user.ID, user.Role, clientInfo(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "生成JWT Token錯誤",
@@ -248,31 +246,65 @@ func LoginHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	//儲存LoginToken
-	loginToken := models.LoginToken{
-		Token:          token,
-		ExpirationTime: tokenExpiredTime,
-		UserID:         user.ID,
-		Role:           user.Role,
+	//成功登入 回傳Token和成功訊息
+	respondTokens(c, tokens, "成功登入")
+}
+
+// 記錄於登入的用戶端IP及User-Agent
+func clientInfo(c *gin.Context) jwt.ClientInfo {
+	return jwt.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// 以Authorization標頭回傳Access Token，Refresh Token及過期時間放在回應內容
+func respondTokens(c *gin.Context, tokens jwt.TokenPair, message string) {
+	c.Header("Authorization", "Bearer "+tokens.AccessToken)
+	c.JSON(http.StatusOK, gin.H{
+		"message":               message,
+		"accessTokenExpiresAt":  tokens.AccessTokenExpiresAt,
+		"refreshToken":          tokens.RefreshToken,
+		"refreshTokenExpiresAt": tokens.RefreshTokenExpiresAt,
+	})
+}
+
+// 以Refresh Token換發新的Access Token及Refresh Token，每個Refresh Token只能使用一次
+// 已使用過的Refresh Token再次使用時撤銷該次登入的所有Token
+func RefreshTokenHandler(c *gin.Context, db *gorm.DB) {
+	var refreshReq struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&refreshReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "綁定請求資料錯誤",
+			"error":   err.Error(),
+		})
+		return
 	}
-	err = db.Create(&loginToken).Error
+
+	tokens, err := jwt.Refresh(c, db, refreshReq.RefreshToken, clientInfo(c))
 	if err != nil {
+		if err == jwt.ErrRefreshTokenInvalid || err == jwt.ErrRefreshTokenReused {
+			log.Printf("無法更新Token: %v\n", err)
+			c.Header("Authorization", "")
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "儲存Login Token失敗",
+			"message": "更新Token失敗",
 			"error":   err.Error(),
 		})
 		return
 	}
 
-	//成功登入 回傳Token和成功訊息
-	c.Header("Authorization", "Bearer "+token)
-	c.JSON(http.StatusOK, gin.H{
-		"message": "成功登入",
-	})
+	respondTokens(c, tokens, "成功更新Token")
 }
 
 func LogOutHandler(c *gin.Context, db *gorm.DB) {
-	token, exists := c.Get("Token")
+	sessionID, exists := c.Get("SessionID")
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "無法取得Token",
@@ -280,10 +312,8 @@ func LogOutHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	//刪除此LoginToken
-	var loginToken models.LoginToken
-	result := db.Delete(&loginToken, "Token = ?", token)
-	err := result.Error
+	//刪除此LoginToken及其所有Refresh Token
+	found, err := jwt.Logout(c, db, sessionID.(uint))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "資料庫錯誤",
@@ -291,7 +321,7 @@ func LogOutHandler(c *gin.Context, db *gorm.DB) {
 		})
 		return
 	}
-	if result.RowsAffected == 0 {
+	if !found {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "找不到此token或已登出",
 		})
@@ -352,6 +382,7 @@ func UpdateUserProfileHandler(c *gin.Context, db *gorm.DB) {
 			"message": "發生錯誤:無法取得使用者資料",
 			"error":   err.Error(),
 		})
+		return
 	}
 
 	var newUserData struct {
@@ -380,7 +411,8 @@ func UpdateUserProfileHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	if newUserData.NewPassword != "" {
+	passwordChanged := newUserData.NewPassword != ""
+	if passwordChanged {
 		if !ValidatePassword(newUserData.NewPassword) {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": "不合法的新密碼",
@@ -420,9 +452,26 @@ func UpdateUserProfileHandler(c *gin.Context, db *gorm.DB) {
 		user.Address = *newUserData.Address
 	}
 
-	result := db.Where("id = ?", userID).Save(&user)
+	//修改密碼與撤銷其他登入在同一個事務中，撤銷失敗時密碼也不會被修改
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "開啟資料庫事務失敗",
+			"error":   tx.Error.Error(),
+		})
+		return
+	}
+
+	result := tx.Where("id = ?", userID).Save(&user)
 	err = result.Error
 	if err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -430,12 +479,46 @@ func UpdateUserProfileHandler(c *gin.Context, db *gorm.DB) {
 	}
 
 	if result.RowsAffected == 0 {
+		tx.Rollback()
 		c.JSON(http.StatusOK, gin.H{
 			"message": "沒有變更資料",
 		})
 		return
 	}
 
+	//修改密碼後撤銷目前以外的所有登入
+	var revokedSessionIDs []uint
+	if passwordChanged {
+		sessionID, _ := c.Get("SessionID")
+		revokedSessionIDs, err = jwt.RevokeUserSessionsTx(tx, user.ID, sessionID.(uint))
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "撤銷其他登入失敗",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "提交事務失敗",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if passwordChanged {
+		jwt.RevokeSessionCache(c, revokedSessionIDs...)
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "成功修改使用者資料，已撤銷其他登入",
+			"revokedCount": len(revokedSessionIDs),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "成功修改使用者資料",
 	})
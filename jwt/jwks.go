@@ -0,0 +1,44 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"math/big"
+	"sort"
+)
+
+// JSON Web Key，只包含驗證簽章所需的RSA公鑰
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JSON Web Key Set，其他服務可用來驗證本服務簽發的Token
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// 所有可用於驗證的公鑰，依kid排序
+func PublicJWKS() (JWKS, error) {
+	keys, _, err := loadedKeys()
+	if err != nil {
+		return JWKS{}, err
+	}
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(keys))}
+	for keyID, pair := range keys {
+		jwks.Keys = append(jwks.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: keyID,
+			N:   base64.RawURLEncoding.EncodeToString(pair.publicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pair.publicKey.E)).Bytes()),
+		})
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks, nil
+}
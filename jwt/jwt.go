@@ -1,100 +1,78 @@
 package jwt
 
 import (
-	"Backend/models"
-	"crypto/rsa"
+	"context"
+	"errors"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
-	"log"
-	"os"
+	"time"
 )
 
-var (
-	privateKeyPath = "jwt/private_key.pem"
-	publicKeyPath  = "jwt/public_key.pem"
-)
-
-// 讀取私鑰
-func loadPrivateKey() (*rsa.PrivateKey, error) {
-	keyBytes, err := os.ReadFile(privateKeyPath)
-	if err != nil {
-		return nil, err
-	}
-
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyBytes)
-	if err != nil {
-		return nil, err
-	}
-
-	return key, nil
+// Access Token的內容，SessionID(sid)為登入(LoginToken)的ID，ID(jti)為每個Token唯一的ID
+type Claims struct {
+	UserID    uint   `json:"userID"`
+	Role      string `json:"role"`
+	SessionID uint   `json:"sid"`
+	jwt.RegisteredClaims
 }
 
-// 讀取公鑰
-func loadPublicKey() (*rsa.PublicKey, error) {
-	keyBytes, err := os.ReadFile(publicKeyPath)
-	if err != nil {
-		return nil, err
-	}
+var ErrTokenRevoked = errors.New("Token已登出或已被撤銷")
 
-	key, err := jwt.ParseRSAPublicKeyFromPEM(keyBytes)
+// 生成JWT Token，回傳Token及其jti
+func GenerateToken(userID uint, role string, sessionID uint, expTime time.Time) (string, string, error) {
+	keyID, privateKey, err := signingKey()
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
-	return key, nil
-}
-
-// 生成JWT Token
-func GenerateToken(userID uint, role string, expTime int64) (string, error) {
-	privateKey, err := loadPrivateKey()
-	if err != nil {
-		return "", err
+	claims := Claims{
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expTime),
+		},
 	}
-
-	token := jwt.New(jwt.SigningMethodRS256)
-
-	claims := token.Claims.(jwt.MapClaims)
-	claims["userID"] = userID
-	claims["exp"] = expTime //time.Now().Add(time.Hour).Unix()
-	claims["role"] = role
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
 
 	tokenString, err := token.SignedString(privateKey)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return tokenString, nil
+	return tokenString, claims.ID, nil
 }
 
-// 驗證JWT Token並回傳UserID
-func VerifyToken(tokenString *string, db *gorm.DB) (uint, string, error) {
-	publicKey, err := loadPublicKey()
+// 驗證JWT Token並回傳其內容
+// 簽章及過期時間以記憶體中kid對應的公鑰驗證，是否已撤銷優先查詢Redis中的登入快取，快取中沒有時才查詢資料庫
+func VerifyToken(ctx context.Context, tokenString string, db *gorm.DB) (*Claims, error) {
+	var claims Claims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		//依標頭的kid選擇公鑰，沒有kid的Token不接受
+		keyID, _ := token.Header["kid"].(string)
+		return verificationKey(keyID)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
 	if err != nil {
-		return 0, "", err
+		return nil, err
 	}
 
-	token, err := jwt.Parse(*tokenString, func(token *jwt.Token) (interface{}, error) {
-		return publicKey, nil
-	})
-	if err != nil {
-		return 0, "", err
+	if !token.Valid {
+		return nil, jwt.ErrTokenSignatureInvalid
 	}
 
-	if !token.Valid {
-		return 0, "", jwt.ErrTokenSignatureInvalid
+	//沒有sid、jti或過期時間的舊Token視為無效
+	if claims.SessionID == 0 || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil, ErrTokenRevoked
 	}
 
-	//從資料庫檢查Token是否刪除
-	var loginToken models.LoginToken
-	err = db.Where("token = ?", *tokenString).First(&loginToken).Error
+	err = checkSession(ctx, db, &claims)
 	if err != nil {
-		log.Println(err)
-		return 0, "", err
+		return nil, err
 	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	userID := uint(claims["userID"].(float64))
-	role := claims["role"].(string)
-
-	return userID, role, nil
+	return &claims, nil
 }
@@ -0,0 +1,248 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 金鑰的設定，ID為Token標頭中的kid
+// 只用於驗證的舊金鑰可只提供公鑰，只提供私鑰時由私鑰產生公鑰
+type KeyConfig struct {
+	ID             string
+	PrivateKeyPath string
+	PublicKeyPath  string
+}
+
+// 未設定金鑰時使用的預設金鑰
+var defaultKeyConfigs = []KeyConfig{{
+	ID:             "default",
+	PrivateKeyPath: "jwt/private_key.pem",
+	PublicKeyPath:  "jwt/public_key.pem",
+}}
+
+var ErrUnknownKeyID = errors.New("未知的JWT金鑰ID")
+
+type signingKeyPair struct {
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey
+}
+
+// 已載入的金鑰，由SetupKeys設定後載入，之後由RunKeyReloader在檔案變更時重新載入
+// 所有設定的公鑰都可用於驗證，只有signingKeyID的私鑰用於簽發新的Token
+var ring struct {
+	mu           sync.RWMutex
+	configs      []KeyConfig
+	signingKeyID string
+	keys         map[string]signingKeyPair
+	modTime      time.Time
+}
+
+func init() {
+	ring.configs = defaultKeyConfigs
+	ring.signingKeyID = defaultKeyConfigs[0].ID
+}
+
+// 讀取私鑰
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	keyBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// 讀取公鑰
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+	keyBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwt.ParseRSAPublicKeyFromPEM(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// 設定金鑰並載入，configs為空時使用jwt/private_key.pem及jwt/public_key.pem
+// signingKeyID為空時使用第一個金鑰簽發Token
+func SetupKeys(configs []KeyConfig, signingKeyID string) error {
+	if len(configs) == 0 {
+		configs = defaultKeyConfigs
+	}
+	if signingKeyID == "" {
+		signingKeyID = configs[0].ID
+	}
+
+	ring.mu.Lock()
+	ring.configs = configs
+	ring.signingKeyID = signingKeyID
+	ring.keys = nil
+	ring.mu.Unlock()
+
+	return ReloadKeys()
+}
+
+// 金鑰檔案中最晚的修改時間
+func keysModTime(configs []KeyConfig) (time.Time, error) {
+	var latest time.Time
+	for _, config := range configs {
+		for _, path := range []string{config.PrivateKeyPath, config.PublicKeyPath} {
+			if path == "" {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return latest, err
+			}
+			if info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+		}
+	}
+	return latest, nil
+}
+
+// 讀取單一金鑰
+func loadKeyPair(config KeyConfig) (signingKeyPair, error) {
+	var pair signingKeyPair
+	var err error
+	if config.PrivateKeyPath != "" {
+		pair.privateKey, err = loadPrivateKey(config.PrivateKeyPath)
+		if err != nil {
+			return pair, err
+		}
+		pair.publicKey = &pair.privateKey.PublicKey
+	}
+	if config.PublicKeyPath != "" {
+		pair.publicKey, err = loadPublicKey(config.PublicKeyPath)
+		if err != nil {
+			return pair, err
+		}
+	}
+	if pair.publicKey == nil {
+		return pair, fmt.Errorf("金鑰%s未設定私鑰或公鑰", config.ID)
+	}
+	if pair.privateKey != nil && pair.privateKey.PublicKey.N.Cmp(pair.publicKey.N) != 0 {
+		return pair, fmt.Errorf("金鑰%s的私鑰與公鑰不符", config.ID)
+	}
+	return pair, nil
+}
+
+// 重新從檔案載入所有金鑰，全部讀取成功且簽發用的金鑰有私鑰才替換，失敗時繼續使用原本的金鑰
+func ReloadKeys() error {
+	ring.mu.RLock()
+	configs := ring.configs
+	signingKeyID := ring.signingKeyID
+	ring.mu.RUnlock()
+
+	modTime, err := keysModTime(configs)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]signingKeyPair, len(configs))
+	for _, config := range configs {
+		if config.ID == "" {
+			return errors.New("JWT金鑰ID不得為空")
+		}
+		if _, ok := keys[config.ID]; ok {
+			return fmt.Errorf("JWT金鑰ID重複: %s", config.ID)
+		}
+		pair, err := loadKeyPair(config)
+		if err != nil {
+			return fmt.Errorf("無法讀取JWT金鑰%s: %w", config.ID, err)
+		}
+		keys[config.ID] = pair
+	}
+	if keys[signingKeyID].privateKey == nil {
+		return fmt.Errorf("簽發用的JWT金鑰%s沒有私鑰", signingKeyID)
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.keys = keys
+	ring.modTime = modTime
+	return nil
+}
+
+// 取得已載入的金鑰，尚未載入時從檔案讀取
+func loadedKeys() (map[string]signingKeyPair, string, error) {
+	ring.mu.RLock()
+	keys := ring.keys
+	signingKeyID := ring.signingKeyID
+	ring.mu.RUnlock()
+	if keys != nil {
+		return keys, signingKeyID, nil
+	}
+
+	err := ReloadKeys()
+	if err != nil {
+		return nil, "", err
+	}
+	return loadedKeys()
+}
+
+// 簽發Token使用的金鑰ID及私鑰
+func signingKey() (string, *rsa.PrivateKey, error) {
+	keys, signingKeyID, err := loadedKeys()
+	if err != nil {
+		return "", nil, err
+	}
+	return signingKeyID, keys[signingKeyID].privateKey, nil
+}
+
+// 依kid取得驗證用的公鑰
+func verificationKey(keyID string) (*rsa.PublicKey, error) {
+	keys, _, err := loadedKeys()
+	if err != nil {
+		return nil, err
+	}
+	pair, ok := keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return pair.publicKey, nil
+}
+
+// 定期檢查金鑰檔案，修改時間改變時重新載入，更換金鑰檔案後不須重新啟動
+func RunKeyReloader(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ring.mu.RLock()
+		configs := ring.configs
+		loadedModTime := ring.modTime
+		ring.mu.RUnlock()
+
+		modTime, err := keysModTime(configs)
+		if err != nil {
+			log.Printf("無法讀取JWT金鑰檔案: %v\n", err)
+			continue
+		}
+		if modTime.Equal(loadedModTime) {
+			continue
+		}
+
+		if err := ReloadKeys(); err != nil {
+			log.Printf("重新載入JWT金鑰失敗，繼續使用原本的金鑰: %v\n", err)
+			continue
+		}
+		log.Println("已重新載入JWT金鑰")
+	}
+}
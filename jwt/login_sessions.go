@@ -0,0 +1,183 @@
+package jwt
+
+import (
+	"Backend/models"
+	"context"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"time"
+)
+
+// 登入或換發Token時的用戶端資料，用於讓使用者辨識自己的登入
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// 資料庫欄位的長度上限
+const maxUserAgentLength = 512
+
+// 記錄用戶端資料及最後使用時間
+func (client ClientInfo) applyTo(loginToken *models.LoginToken, now time.Time) {
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	loginToken.IP = client.IP
+	loginToken.UserAgent = userAgent
+	loginToken.Device = deviceName(userAgent)
+	loginToken.LastSeenAt = now
+}
+
+// 由User-Agent判斷瀏覽器及作業系統，例如"Chrome on Windows"，無法判斷時回傳空字串
+func deviceName(userAgent string) string {
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	systems := []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+
+	browser := ""
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	system := ""
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	default:
+		return system
+	}
+}
+
+// 查詢使用者有效的登入(Refresh Token尚未過期)，最近使用的在前
+func ListSessions(db *gorm.DB, userID uint) ([]models.LoginToken, error) {
+	var sessions []models.LoginToken
+	err := db.
+		Select("id", "created_at", "refresh_expiration_time", "user_id", "ip", "user_agent", "device", "last_seen_at").
+		Where("user_id = ? AND refresh_expiration_time > ?", userID, time.Now()).
+		Order("last_seen_at DESC, id DESC").
+		Find(&sessions).
+		Error
+	return sessions, err
+}
+
+// 撤銷使用者的一個登入，回傳是否有找到登入
+func RevokeSession(ctx context.Context, db *gorm.DB, userID uint, sessionID uint) (bool, error) {
+	sessionIDs, err := revokeSessions(ctx, db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ? AND user_id = ?", sessionID, userID)
+	})
+	return len(sessionIDs) > 0, err
+}
+
+// 撤銷使用者的所有登入，exceptSessionID不為0時保留該登入，回傳撤銷的登入數量
+func RevokeUserSessions(ctx context.Context, db *gorm.DB, userID uint, exceptSessionID uint) (int, error) {
+	sessionIDs, err := revokeSessions(ctx, db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ? AND id <> ?", userID, exceptSessionID)
+	})
+	return len(sessionIDs), err
+}
+
+// 在事務中撤銷使用者的所有登入，exceptSessionID不為0時保留該登入，回傳撤銷的登入ID
+// 事務提交後須呼叫RevokeSessionCache，用於需要與其他資料一起修改的情況(如修改密碼)
+func RevokeUserSessionsTx(tx *gorm.DB, userID uint, exceptSessionID uint) ([]uint, error) {
+	return revokeSessionsTx(tx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ? AND id <> ?", userID, exceptSessionID)
+	})
+}
+
+// 在Redis標記登入已撤銷，應在RevokeUserSessionsTx的事務提交後呼叫
+func RevokeSessionCache(ctx context.Context, sessionIDs ...uint) {
+	revokeSessionCache(ctx, sessionIDs...)
+}
+
+// 變更使用者的角色，使用者所有登入目前的Access Token隨即失效，以Refresh Token換發後使用新的角色
+// 登入及Refresh Token仍然有效，不需要重新登入
+func ChangeUserRole(ctx context.Context, db *gorm.DB, userID uint, role string) error {
+	var sessions []models.LoginToken
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ?", userID).Update("role", role).Error
+		if err != nil {
+			return err
+		}
+
+		//清除登入目前的jti，驗證Token時與快取及資料庫都不符
+		err = tx.Select("id", "expiration_time").Where("user_id = ?", userID).Find(&sessions).Error
+		if err != nil || len(sessions) == 0 {
+			return err
+		}
+		return tx.
+			Model(&models.LoginToken{}).
+			Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"role": role, "jti": ""}).
+			Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		cacheSession(ctx, session.ID, "", session.ExpirationTime)
+	}
+	return nil
+}
+
+// 永久刪除已過期的Refresh Token、已過期及已撤銷的登入，回傳刪除的登入數量
+// 已撤銷的登入在Redis的撤銷標記過期後，驗證Token時查無登入同樣視為已撤銷
+func PurgeExpiredSessions(db *gorm.DB) (int64, error) {
+	now := time.Now()
+	err := db.Where("expires_at <= ?", now).Delete(&models.RefreshToken{}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	result := db.
+		Unscoped().
+		Where("refresh_expiration_time <= ? OR deleted_at IS NOT NULL", now).
+		Delete(&models.LoginToken{})
+	return result.RowsAffected, result.Error
+}
+
+// 定期刪除過期的登入，應以goroutine執行
+func RunSessionSweeper(db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := PurgeExpiredSessions(db)
+		if err != nil {
+			log.Printf("清除過期登入失敗: %v\n", err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("已清除%d筆過期登入\n", count)
+		}
+	}
+}
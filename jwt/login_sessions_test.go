@@ -0,0 +1,54 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// 變更角色後使用者所有登入的Access Token立即失效，換發的Token使用新的角色
+func TestChangeUserRoleInvalidatesAccessTokens(t *testing.T) {
+	db, server := setupTestSession(t)
+	user := createTestUser(t, db)
+	ctx := context.Background()
+
+	first, err := Login(ctx, db, user.ID, "admin", ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	other, err := Login(ctx, db, user.ID, "admin", ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	claims, err := VerifyToken(ctx, first.AccessToken, db)
+	if err != nil {
+		t.Fatalf("VerifyToken before role change: %v", err)
+	}
+
+	if err := ChangeUserRole(ctx, db, user.ID, "user"); err != nil {
+		t.Fatalf("ChangeUserRole: %v", err)
+	}
+
+	//快取中的登入已更新，不需要查詢資料庫即可拒絕舊Token
+	if value, err := server.Get(sessionKey(claims.SessionID)); err != nil || value != "" {
+		t.Errorf("session cache = %q, want empty jti", value)
+	}
+	for name, pair := range map[string]TokenPair{"first": first, "other": other} {
+		if _, err := VerifyToken(ctx, pair.AccessToken, db); !errors.Is(err, ErrTokenRevoked) {
+			t.Errorf("VerifyToken(%s) error = %v, want %v", name, err, ErrTokenRevoked)
+		}
+	}
+
+	//Refresh Token仍可使用，換發的Token帶有新的角色
+	refreshed, err := Refresh(ctx, db, first.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Refresh after role change: %v", err)
+	}
+	claims, err = VerifyToken(ctx, refreshed.AccessToken, db)
+	if err != nil {
+		t.Fatalf("VerifyToken(refreshed): %v", err)
+	}
+	if claims.Role != "user" {
+		t.Errorf("role = %q, want user", claims.Role)
+	}
+}
@@ -0,0 +1,273 @@
+package jwt
+
+import (
+	"Backend/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// Access Token的有效時間較短，過期後以Refresh Token取得新的Token
+// Refresh Token每次使用後重新計算有效時間，超過有效時間未使用即須重新登入
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("Refresh Token無效或已過期")
+	ErrRefreshTokenReused  = errors.New("Refresh Token已被使用，已撤銷此登入的所有Token")
+)
+
+// 登入或更新Token時回傳的Token
+type TokenPair struct {
+	AccessToken           string
+	AccessTokenExpiresAt  time.Time
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+}
+
+// 產生隨機的Refresh Token及其雜湊
+func newRefreshToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashRefreshToken(token), nil
+}
+
+// 資料庫只儲存Refresh Token的雜湊，資料外洩時無法直接使用
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 為登入產生新的Access Token及Refresh Token並更新LoginToken及用戶端資料，tx應在事務中
+func issueTokens(tx *gorm.DB, loginToken *models.LoginToken, client ClientInfo) (TokenPair, error) {
+	now := time.Now()
+	client.applyTo(loginToken, now)
+	pair := TokenPair{
+		AccessTokenExpiresAt:  now.Add(AccessTokenTTL),
+		RefreshTokenExpiresAt: now.Add(RefreshTokenTTL),
+	}
+
+	accessToken, jti, err := GenerateToken(loginToken.UserID, loginToken.Role, loginToken.ID, pair.AccessTokenExpiresAt)
+	if err != nil {
+		return pair, err
+	}
+	pair.AccessToken = accessToken
+
+	refreshToken, refreshTokenHash, err := newRefreshToken()
+	if err != nil {
+		return pair, err
+	}
+	pair.RefreshToken = refreshToken
+
+	loginToken.JTI = jti
+	loginToken.ExpirationTime = pair.AccessTokenExpiresAt
+	loginToken.RefreshExpirationTime = pair.RefreshTokenExpiresAt
+	err = tx.Save(loginToken).Error
+	if err != nil {
+		return pair, err
+	}
+
+	err = tx.Create(&models.RefreshToken{
+		LoginTokenID: loginToken.ID,
+		UserID:       loginToken.UserID,
+		TokenHash:    refreshTokenHash,
+		ExpiresAt:    pair.RefreshTokenExpiresAt,
+	}).Error
+	return pair, err
+}
+
+// 建立新的登入並回傳Access Token及Refresh Token
+func Login(ctx context.Context, db *gorm.DB, userID uint, role string, client ClientInfo) (TokenPair, error) {
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	if tx.Error != nil {
+		return TokenPair{}, tx.Error
+	}
+
+	loginToken := models.LoginToken{
+		UserID: userID,
+		Role:   role,
+	}
+	err := tx.Create(&loginToken).Error
+	if err != nil {
+		tx.Rollback()
+		return TokenPair{}, err
+	}
+
+	pair, err := issueTokens(tx, &loginToken, client)
+	if err != nil {
+		tx.Rollback()
+		return TokenPair{}, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return TokenPair{}, err
+	}
+
+	cacheSession(ctx, loginToken.ID, loginToken.JTI, loginToken.ExpirationTime)
+	return pair, nil
+}
+
+// 以Refresh Token換發新的Access Token及Refresh Token，舊的Refresh Token之後不可再使用
+// Refresh Token已被使用過時視為被盜用，撤銷同一次登入的所有Token並回傳ErrRefreshTokenReused
+func Refresh(ctx context.Context, db *gorm.DB, refreshToken string, client ClientInfo) (TokenPair, error) {
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	if tx.Error != nil {
+		return TokenPair{}, tx.Error
+	}
+
+	//鎖定Refresh Token，同時使用同一個Refresh Token的請求只有一個能成功
+	var stored models.RefreshToken
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hashRefreshToken(refreshToken)).
+		First(&stored).
+		Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			return TokenPair{}, ErrRefreshTokenInvalid
+		}
+		return TokenPair{}, err
+	}
+
+	if stored.UsedAt != nil {
+		err = revokeLogin(tx, stored.LoginTokenID)
+		if err != nil {
+			tx.Rollback()
+			return TokenPair{}, err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return TokenPair{}, err
+		}
+		revokeSessionCache(ctx, stored.LoginTokenID)
+		return TokenPair{}, ErrRefreshTokenReused
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		tx.Rollback()
+		return TokenPair{}, ErrRefreshTokenInvalid
+	}
+
+	//鎖定登入，避免同時撤銷的登入被換發Token時寫回
+	var loginToken models.LoginToken
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&loginToken, stored.LoginTokenID).
+		Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			return TokenPair{}, ErrRefreshTokenInvalid
+		}
+		return TokenPair{}, err
+	}
+
+	//以使用者目前的角色換發Token，角色變更後換發的Token即使用新的角色
+	var user models.User
+	err = tx.Select("id", "role").First(&user, loginToken.UserID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			return TokenPair{}, ErrRefreshTokenInvalid
+		}
+		return TokenPair{}, err
+	}
+	loginToken.Role = user.Role
+
+	now := time.Now()
+	err = tx.Model(&stored).Update("used_at", &now).Error
+	if err != nil {
+		tx.Rollback()
+		return TokenPair{}, err
+	}
+
+	pair, err := issueTokens(tx, &loginToken, client)
+	if err != nil {
+		tx.Rollback()
+		return TokenPair{}, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return TokenPair{}, err
+	}
+
+	cacheSession(ctx, loginToken.ID, loginToken.JTI, loginToken.ExpirationTime)
+	return pair, nil
+}
+
+// 撤銷登入，刪除LoginToken及其所有Refresh Token，tx應在事務中
+func revokeLogin(tx *gorm.DB, loginTokenIDs ...uint) error {
+	err := tx.Where("login_token_id IN ?", loginTokenIDs).Delete(&models.RefreshToken{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Delete(&models.LoginToken{}, loginTokenIDs).Error
+}
+
+// 撤銷符合條件的所有登入，回傳撤銷的登入ID
+func revokeSessions(ctx context.Context, db *gorm.DB, query func(db *gorm.DB) *gorm.DB) ([]uint, error) {
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	sessionIDs, err := revokeSessionsTx(tx, query)
+	if err != nil || len(sessionIDs) == 0 {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	revokeSessionCache(ctx, sessionIDs...)
+	return sessionIDs, nil
+}
+
+// 在事務中撤銷符合條件的所有登入，回傳撤銷的登入ID
+// 不會更新Redis，呼叫者應在事務提交後以RevokeSessionCache標記撤銷
+func revokeSessionsTx(tx *gorm.DB, query func(db *gorm.DB) *gorm.DB) ([]uint, error) {
+	var sessionIDs []uint
+	err := query(tx.Model(&models.LoginToken{})).Pluck("id", &sessionIDs).Error
+	if err != nil || len(sessionIDs) == 0 {
+		return nil, err
+	}
+
+	err = revokeLogin(tx, sessionIDs...)
+	if err != nil {
+		return nil, err
+	}
+	return sessionIDs, nil
+}
+
+// 登出，撤銷此登入的Access Token及所有Refresh Token，回傳是否有找到登入
+func Logout(ctx context.Context, db *gorm.DB, sessionID uint) (bool, error) {
+	sessionIDs, err := revokeSessions(ctx, db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", sessionID)
+	})
+	return len(sessionIDs) > 0, err
+}
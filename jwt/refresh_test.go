@@ -0,0 +1,253 @@
+package jwt
+
+import (
+	"Backend/models"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 產生測試用的RSA金鑰並設定為簽發用的金鑰
+func setupTestKeys(t *testing.T) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "private_key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, keyPEM, 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	if err := SetupKeys([]KeyConfig{{ID: "test", PrivateKeyPath: path}}, ""); err != nil {
+		t.Fatalf("setup keys: %v", err)
+	}
+}
+
+// 建立測試用的SQLite資料庫及登入快取使用的miniredis
+func setupTestSession(t *testing.T) (*gorm.DB, *miniredis.Miniredis) {
+	t.Helper()
+	setupTestKeys(t)
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(&models.User{}, &models.LoginToken{}, &models.RefreshToken{})
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	SetupSessionCache(rdb)
+	t.Cleanup(func() {
+		SetupSessionCache(nil)
+		rdb.Close()
+	})
+	return db, server
+}
+
+func createTestUser(t *testing.T, db *gorm.DB) models.User {
+	t.Helper()
+	user := models.User{Username: "tester", Email: "tester@example.com", Password: "x", Role: "user"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	db, _ := setupTestSession(t)
+	user := createTestUser(t, db)
+	ctx := context.Background()
+
+	first, err := Login(ctx, db, user.ID, user.Role, ClientInfo{IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	second, err := Refresh(ctx, db, first.RefreshToken, ClientInfo{IP: "127.0.0.2"})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("Refresh should issue a new access token and refresh token")
+	}
+
+	var used models.RefreshToken
+	err = db.Where("token_hash = ?", hashRefreshToken(first.RefreshToken)).First(&used).Error
+	if err != nil {
+		t.Fatalf("find used token: %v", err)
+	}
+	if used.UsedAt == nil {
+		t.Error("used refresh token should have UsedAt set")
+	}
+
+	//舊的Access Token已被新的取代，新的Access Token可通過驗證
+	if _, err := VerifyToken(ctx, first.AccessToken, db); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("VerifyToken(old access token) error = %v, want %v", err, ErrTokenRevoked)
+	}
+	claims, err := VerifyToken(ctx, second.AccessToken, db)
+	if err != nil {
+		t.Fatalf("VerifyToken(new access token): %v", err)
+	}
+	if claims.SessionID != used.LoginTokenID {
+		t.Errorf("SessionID = %d, want %d", claims.SessionID, used.LoginTokenID)
+	}
+
+	//新的Refresh Token可再換發一次
+	if _, err := Refresh(ctx, db, second.RefreshToken, ClientInfo{}); err != nil {
+		t.Fatalf("Refresh(second): %v", err)
+	}
+}
+
+func TestRefreshReuseRevokesLogin(t *testing.T) {
+	db, server := setupTestSession(t)
+	user := createTestUser(t, db)
+	ctx := context.Background()
+
+	first, err := Login(ctx, db, user.ID, user.Role, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	second, err := Refresh(ctx, db, first.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	var stored models.RefreshToken
+	err = db.Where("token_hash = ?", hashRefreshToken(first.RefreshToken)).First(&stored).Error
+	if err != nil {
+		t.Fatalf("find refresh token: %v", err)
+	}
+
+	_, err = Refresh(ctx, db, first.RefreshToken, ClientInfo{})
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Refresh(reused) error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+
+	var loginCount int64
+	db.Model(&models.LoginToken{}).Where("id = ?", stored.LoginTokenID).Count(&loginCount)
+	if loginCount != 0 {
+		t.Errorf("LoginToken should be revoked, found %d", loginCount)
+	}
+	var refreshCount int64
+	db.Model(&models.RefreshToken{}).Where("login_token_id = ?", stored.LoginTokenID).Count(&refreshCount)
+	if refreshCount != 0 {
+		t.Errorf("all RefreshTokens of the login should be deleted, found %d", refreshCount)
+	}
+
+	if value, _ := server.Get(sessionKey(stored.LoginTokenID)); value != revokedSession {
+		t.Errorf("session cache = %q, want %q", value, revokedSession)
+	}
+
+	//同一次登入最新的Token也不可再使用
+	if _, err := Refresh(ctx, db, second.RefreshToken, ClientInfo{}); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("Refresh(latest) error = %v, want %v", err, ErrRefreshTokenInvalid)
+	}
+	if _, err := VerifyToken(ctx, second.AccessToken, db); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("VerifyToken(latest access token) error = %v, want %v", err, ErrTokenRevoked)
+	}
+}
+
+func TestRefreshReuseKeepsOtherLogins(t *testing.T) {
+	db, _ := setupTestSession(t)
+	user := createTestUser(t, db)
+	ctx := context.Background()
+
+	stolen, err := Login(ctx, db, user.ID, user.Role, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	other, err := Login(ctx, db, user.ID, user.Role, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if _, err := Refresh(ctx, db, stolen.RefreshToken, ClientInfo{}); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if _, err := Refresh(ctx, db, stolen.RefreshToken, ClientInfo{}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Refresh(reused) error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+
+	if _, err := Refresh(ctx, db, other.RefreshToken, ClientInfo{}); err != nil {
+		t.Errorf("Refresh(other login): %v", err)
+	}
+}
+
+func TestRefreshInvalidToken(t *testing.T) {
+	db, _ := setupTestSession(t)
+	user := createTestUser(t, db)
+	ctx := context.Background()
+
+	pair, err := Login(ctx, db, user.ID, user.Role, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func() string
+	}{
+		{
+			name:  "不存在的Token",
+			setup: func() string { return "not-a-refresh-token" },
+		},
+		{
+			name: "已過期的Token",
+			setup: func() string {
+				err := db.
+					Model(&models.RefreshToken{}).
+					Where("token_hash = ?", hashRefreshToken(pair.RefreshToken)).
+					Update("expires_at", time.Now().Add(-time.Minute)).
+					Error
+				if err != nil {
+					t.Fatalf("expire token: %v", err)
+				}
+				return pair.RefreshToken
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Refresh(ctx, db, tt.setup(), ClientInfo{})
+			if !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Errorf("Refresh error = %v, want %v", err, ErrRefreshTokenInvalid)
+			}
+		})
+	}
+
+	//過期的Token不會被標記為已使用，登入仍然存在
+	var stored models.RefreshToken
+	err = db.Where("token_hash = ?", hashRefreshToken(pair.RefreshToken)).First(&stored).Error
+	if err != nil {
+		t.Fatalf("find refresh token: %v", err)
+	}
+	if stored.UsedAt != nil {
+		t.Error("expired refresh token should not be marked as used")
+	}
+}
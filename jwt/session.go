@@ -0,0 +1,110 @@
+package jwt
+
+import (
+	"Backend/cache"
+	"Backend/models"
+	"context"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Redis中的登入快取，session:<登入ID> 為目前有效Access Token的jti，已撤銷的登入為revokedSession
+// 有效的快取在Access Token過期時一併過期，撤銷的標記保留到該登入所有Access Token都已過期
+const (
+	sessionKeyPrefix = "session:"
+	revokedSession   = "revoked"
+)
+
+var sessionRDB *redis.Client
+
+// 只在登入未被標記撤銷時寫入jti，避免讀取資料庫後才寫入的快取覆蓋同時發生的撤銷
+var cacheSessionScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[2] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+return 1
+`)
+
+// 設定登入快取使用的Redis，未設定時每次都查詢資料庫
+func SetupSessionCache(rdb *redis.Client) {
+	sessionRDB = rdb
+}
+
+func sessionKey(sessionID uint) string {
+	return sessionKeyPrefix + strconv.FormatUint(uint64(sessionID), 10)
+}
+
+// 快取登入目前有效的jti，應在事務提交後呼叫，失敗時只記錄錯誤
+// 已標記撤銷的登入不會被覆蓋
+func cacheSession(ctx context.Context, sessionID uint, jti string, expiresAt time.Time) {
+	if sessionRDB == nil || !cache.RedisAvailable() {
+		return
+	}
+	ttl := time.Until(expiresAt)
+	if ttl < time.Millisecond {
+		return
+	}
+	err := cacheSessionScript.Run(ctx, sessionRDB, []string{sessionKey(sessionID)}, jti, revokedSession, ttl.Milliseconds()).Err()
+	if err != nil {
+		log.Printf("無法更新登入快取: %v\n", err)
+	}
+}
+
+// 標記登入已撤銷，應在事務提交後呼叫
+// 失敗時登入快取最多在Access Token過期前仍視為有效
+func revokeSessionCache(ctx context.Context, sessionIDs ...uint) {
+	if sessionRDB == nil || !cache.RedisAvailable() || len(sessionIDs) == 0 {
+		return
+	}
+	pipe := sessionRDB.Pipeline()
+	for _, sessionID := range sessionIDs {
+		pipe.Set(ctx, sessionKey(sessionID), revokedSession, AccessTokenTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		log.Printf("無法更新登入快取，已撤銷的Token在過期前可能仍可使用: %v\n", err)
+	}
+}
+
+// 檢查Token所屬的登入是否仍有效且Token為該登入目前的Access Token
+// Redis無法使用或快取中沒有時查詢資料庫並寫入快取
+func checkSession(ctx context.Context, db *gorm.DB, claims *Claims) error {
+	if sessionRDB != nil && cache.RedisAvailable() {
+		jti, err := sessionRDB.Get(ctx, sessionKey(claims.SessionID)).Result()
+		if err == nil {
+			if jti == claims.ID {
+				return nil
+			}
+			if jti == revokedSession {
+				return ErrTokenRevoked
+			}
+			//快取的jti不同時可能是換發Token期間寫入的舊資料，以資料庫為準
+		}
+		if err != nil && err != redis.Nil && err != cache.ErrRedisUnavailable {
+			log.Printf("無法讀取登入快取: %v\n", err)
+		}
+	}
+
+	var loginToken models.LoginToken
+	err := db.
+		Select("id", "jti", "expiration_time").
+		First(&loginToken, claims.SessionID).
+		Error
+	if err == gorm.ErrRecordNotFound {
+		revokeSessionCache(ctx, claims.SessionID)
+		return ErrTokenRevoked
+	}
+	if err != nil {
+		return err
+	}
+
+	cacheSession(ctx, loginToken.ID, loginToken.JTI, loginToken.ExpirationTime)
+	if loginToken.JTI != claims.ID {
+		return ErrTokenRevoked
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+// 計算查詢login_tokens的次數
+func countSessionQueries(t *testing.T, db *gorm.DB) *int {
+	t.Helper()
+	count := new(int)
+	err := db.Callback().Query().Before("gorm:query").Register("test:count_sessions", func(tx *gorm.DB) {
+		if tx.Statement.Table == "login_tokens" {
+			*count++
+		}
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+	return count
+}
+
+// 快取中有登入時驗證Token不查詢資料庫，快取遺失時查詢資料庫並寫回快取
+func TestVerifyTokenUsesSessionCache(t *testing.T) {
+	db, server := setupTestSession(t)
+	user := createTestUser(t, db)
+	ctx := context.Background()
+	queries := countSessionQueries(t, db)
+
+	pair, err := Login(ctx, db, user.ID, user.Role, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := VerifyToken(ctx, pair.AccessToken, db); err != nil {
+			t.Fatalf("VerifyToken: %v", err)
+		}
+	}
+	if *queries != 0 {
+		t.Errorf("login_tokens queries with cached session = %d, want 0", *queries)
+	}
+
+	server.FlushAll()
+	claims, err := VerifyToken(ctx, pair.AccessToken, db)
+	if err != nil {
+		t.Fatalf("VerifyToken after cache flushed: %v", err)
+	}
+	if *queries != 1 {
+		t.Errorf("login_tokens queries after cache flushed = %d, want 1", *queries)
+	}
+	if value, _ := server.Get(sessionKey(claims.SessionID)); value != claims.ID {
+		t.Errorf("session cache = %q, want jti %q", value, claims.ID)
+	}
+	if ttl := server.TTL(sessionKey(claims.SessionID)); ttl <= 0 || ttl > AccessTokenTTL {
+		t.Errorf("session cache TTL = %v, should expire with the access token", ttl)
+	}
+}
+
+// 撤銷的登入以快取中的撤銷標記拒絕，之後寫入快取也不會覆蓋撤銷標記
+func TestRevokedSessionStaysRevoked(t *testing.T) {
+	db, server := setupTestSession(t)
+	user := createTestUser(t, db)
+	ctx := context.Background()
+
+	revoked, err := Login(ctx, db, user.ID, user.Role, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	current, err := Login(ctx, db, user.ID, user.Role, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	claims, err := VerifyToken(ctx, revoked.AccessToken, db)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	currentClaims, err := VerifyToken(ctx, current.AccessToken, db)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+
+	count, err := RevokeUserSessions(ctx, db, user.ID, currentClaims.SessionID)
+	if err != nil || count != 1 {
+		t.Fatalf("RevokeUserSessions = %d, %v, want 1", count, err)
+	}
+	if value, _ := server.Get(sessionKey(claims.SessionID)); value != revokedSession {
+		t.Errorf("session cache = %q, want %q", value, revokedSession)
+	}
+
+	//讀取資料庫前取得的jti晚於撤銷寫入快取
+	cacheSession(ctx, claims.SessionID, claims.ID, claims.ExpiresAt.Time)
+	if _, err := VerifyToken(ctx, revoked.AccessToken, db); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("VerifyToken(revoked) error = %v, want %v", err, ErrTokenRevoked)
+	}
+	if _, err := Refresh(ctx, db, revoked.RefreshToken, ClientInfo{}); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("Refresh(revoked) error = %v, want %v", err, ErrRefreshTokenInvalid)
+	}
+
+	//保留的登入不受影響
+	if _, err := VerifyToken(ctx, current.AccessToken, db); err != nil {
+		t.Errorf("VerifyToken(current): %v", err)
+	}
+
+	//撤銷標記過期後查無登入同樣視為已撤銷
+	server.FastForward(AccessTokenTTL + time.Second)
+	if _, err := server.Get(sessionKey(claims.SessionID)); err == nil {
+		t.Fatal("revoked marker should expire")
+	}
+	if err := checkSession(ctx, db, claims); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("checkSession after marker expired error = %v, want %v", err, ErrTokenRevoked)
+	}
+}
+
+// Redis無法連線時改以資料庫確認登入，撤銷立即生效
+func TestRevokeSessionWithoutRedis(t *testing.T) {
+	db, server := setupTestSession(t)
+	user := createTestUser(t, db)
+	ctx := context.Background()
+
+	pair, err := Login(ctx, db, user.ID, user.Role, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	claims, err := VerifyToken(ctx, pair.AccessToken, db)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+
+	server.Close()
+	if _, err := VerifyToken(ctx, pair.AccessToken, db); err != nil {
+		t.Fatalf("VerifyToken with Redis down: %v", err)
+	}
+
+	found, err := RevokeSession(ctx, db, user.ID, claims.SessionID)
+	if err != nil || !found {
+		t.Fatalf("RevokeSession = %v, %v, want found", found, err)
+	}
+	if _, err := VerifyToken(ctx, pair.AccessToken, db); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("VerifyToken(revoked) with Redis down error = %v, want %v", err, ErrTokenRevoked)
+	}
+}
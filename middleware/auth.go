@@ -20,7 +20,7 @@ func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		//如Token不合法或錯誤則回傳空Authorization
-		userID, role, err := jwt.VerifyToken(&token, db)
+		claims, err := jwt.VerifyToken(c, token, db)
 		if err != nil {
 			log.Printf("無法驗證Token: %v\n", err)
 			c.Header("Authorization", "")
@@ -30,8 +30,9 @@ func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 
 		c.Header("Authorization", authHeader)
 		c.Set("Token", token)
-		c.Set("UserID", userID)
-		c.Set("Role", role)
+		c.Set("SessionID", claims.SessionID)
+		c.Set("UserID", claims.UserID)
+		c.Set("Role", claims.Role)
 		c.Next()
 		return
 	}
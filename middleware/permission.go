@@ -9,6 +9,7 @@ import (
 const adminRole = "admin"
 
 // 檢查是否有admin權限，沒有則中止請求
+// Token中的角色已在驗證Token時以登入快取確認，變更角色後原本的Access Token即失效，因此不再查詢資料庫
 func CheckAdminPermissionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("Role")
@@ -8,5 +8,7 @@ type CartItem struct {
 	Cart      Cart
 	ProductID uint `gorm:"foreignKey:ProductID"`
 	Product   Product
+	VariantID *uint
+	Variant   *ProductVariant
 	Quantity  uint `gorm:"not null"`
 }
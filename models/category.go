@@ -1,9 +1,38 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"strings"
+	"unicode"
+)
 
+// 商品標籤(分類)，ParentID為nil時為最上層分類
+// Slug為唯一的網址代稱，已刪除的分類仍保留其Slug，同一層的分類依SortOrder及ID排序
+// Slug預設為null，讓舊資料新增欄位時不違反唯一索引
 type Category struct {
 	gorm.Model
-	Name     string
-	Products []Product `gorm:"many2many:category_products;"`
+	Name        string
+	Slug        string `gorm:"size:191;uniqueIndex;default:null"`
+	Description string
+	ParentID    *uint `gorm:"index"`
+	SortOrder   int
+	Products    []Product `gorm:"many2many:category_products;"`
+}
+
+// 將分類名稱轉為網址代稱，保留各語言的文字及數字並轉為小寫，其餘字元以-連接
+func Slugify(name string) string {
+	var builder strings.Builder
+	pendingDash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingDash && builder.Len() > 0 {
+				builder.WriteRune('-')
+			}
+			builder.WriteRune(r)
+			pendingDash = false
+			continue
+		}
+		pendingDash = true
+	}
+	return builder.String()
 }
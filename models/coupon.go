@@ -0,0 +1,40 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// 優惠券類型
+const (
+	CouponTypePercentage   = "percentage"    //依百分比折扣，Value為折扣百分比(1-100)
+	CouponTypeFixedAmount  = "fixed"         //折抵固定金額，Value為折抵金額
+	CouponTypeFreeShipping = "free_shipping" //免運費
+)
+
+// UsageLimit及PerUserLimit為0代表不限次數，MaxDiscount為0代表不限折扣上限
+type Coupon struct {
+	gorm.Model
+	Code         string `gorm:"size:64;uniqueIndex;not null"`
+	Description  string
+	Type         string `gorm:"not null"`
+	Value        uint
+	MaxDiscount  uint
+	MinSpend     uint
+	UsageLimit   uint
+	PerUserLimit uint
+	UsedCount    uint `gorm:"not null"`
+	StartsAt     *time.Time
+	EndsAt       *time.Time
+	Enabled      bool
+}
+
+// 優惠券使用紀錄
+type CouponRedemption struct {
+	gorm.Model
+	CouponID uint `gorm:"index;not null"`
+	Coupon   Coupon
+	UserID   uint `gorm:"index;not null"`
+	OrderID  uint `gorm:"index;not null"`
+	Discount uint
+}
@@ -5,10 +5,19 @@ import (
 	"time"
 )
 
+// 一次登入，Access Token的sid為此登入的ID，JTI為目前有效的Access Token的jti，ExpirationTime為其過期時間
+// 同一次登入輪替產生的Refresh Token都屬於此登入，RefreshExpirationTime為最新Refresh Token的過期時間
+// IP、UserAgent及Device為最後一次登入或換發Token時的用戶端，LastSeenAt為其時間
 type LoginToken struct {
 	gorm.Model
-	Token          string
-	ExpirationTime time.Time
-	UserID         uint
-	Role           string
+	JTI                   string `gorm:"size:36"`
+	ExpirationTime        time.Time
+	RefreshExpirationTime time.Time `gorm:"index"`
+	UserID                uint      `gorm:"index"`
+	Role                  string
+	IP                    string `gorm:"size:45"`
+	UserAgent             string `gorm:"size:512"`
+	Device                string `gorm:"size:64"`
+	LastSeenAt            time.Time
+	RefreshTokens         []RefreshToken
 }
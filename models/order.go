@@ -2,15 +2,85 @@ package models
 
 import "gorm.io/gorm"
 
+// 訂單狀態
+const (
+	OrderStatusPending       = "待處理"
+	OrderStatusPaid          = "已付款"
+	OrderStatusPaymentFailed = "付款失敗"
+	OrderStatusShipped       = "已出貨"
+	OrderStatusDelivered     = "已送達"
+	OrderStatusCancelled     = "已取消"
+	OrderStatusRefunded      = "已退款"
+)
+
+// 各訂單狀態允許變更的下一個狀態，已取消及已退款為最終狀態
+var orderStatusTransitions = map[string][]string{
+	OrderStatusPending:       {OrderStatusPaid, OrderStatusPaymentFailed, OrderStatusCancelled},
+	OrderStatusPaymentFailed: {OrderStatusPaid, OrderStatusPending, OrderStatusCancelled},
+	OrderStatusPaid:          {OrderStatusShipped, OrderStatusRefunded},
+	OrderStatusShipped:       {OrderStatusDelivered},
+	OrderStatusDelivered:     {OrderStatusRefunded},
+	OrderStatusCancelled:     {},
+	OrderStatusRefunded:      {},
+}
+
+// 付款結果只能由金流回呼(OrderActorSystem)設定，確保已付款的訂單都有對應的付款紀錄
+var systemOnlyOrderStatuses = map[string]bool{
+	OrderStatusPaid:          true,
+	OrderStatusPaymentFailed: true,
+}
+
+// 檢查是否為已定義的訂單狀態
+func IsValidOrderStatus(status string) bool {
+	_, ok := orderStatusTransitions[status]
+	return ok
+}
+
+// 檢查訂單狀態是否可以從from變更為to
+func CanTransitionOrderStatus(from, to string) bool {
+	for _, next := range orderStatusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 檢查actor(OrderActorSystem、OrderActorAdmin、OrderActorUser)是否可以將訂單狀態從from變更為to
+func CanActorTransitionOrderStatus(from, to, actor string) bool {
+	if systemOnlyOrderStatuses[to] && actor != OrderActorSystem {
+		return false
+	}
+	return CanTransitionOrderStatus(from, to)
+}
+
+// 取得actor可以將訂單狀態變更為的下一個狀態
+func NextOrderStatuses(status string, actor string) []string {
+	next := []string{}
+	for _, to := range orderStatusTransitions[status] {
+		if CanActorTransitionOrderStatus(status, to, actor) {
+			next = append(next, to)
+		}
+	}
+	return next
+}
+
 type Order struct {
 	gorm.Model
-	UserID         uint `gorm:"foreignKey:UserID"`
-	User           User
-	OrderItems     []OrderItem
-	Total          uint   `gorm:"not null"`
-	ShippingMethod string `gorm:"not null"`
-	Name           string `gorm:"not null"`
-	Address        string `gorm:"not null"`
-	Phone          string `gorm:"not null"`
-	Status         string `gorm:"not null"`
+	UserID           uint `gorm:"foreignKey:UserID"`
+	User             User
+	OrderItems       []OrderItem
+	Payments         []Payment
+	StatusHistory    []OrderStatusHistory
+	Subtotal         uint
+	Discount         uint
+	CouponCode       string
+	ShippingFee      uint
+	Total            uint `gorm:"not null"`
+	ShippingMethodID uint
+	ShippingMethod   string `gorm:"not null"`
+	Name             string `gorm:"not null"`
+	Address          string `gorm:"not null"`
+	Phone            string `gorm:"not null"`
+	Status           string `gorm:"not null;index"`
 }
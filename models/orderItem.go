@@ -2,11 +2,33 @@ package models
 
 import "gorm.io/gorm"
 
+// ProductName、SKU、OptionLabel、UnitPrice、ImageURL為結帳當下的商品快照，不隨商品修改或刪除變動
 type OrderItem struct {
 	gorm.Model
-	OrderID   uint `gorm:"foreignKey:OrderID"`
-	Order     Order
-	ProductID uint `gorm:"foreignKey:ProductID"`
-	Product   Product
-	Quantity  uint `gorm:"not null"`
+	OrderID     uint `gorm:"foreignKey:OrderID"`
+	Order       Order
+	ProductID   uint `gorm:"foreignKey:ProductID"`
+	Product     Product
+	VariantID   *uint
+	Variant     *ProductVariant
+	ProductName string
+	SKU         string
+	OptionLabel string
+	UnitPrice   uint
+	ImageURL    string
+	Quantity    uint `gorm:"not null"`
+}
+
+// 取得訂單商品快照，舊訂單沒有快照時改用商品資料
+func (item OrderItem) Snapshot() (name string, unitPrice uint, imageURL string) {
+	if item.ProductName == "" {
+		return item.Product.Name, item.Product.Price, item.Product.ImageURL
+	}
+	return item.ProductName, item.UnitPrice, item.ImageURL
+}
+
+// 訂單商品小計
+func (item OrderItem) LineTotal() uint {
+	_, unitPrice, _ := item.Snapshot()
+	return unitPrice * item.Quantity
 }
@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+// 變更訂單狀態的角色，system為金流回呼等系統自動變更
+const (
+	OrderActorSystem = "system"
+	OrderActorUser   = "user"
+	OrderActorAdmin  = "admin"
+)
+
+type OrderStatusHistory struct {
+	gorm.Model
+	OrderID       uint   `gorm:"index;not null"`
+	FromStatus    string `gorm:"not null"`
+	ToStatus      string `gorm:"not null"`
+	ChangedBy     uint
+	ChangedByRole string `gorm:"not null"`
+	Note          string
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanTransitionOrderStatus(t *testing.T) {
+	statuses := []string{
+		OrderStatusPending,
+		OrderStatusPaid,
+		OrderStatusPaymentFailed,
+		OrderStatusShipped,
+		OrderStatusDelivered,
+		OrderStatusCancelled,
+		OrderStatusRefunded,
+	}
+
+	//表中沒有的變更皆不允許
+	allowed := map[[2]string]bool{
+		{OrderStatusPending, OrderStatusPaid}:          true,
+		{OrderStatusPending, OrderStatusPaymentFailed}: true,
+		{OrderStatusPending, OrderStatusCancelled}:     true,
+
+		{OrderStatusPaymentFailed, OrderStatusPaid}:      true,
+		{OrderStatusPaymentFailed, OrderStatusPending}:   true,
+		{OrderStatusPaymentFailed, OrderStatusCancelled}: true,
+
+		{OrderStatusPaid, OrderStatusShipped}:  true,
+		{OrderStatusPaid, OrderStatusRefunded}: true,
+
+		{OrderStatusShipped, OrderStatusDelivered}: true,
+
+		{OrderStatusDelivered, OrderStatusRefunded}: true,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := CanTransitionOrderStatus(from, to); got != want {
+				t.Errorf("CanTransitionOrderStatus(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+
+	//未定義的狀態不可變更
+	for _, status := range statuses {
+		if CanTransitionOrderStatus("unknown", status) || CanTransitionOrderStatus(status, "unknown") {
+			t.Errorf("transition between unknown status and %s should not be allowed", status)
+		}
+	}
+}
+
+func TestCanActorTransitionOrderStatus(t *testing.T) {
+	tests := []struct {
+		from  string
+		to    string
+		actor string
+		want  bool
+	}{
+		//付款結果只能由金流回呼設定
+		{OrderStatusPending, OrderStatusPaid, OrderActorSystem, true},
+		{OrderStatusPending, OrderStatusPaid, OrderActorAdmin, false},
+		{OrderStatusPending, OrderStatusPaid, OrderActorUser, false},
+		{OrderStatusPaymentFailed, OrderStatusPaid, OrderActorAdmin, false},
+		{OrderStatusPending, OrderStatusPaymentFailed, OrderActorSystem, true},
+		{OrderStatusPending, OrderStatusPaymentFailed, OrderActorAdmin, false},
+
+		{OrderStatusPending, OrderStatusCancelled, OrderActorUser, true},
+		{OrderStatusPending, OrderStatusCancelled, OrderActorAdmin, true},
+		{OrderStatusPaymentFailed, OrderStatusPending, OrderActorAdmin, true},
+		{OrderStatusPaid, OrderStatusShipped, OrderActorAdmin, true},
+		{OrderStatusPaid, OrderStatusRefunded, OrderActorAdmin, true},
+
+		//仍須符合訂單狀態流程
+		{OrderStatusCancelled, OrderStatusPaid, OrderActorSystem, false},
+		{OrderStatusShipped, OrderStatusCancelled, OrderActorAdmin, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanActorTransitionOrderStatus(tt.from, tt.to, tt.actor); got != tt.want {
+			t.Errorf("CanActorTransitionOrderStatus(%s, %s, %s) = %v, want %v", tt.from, tt.to, tt.actor, got, tt.want)
+		}
+	}
+}
+
+func TestNextOrderStatuses(t *testing.T) {
+	tests := []struct {
+		status string
+		actor  string
+		want   []string
+	}{
+		{OrderStatusPending, OrderActorAdmin, []string{OrderStatusCancelled}},
+		{OrderStatusPending, OrderActorSystem, []string{OrderStatusPaid, OrderStatusPaymentFailed, OrderStatusCancelled}},
+		{OrderStatusPaymentFailed, OrderActorAdmin, []string{OrderStatusPending, OrderStatusCancelled}},
+		{OrderStatusPaid, OrderActorAdmin, []string{OrderStatusShipped, OrderStatusRefunded}},
+		{OrderStatusRefunded, OrderActorAdmin, []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := NextOrderStatuses(tt.status, tt.actor); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NextOrderStatuses(%s, %s) = %v, want %v", tt.status, tt.actor, got, tt.want)
+		}
+	}
+}
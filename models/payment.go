@@ -0,0 +1,26 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// 付款狀態
+const (
+	PaymentStatusPending = "待付款"
+	PaymentStatusPaid    = "已付款"
+	PaymentStatusFailed  = "付款失敗"
+)
+
+type Payment struct {
+	gorm.Model
+	OrderID       uint `gorm:"index;not null"`
+	Order         Order
+	Provider      string `gorm:"not null"`
+	TradeNo       string `gorm:"size:64;uniqueIndex;not null"`
+	TransactionID string `gorm:"size:128;index"`
+	Amount        uint   `gorm:"not null"`
+	Status        string `gorm:"not null"`
+	FailReason    string
+	PaidAt        *time.Time
+}
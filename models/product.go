@@ -2,12 +2,18 @@ package models
 
 import "gorm.io/gorm"
 
+// 有規格的商品，Stock為所有規格庫存的總和
+// ImageURL為主圖網址，與Images中IsPrimary的圖片同步
+// SKU為外部系統使用的商品編號，批次匯入時以此更新商品，未設定時為nil讓資料庫存為null而不違反唯一索引
 type Product struct {
 	gorm.Model
-	Name        string `gorm:"not null"`
-	Price       uint   `gorm:"not null"`
-	Stock       uint   `gorm:"not null"`
+	SKU         *string `gorm:"size:64;uniqueIndex"`
+	Name        string  `gorm:"not null"`
+	Price       uint    `gorm:"not null"`
+	Stock       uint    `gorm:"not null"`
 	Description string
 	ImageURL    string
 	Categories  []Category `gorm:"many2many:category_products;"`
+	Variants    []ProductVariant
+	Images      []ProductImage
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// 商品圖片，依SortOrder由小到大排序，每個商品最多一張主圖(IsPrimary)
+// 主圖的URL會同步至Product.ImageURL，移除圖片時直接刪除資料以便清除未使用的檔案
+type ProductImage struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ProductID uint   `gorm:"index;not null"`
+	URL       string `gorm:"size:255;index;not null"`
+	AltText   string
+	SortOrder int  `gorm:"not null"`
+	IsPrimary bool `gorm:"not null"`
+}
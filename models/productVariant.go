@@ -0,0 +1,41 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+// 商品規格(SKU)，Options為規格屬性(如{"尺寸": "M", "顏色": "紅"})
+// Price為nil時使用商品價格
+type ProductVariant struct {
+	gorm.Model
+	ProductID uint              `gorm:"index;not null"`
+	SKU       string            `gorm:"size:64;uniqueIndex;not null"`
+	Options   map[string]string `gorm:"type:text;serializer:json"`
+	Price     *uint
+	Stock     uint `gorm:"not null"`
+}
+
+// 取得規格售價
+func (v ProductVariant) EffectivePrice(product Product) uint {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.Price
+}
+
+// 將規格屬性依名稱排序後組成文字，例如「尺寸:M / 顏色:紅」
+func (v ProductVariant) OptionsLabel() string {
+	names := make([]string, 0, len(v.Options))
+	for name := range v.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ":" + v.Options[name]
+	}
+	return strings.Join(parts, " / ")
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Refresh Token只儲存SHA-256雜湊，每個只能使用一次，使用後以UsedAt標記並產生新的Refresh Token
+// 已使用的Refresh Token再次出現代表被盜用，整個登入(LoginToken)的Token都會被撤銷
+// 撤銷時直接刪除資料，因此不使用軟刪除
+type RefreshToken struct {
+	ID           uint `gorm:"primarykey"`
+	CreatedAt    time.Time
+	LoginTokenID uint      `gorm:"index;not null"`
+	UserID       uint      `gorm:"index;not null"`
+	TokenHash    string    `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	UsedAt       *time.Time
+}
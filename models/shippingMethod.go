@@ -0,0 +1,15 @@
+package models
+
+import "gorm.io/gorm"
+
+// FreeShippingThreshold為0代表沒有免運門檻
+type ShippingMethod struct {
+	gorm.Model
+	Code                  string `gorm:"size:64;uniqueIndex;not null"`
+	Name                  string `gorm:"not null"`
+	Description           string
+	Fee                   uint
+	FreeShippingThreshold uint
+	Enabled               bool
+	SortOrder             int
+}
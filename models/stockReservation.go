@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// 結帳時保留的商品庫存，過期後由背景程序刪除，因此不使用軟刪除
+// VariantID為0代表沒有規格的商品
+type StockReservation struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"index;not null"`
+	ProductID uint      `gorm:"index;not null"`
+	VariantID uint      `gorm:"index"`
+	Quantity  uint      `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
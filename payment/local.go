@@ -0,0 +1,46 @@
+package payment
+
+import (
+	"Backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// 本地測試用金流，不經過真實金流即可完成結帳流程
+// 回呼不驗證簽章，僅應在開發或測試環境啟用
+type LocalProvider struct{}
+
+func NewLocalProvider() *LocalProvider {
+	return &LocalProvider{}
+}
+
+func (p *LocalProvider) Name() string {
+	return "local"
+}
+
+func (p *LocalProvider) CreatePayment(payment *models.Payment) (Session, error) {
+	return Session{
+		TransactionID: "LOCAL-" + uuid.New().String(),
+		PaymentURL:    "/api/v1/payments/local/callback",
+	}, nil
+}
+
+// 回呼格式: {"tradeNo": "...", "amount": 100, "success": true, "message": "..."}
+func (p *LocalProvider) ParseCallback(c *gin.Context) (CallbackResult, error) {
+	var callbackReq struct {
+		TradeNo string `json:"tradeNo" binding:"required"`
+		Amount  uint   `json:"amount"`
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	if err := c.ShouldBindJSON(&callbackReq); err != nil {
+		return CallbackResult{}, err
+	}
+
+	return CallbackResult{
+		TradeNo: callbackReq.TradeNo,
+		Amount:  callbackReq.Amount,
+		Success: callbackReq.Success,
+		Message: callbackReq.Message,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package payment
+
+import (
+	"Backend/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"sync"
+)
+
+var ErrProviderNotFound = errors.New("不支援的付款方式")
+
+// 建立付款後供應商回傳的資訊
+type Session struct {
+	TransactionID string
+	PaymentURL    string
+}
+
+// 解析供應商回呼後的付款結果，Amount為供應商實際收取的金額
+type CallbackResult struct {
+	TradeNo       string
+	TransactionID string
+	Amount        uint
+	Success       bool
+	Message       string
+}
+
+// 金流供應商介面，新增金流時實作此介面並呼叫Register註冊
+type Provider interface {
+	// 供應商名稱，用於路由及紀錄於Payment.Provider
+	Name() string
+	// 向供應商建立付款，payment.TradeNo為本站的交易編號
+	CreatePayment(payment *models.Payment) (Session, error)
+	// 解析並驗證供應商的回呼請求
+	ParseCallback(c *gin.Context) (CallbackResult, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// 註冊金流供應商
+func Register(provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[provider.Name()] = provider
+}
+
+// 依名稱取得金流供應商
+func GetProvider(name string) (Provider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	provider, ok := providers[name]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+	return provider, nil
+}
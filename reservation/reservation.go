@@ -0,0 +1,241 @@
+package reservation
+
+import (
+	"Backend/cache"
+	"Backend/models"
+	"context"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ttlMu sync.RWMutex
+	ttl   = 15 * time.Minute
+)
+
+// 保留的商品及數量，VariantID為0代表沒有規格的商品
+type Item struct {
+	ProductID uint
+	VariantID uint
+	Quantity  uint
+}
+
+// 設定保留庫存的有效時間
+func SetTTL(d time.Duration) {
+	ttlMu.Lock()
+	defer ttlMu.Unlock()
+	ttl = d
+}
+
+// 取得保留庫存的有效時間
+func TTL() time.Duration {
+	ttlMu.RLock()
+	defer ttlMu.RUnlock()
+	return ttl
+}
+
+// 查詢商品目前被其他使用者保留的數量(包含所有規格)，excludeUserID為0代表計算所有使用者
+func ReservedQuantities(db *gorm.DB, productIDs []uint, excludeUserID uint) (map[uint]uint, error) {
+	reserved := make(map[uint]uint, len(productIDs))
+	if len(productIDs) == 0 {
+		return reserved, nil
+	}
+
+	var rows []struct {
+		ProductID uint
+		Quantity  uint
+	}
+	err := db.
+		Model(&models.StockReservation{}).
+		Select("product_id, SUM(quantity) AS quantity").
+		Where("product_id IN ? AND user_id <> ? AND expires_at > ?", productIDs, excludeUserID, time.Now()).
+		Group("product_id").
+		Scan(&rows).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		reserved[row.ProductID] = row.Quantity
+	}
+	return reserved, nil
+}
+
+// 查詢商品規格目前被其他使用者保留的數量，excludeUserID為0代表計算所有使用者
+func ReservedVariantQuantities(db *gorm.DB, variantIDs []uint, excludeUserID uint) (map[uint]uint, error) {
+	reserved := make(map[uint]uint, len(variantIDs))
+	if len(variantIDs) == 0 {
+		return reserved, nil
+	}
+
+	var rows []struct {
+		VariantID uint
+		Quantity  uint
+	}
+	err := db.
+		Model(&models.StockReservation{}).
+		Select("variant_id, SUM(quantity) AS quantity").
+		Where("variant_id IN ? AND user_id <> ? AND expires_at > ?", variantIDs, excludeUserID, time.Now()).
+		Group("variant_id").
+		Scan(&rows).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		reserved[row.VariantID] = row.Quantity
+	}
+	return reserved, nil
+}
+
+// 以新的保留取代使用者原本的所有保留，tx應在事務中且商品已上鎖
+// 回傳保留有變更的商品ID(包含原本及新的保留)，呼叫者應在提交後更新這些商品的快取
+func Replace(tx *gorm.DB, userID uint, items []Item) (time.Time, []uint, error) {
+	expiresAt := time.Now().Add(TTL())
+
+	productIDs, err := deleteReservations(tx, "user_id = ?", userID)
+	if err != nil {
+		return expiresAt, nil, err
+	}
+
+	if len(items) > 0 {
+		reservations := make([]models.StockReservation, len(items))
+		for i, item := range items {
+			reservations[i] = models.StockReservation{
+				UserID:    userID,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Quantity:  item.Quantity,
+				ExpiresAt: expiresAt,
+			}
+			productIDs = append(productIDs, item.ProductID)
+		}
+		if err := tx.Create(&reservations).Error; err != nil {
+			return expiresAt, nil, err
+		}
+	}
+
+	return expiresAt, productIDs, cache.Touch(tx, productIDs...)
+}
+
+// 釋放使用者保留的所有庫存，回傳保留有變更的商品ID
+func Release(db *gorm.DB, userID uint) ([]uint, error) {
+	var productIDs []uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		productIDs, err = deleteReservations(tx, "user_id = ?", userID)
+		if err != nil {
+			return err
+		}
+		return cache.Touch(tx, productIDs...)
+	})
+	return productIDs, err
+}
+
+// 釋放使用者對指定商品的保留，只比對ProductID及VariantID，沒有規格的商品VariantID為0
+func ReleaseItems(tx *gorm.DB, userID uint, items []Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	conditions := make([]string, len(items))
+	args := make([]interface{}, 0, len(items)*2)
+	for i, item := range items {
+		conditions[i] = "(product_id = ? AND variant_id = ?)"
+		args = append(args, item.ProductID, item.VariantID)
+	}
+
+	query := "user_id = ? AND (" + strings.Join(conditions, " OR ") + ")"
+	productIDs, err := deleteReservations(tx, query, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return err
+	}
+	return cache.Touch(tx, productIDs...)
+}
+
+// 刪除所有過期的保留，回傳保留有變更的商品ID
+func Sweep(db *gorm.DB) ([]uint, error) {
+	var productIDs []uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		productIDs, err = deleteReservations(tx, "expires_at <= ?", time.Now())
+		if err != nil {
+			return err
+		}
+		return cache.Touch(tx, productIDs...)
+	})
+	return productIDs, err
+}
+
+// 刪除符合條件的保留並回傳其商品ID
+// 保留數量會影響商品的可購買庫存，呼叫者須以cache.Touch更新這些商品的updated_at，讓商品的Last-Modified及快取版本遞增
+func deleteReservations(tx *gorm.DB, query string, args ...interface{}) ([]uint, error) {
+	var productIDs []uint
+	err := tx.
+		Model(&models.StockReservation{}).
+		Where(query, args...).
+		Distinct().
+		Pluck("product_id", &productIDs).
+		Error
+	if err != nil || len(productIDs) == 0 {
+		return nil, err
+	}
+
+	return productIDs, tx.Where(query, args...).Delete(&models.StockReservation{}).Error
+}
+
+// 查詢商品已過期但尚未被清除的保留中最晚的過期時間
+// 保留過期時可購買庫存就會增加，但要等到清除時才會更新商品的updated_at，因此Last-Modified須一併參考
+func LatestExpiry(db *gorm.DB, productIDs []uint) (map[uint]time.Time, error) {
+	latest := make(map[uint]time.Time, len(productIDs))
+	if len(productIDs) == 0 {
+		return latest, nil
+	}
+
+	var reservations []models.StockReservation
+	err := db.
+		Select("product_id", "expires_at").
+		Where("product_id IN ? AND expires_at <= ?", productIDs, time.Now()).
+		Find(&reservations).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range reservations {
+		if r.ExpiresAt.After(latest[r.ProductID]) {
+			latest[r.ProductID] = r.ExpiresAt
+		}
+	}
+	return latest, nil
+}
+
+// 定期刪除過期的保留並更新受影響商品的快取，應以goroutine執行
+func RunSweeper(db *gorm.DB, rdb *redis.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		productIDs, err := Sweep(db)
+		if err != nil {
+			log.Printf("清除過期保留庫存失敗: %v\n", err)
+			continue
+		}
+		if len(productIDs) == 0 {
+			continue
+		}
+		log.Printf("已清除%d個商品的過期保留庫存\n", len(productIDs))
+
+		//資料庫已是正確資料，快取更新失敗由背景協調程序修復
+		err = cache.NewProductCache(db, rdb).Refresh(context.Background(), productIDs...)
+		if err != nil && err != cache.ErrRedisUnavailable {
+			log.Printf("更新商品快取失敗，將由背景協調程序修復: %v\n", err)
+		}
+	}
+}
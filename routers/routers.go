@@ -28,6 +28,11 @@ func SetupRouters(db *gorm.DB, rdb *redis.Client) *gin.Engine {
 	//設定商品圖片靜態資源路徑
 	router.Static("/uploads", "./uploads")
 
+	//公開驗證JWT Token用的公鑰
+	router.GET("/.well-known/jwks.json", func(context *gin.Context) {
+		handlers.GetJWKSHandler(context)
+	})
+
 	router.OPTIONS("/*path", func(c *gin.Context) {
 		c.Status(http.StatusOK)
 	})
@@ -39,13 +44,29 @@ func SetupRouters(db *gorm.DB, rdb *redis.Client) *gin.Engine {
 		router.GET("/api/v1/products", func(context *gin.Context) {
 			handlers.GetProductListHandler(context, db, rdb)
 		})
-		//搜尋完整包含標籤的所有商品
+		//以關鍵字搜尋商品
+		router.GET("/api/v1/products/search", func(context *gin.Context) {
+			handlers.SearchProductsHandler(context, db, rdb)
+		})
+		//搜尋完整包含標籤的所有商品(categories=1,2)
 		router.GET("/api/v1/products/categories", func(context *gin.Context) {
 			handlers.GetProductsFromCategoriesHandler(context, db, rdb)
 		})
 		//查詢商品詳細資料
 		router.GET("/api/v1/products/:productID", func(context *gin.Context) {
-			handlers.GetProductDataHandler(context, db)
+			handlers.GetProductDataHandler(context, db, rdb)
+		})
+		//查詢所有分類及各分類有庫存的商品數量
+		router.GET("/api/v1/categories", func(context *gin.Context) {
+			handlers.GetPublicCategoryListHandler(context, db, rdb)
+		})
+		//查詢巢狀的分類樹
+		router.GET("/api/v1/categories/tree", func(context *gin.Context) {
+			handlers.GetCategoryTreeHandler(context, db)
+		})
+		//依代稱查詢分類頁面(含子分類的商品)
+		router.GET("/api/v1/categories/:slug", func(context *gin.Context) {
+			handlers.GetCategoryPageHandler(context, db, rdb)
 		})
 		//註冊帳號
 		router.POST("/api/v1/register", func(context *gin.Context) {
@@ -55,6 +76,10 @@ func SetupRouters(db *gorm.DB, rdb *redis.Client) *gin.Engine {
 		router.POST("/api/v1/login", func(context *gin.Context) {
 			handlers.LoginHandler(context, db)
 		})
+		//以Refresh Token換發新的Token
+		router.POST("/api/v1/token/refresh", func(context *gin.Context) {
+			handlers.RefreshTokenHandler(context, db)
+		})
 		//新增商品至購物車
 		router.POST("/api/v1/carts/add", func(context *gin.Context) {
 			handlers.AddToCartHandler(context, db)
@@ -75,6 +100,18 @@ func SetupRouters(db *gorm.DB, rdb *redis.Client) *gin.Engine {
 		router.DELETE("/api/v1/carts", func(context *gin.Context) {
 			handlers.ClearCartHandler(context, db)
 		})
+		//查詢可使用的運送方式
+		router.GET("/api/v1/shipping-methods", func(context *gin.Context) {
+			handlers.GetShippingMethodListHandler(context, db)
+		})
+		//試算購物車套用優惠券後的金額
+		router.POST("/api/v1/carts/coupon", func(context *gin.Context) {
+			handlers.PreviewCouponHandler(context, db)
+		})
+		//接收金流付款結果回呼
+		router.POST("/api/v1/payments/:provider/callback", func(context *gin.Context) {
+			handlers.PaymentCallbackHandler(context, db)
+		})
 
 		////需要登入，使用中間件檢查是否登入
 		loginRequired := router.Group("/api/v1/user")
@@ -92,6 +129,14 @@ func SetupRouters(db *gorm.DB, rdb *redis.Client) *gin.Engine {
 			loginRequired.POST("/carts/merge", func(context *gin.Context) {
 				handlers.MergeCartHandler(context, db)
 			})
+			//開始結帳，保留購物車商品庫存
+			loginRequired.POST("/checkout/reservation", func(context *gin.Context) {
+				handlers.ReserveCheckoutHandler(context, db, rdb)
+			})
+			//取消結帳，釋放保留的庫存
+			loginRequired.DELETE("/checkout/reservation", func(context *gin.Context) {
+				handlers.ReleaseCheckoutHandler(context, db, rdb)
+			})
 			//送出訂單並清除購物車內對應商品
 			loginRequired.POST("/orders", func(context *gin.Context) {
 				handlers.SendOrderHandler(context, db, rdb)
@@ -104,10 +149,30 @@ func SetupRouters(db *gorm.DB, rdb *redis.Client) *gin.Engine {
 			loginRequired.GET("/orders/:orderID", func(context *gin.Context) {
 				handlers.GetOrderDataHandler(context, db)
 			})
+			//取消尚未付款的訂單並加回庫存
+			loginRequired.POST("/orders/:orderID/cancel", func(context *gin.Context) {
+				handlers.CancelOrderHandler(context, db, rdb)
+			})
+			//開始付款訂單
+			loginRequired.POST("/orders/:orderID/payments", func(context *gin.Context) {
+				handlers.StartPaymentHandler(context, db)
+			})
 			//登出
 			loginRequired.POST("/logout", func(context *gin.Context) {
 				handlers.LogOutHandler(context, db)
 			})
+			//查詢自己所有有效的登入
+			loginRequired.GET("/sessions", func(context *gin.Context) {
+				handlers.GetSessionListHandler(context, db)
+			})
+			//撤銷自己的所有登入(exceptCurrent=true保留目前的登入)
+			loginRequired.DELETE("/sessions", func(context *gin.Context) {
+				handlers.RevokeAllSessionsHandler(context, db)
+			})
+			//撤銷自己的一個登入
+			loginRequired.DELETE("/sessions/:sessionID", func(context *gin.Context) {
+				handlers.RevokeSessionHandler(context, db)
+			})
 		}
 
 		////需要admin身分，使用中間件檢查是否登入及admin權限
@@ -118,6 +183,22 @@ func SetupRouters(db *gorm.DB, rdb *redis.Client) *gin.Engine {
 			adminRequired.GET("/users", func(context *gin.Context) {
 				handlers.GetUserListHandler(context, db)
 			})
+			//查詢使用者所有有效的登入
+			adminRequired.GET("/users/:userID/sessions", func(context *gin.Context) {
+				handlers.GetUserSessionListHandler(context, db)
+			})
+			//變更使用者的角色
+			adminRequired.PUT("/users/:userID/role", func(context *gin.Context) {
+				handlers.UpdateUserRoleHandler(context, db)
+			})
+			//撤銷使用者的所有登入
+			adminRequired.DELETE("/users/:userID/sessions", func(context *gin.Context) {
+				handlers.RevokeAllUserSessionsHandler(context, db)
+			})
+			//撤銷使用者的一個登入
+			adminRequired.DELETE("/users/:userID/sessions/:sessionID", func(context *gin.Context) {
+				handlers.RevokeUserSessionHandler(context, db)
+			})
 			//上傳商品圖片
 			adminRequired.POST("/image", func(context *gin.Context) {
 				handlers.UploadImageHandler(context)
@@ -130,6 +211,14 @@ func SetupRouters(db *gorm.DB, rdb *redis.Client) *gin.Engine {
 			adminRequired.POST("/products", func(context *gin.Context) {
 				handlers.CreateProductHandler(context, db, rdb)
 			})
+			//以CSV或JSON檔案批次新增或更新商品(dryRun=true只檢查)
+			adminRequired.POST("/products/import", func(context *gin.Context) {
+				handlers.ImportProductsHandler(context, db, rdb)
+			})
+			//以CSV或JSON匯出所有商品
+			adminRequired.GET("/products/export", func(context *gin.Context) {
+				handlers.ExportProductsHandler(context, db)
+			})
 			//修改商品
 			adminRequired.PATCH("/products/:productID", func(context *gin.Context) {
 				handlers.UpdateProductHandler(context, db, rdb)
@@ -138,13 +227,101 @@ func SetupRouters(db *gorm.DB, rdb *redis.Client) *gin.Engine {
 			adminRequired.DELETE("/products/:productID", func(context *gin.Context) {
 				handlers.DeleteProductHandler(context, db, rdb)
 			})
+			//將已上傳的圖片加入商品
+			adminRequired.POST("/products/:productID/images", func(context *gin.Context) {
+				handlers.AttachProductImageHandler(context, db, rdb)
+			})
+			//重新排序商品圖片
+			adminRequired.PUT("/products/:productID/images/order", func(context *gin.Context) {
+				handlers.ReorderProductImagesHandler(context, db, rdb)
+			})
+			//修改商品圖片替代文字或設為主圖
+			adminRequired.PATCH("/products/:productID/images/:imageID", func(context *gin.Context) {
+				handlers.UpdateProductImageHandler(context, db, rdb)
+			})
+			//從商品移除圖片
+			adminRequired.DELETE("/products/:productID/images/:imageID", func(context *gin.Context) {
+				handlers.DetachProductImageHandler(context, db, rdb)
+			})
+			//清除沒有被使用的圖片檔案
+			adminRequired.POST("/images/cleanup", func(context *gin.Context) {
+				handlers.CleanupOrphanedImagesHandler(context, db)
+			})
+			//重建商品搜尋索引
+			adminRequired.POST("/search/reindex", func(context *gin.Context) {
+				handlers.ReindexSearchHandler(context, db, rdb)
+			})
 			//查詢商品標籤列表
 			adminRequired.GET("/categories", func(context *gin.Context) {
 				handlers.GetCategoryListHandler(context, db)
 			})
+			//新增商品標籤
+			adminRequired.POST("/categories", func(context *gin.Context) {
+				handlers.CreateCategoryHandler(context, db)
+			})
+			//修改商品標籤名稱、代稱、說明及排序
+			adminRequired.PATCH("/categories/:categoryID", func(context *gin.Context) {
+				handlers.UpdateCategoryHandler(context, db, rdb)
+			})
+			//移動商品標籤至其他上層標籤
+			adminRequired.PUT("/categories/:categoryID/parent", func(context *gin.Context) {
+				handlers.MoveCategoryHandler(context, db, rdb)
+			})
+			//將商品標籤合併至其他標籤
+			adminRequired.POST("/categories/:categoryID/merge", func(context *gin.Context) {
+				handlers.MergeCategoryHandler(context, db, rdb)
+			})
 			//刪除商品標籤
 			adminRequired.DELETE("/categories/:categoryID", func(context *gin.Context) {
-				handlers.DeleteCategoryHandler(context, db)
+				handlers.DeleteCategoryHandler(context, db, rdb)
+			})
+			//查詢訂單列表(可依狀態、使用者及日期篩選)
+			adminRequired.GET("/orders", func(context *gin.Context) {
+				handlers.GetAdminOrderListHandler(context, db)
+			})
+			//查詢訂單詳細資訊及狀態紀錄
+			adminRequired.GET("/orders/:orderID", func(context *gin.Context) {
+				handlers.GetAdminOrderDataHandler(context, db)
+			})
+			//查詢優惠券列表
+			adminRequired.GET("/coupons", func(context *gin.Context) {
+				handlers.GetCouponListHandler(context, db)
+			})
+			//查詢優惠券
+			adminRequired.GET("/coupons/:couponID", func(context *gin.Context) {
+				handlers.GetCouponHandler(context, db)
+			})
+			//新增優惠券
+			adminRequired.POST("/coupons", func(context *gin.Context) {
+				handlers.CreateCouponHandler(context, db)
+			})
+			//修改優惠券
+			adminRequired.PATCH("/coupons/:couponID", func(context *gin.Context) {
+				handlers.UpdateCouponHandler(context, db)
+			})
+			//刪除優惠券
+			adminRequired.DELETE("/coupons/:couponID", func(context *gin.Context) {
+				handlers.DeleteCouponHandler(context, db)
+			})
+			//查詢運送方式列表(包含停用)
+			adminRequired.GET("/shipping-methods", func(context *gin.Context) {
+				handlers.GetAdminShippingMethodListHandler(context, db)
+			})
+			//新增運送方式
+			adminRequired.POST("/shipping-methods", func(context *gin.Context) {
+				handlers.CreateShippingMethodHandler(context, db)
+			})
+			//修改運送方式
+			adminRequired.PATCH("/shipping-methods/:shippingMethodID", func(context *gin.Context) {
+				handlers.UpdateShippingMethodHandler(context, db)
+			})
+			//刪除運送方式
+			adminRequired.DELETE("/shipping-methods/:shippingMethodID", func(context *gin.Context) {
+				handlers.DeleteShippingMethodHandler(context, db)
+			})
+			//變更訂單狀態
+			adminRequired.PATCH("/orders/:orderID/status", func(context *gin.Context) {
+				handlers.UpdateOrderStatusHandler(context, db, rdb)
 			})
 		}
 	}
@@ -0,0 +1,127 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// 標示符合查詢詞的文字的標籤
+const (
+	highlightOpen  = "<em>"
+	highlightClose = "</em>"
+)
+
+// 找出文字中符合查詢詞的位置
+func matchPositions(text []rune, terms []string) []bool {
+	lower := make([]rune, len(text))
+	for i, r := range text {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	matched := make([]bool, len(text))
+	for _, term := range termRunes(terms) {
+		if len(term) == 0 {
+			continue
+		}
+		for i := 0; i+len(term) <= len(lower); i++ {
+			if !equalRunes(lower[i:i+len(term)], term) {
+				continue
+			}
+			//英數字詞須完整符合，避免「pen」標示到「open」
+			if isWordRune(term[0]) && !isWordBoundary(lower, i, i+len(term)) {
+				continue
+			}
+			for j := i; j < i+len(term); j++ {
+				matched[j] = true
+			}
+		}
+	}
+	return matched
+}
+
+func equalRunes(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordBoundary(text []rune, start, end int) bool {
+	if start > 0 && isWordRune(text[start-1]) {
+		return false
+	}
+	if end < len(text) && isWordRune(text[end]) {
+		return false
+	}
+	return true
+}
+
+// 將文字中符合的部分以<em>標示，其餘文字會經過HTML跳脫
+func renderHighlight(text []rune, matched []bool) string {
+	var builder strings.Builder
+	inMatch := false
+	start := 0
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) && matched[i] == inMatch {
+			continue
+		}
+		segment := html.EscapeString(string(text[start:i]))
+		if inMatch {
+			builder.WriteString(highlightOpen + segment + highlightClose)
+		} else {
+			builder.WriteString(segment)
+		}
+		if i < len(text) {
+			inMatch = matched[i]
+			start = i
+		}
+	}
+	return builder.String()
+}
+
+// 標示文字中所有符合查詢的部分
+func Highlight(text string, query string) string {
+	runes := []rune(text)
+	return renderHighlight(runes, matchPositions(runes, QueryTerms(query)))
+}
+
+// 擷取文字中第一個符合查詢的片段並標示，最多maxRunes個字
+// 沒有符合的部分時回傳開頭的片段
+func Snippet(text string, query string, maxRunes int) string {
+	runes := []rune(text)
+	matched := matchPositions(runes, QueryTerms(query))
+	if len(runes) <= maxRunes {
+		return renderHighlight(runes, matched)
+	}
+
+	first := 0
+	for i, ok := range matched {
+		if ok {
+			first = i
+			break
+		}
+	}
+
+	//讓符合的部分位於片段前段，保留前面少許文字
+	start := first - maxRunes/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + maxRunes
+	if end > len(runes) {
+		end = len(runes)
+		start = end - maxRunes
+	}
+
+	snippet := renderHighlight(runes[start:end], matched[start:end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
@@ -0,0 +1,116 @@
+package search
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  string
+	}{
+		{
+			name:  "中文bigram",
+			text:  "無線藍芽耳機",
+			query: "藍芽耳機",
+			want:  "無線<em>藍芽耳機</em>",
+		},
+		{
+			name:  "英文不分大小寫且保留原文",
+			text:  "Apple AirPods",
+			query: "airpods",
+			want:  "Apple <em>AirPods</em>",
+		},
+		{
+			name:  "英文詞須完整符合",
+			text:  "open pen",
+			query: "pen",
+			want:  "open <em>pen</em>",
+		},
+		{
+			name:  "中英混合查詢",
+			text:  "Sony無線耳機",
+			query: "sony 耳機",
+			want:  "<em>Sony</em>無線<em>耳機</em>",
+		},
+		{
+			name:  "非符合的文字經過HTML跳脫",
+			text:  `<script>alert("x")</script> 耳機`,
+			query: "耳機",
+			want:  "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; <em>耳機</em>",
+		},
+		{
+			name:  "符合的文字經過HTML跳脫",
+			text:  "a&b <b>",
+			query: "b",
+			want:  "a&amp;<em>b</em> &lt;<em>b</em>&gt;",
+		},
+		{
+			name:  "查詢中的標籤不會被輸出",
+			text:  "耳機",
+			query: "<em>",
+			want:  "耳機",
+		},
+		{
+			name:  "沒有符合",
+			text:  "耳機 & 喇叭",
+			query: "滑鼠",
+			want:  "耳機 &amp; 喇叭",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Highlight(tt.text, tt.query); got != tt.want {
+				t.Errorf("Highlight(%q, %q) = %q, want %q", tt.text, tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		query    string
+		maxRunes int
+		want     string
+	}{
+		{
+			name:     "短文字不截斷",
+			text:     "藍芽耳機",
+			query:    "耳機",
+			maxRunes: 10,
+			want:     "藍芽<em>耳機</em>",
+		},
+		{
+			name:     "符合的部分位於片段前段",
+			text:     "一二三四五六七八九十耳機一二三四五六七八九十",
+			query:    "耳機",
+			maxRunes: 8,
+			want:     "…九十<em>耳機</em>一二三四…",
+		},
+		{
+			name:     "沒有符合時回傳開頭",
+			text:     "一二三四五六七八九十",
+			query:    "耳機",
+			maxRunes: 4,
+			want:     "一二三四…",
+		},
+		{
+			name:     "截斷後仍跳脫HTML",
+			text:     "<<<<耳機>>>>",
+			query:    "耳機",
+			maxRunes: 4,
+			want:     "…&lt;<em>耳機</em>&gt;…",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Snippet(tt.text, tt.query, tt.maxRunes); got != tt.want {
+				t.Errorf("Snippet(%q, %q, %d) = %q, want %q", tt.text, tt.query, tt.maxRunes, got, tt.want)
+			}
+		})
+	}
+}
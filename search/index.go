@@ -0,0 +1,213 @@
+package search
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"strconv"
+)
+
+// Redis中的搜尋索引
+// search:term:<詞> 為ZSET，成員為商品ID，分數為此詞在商品中的權重
+// search:product:<商品ID> 為SET，記錄商品被索引的詞，用於更新及刪除索引
+// search:docs 為SET，記錄已索引的商品ID，用於計算詞的稀有程度
+const (
+	termKeyPrefix    = "search:term:"
+	productKeyPrefix = "search:product:"
+	docsKey          = "search:docs"
+	tmpKeyPrefix     = "search:tmp:"
+)
+
+// 商品名稱的詞比描述中的詞重要
+const (
+	nameWeight        = 3.0
+	descriptionWeight = 1.0
+)
+
+// 建立索引所需的商品資料
+type Document struct {
+	ProductID   uint
+	Name        string
+	Description string
+}
+
+// 搜尋結果中的商品及相關度分數
+type Hit struct {
+	ProductID uint
+	Score     float64
+}
+
+// 搜尋結果，Total為符合的商品總數
+type Result struct {
+	Hits  []Hit
+	Total int64
+}
+
+func termKey(term string) string {
+	return termKeyPrefix + term
+}
+
+func productKey(productID uint) string {
+	return productKeyPrefix + strconv.FormatUint(uint64(productID), 10)
+}
+
+// 出現次數以對數遞減，避免重複堆疊關鍵字的商品排在最前面
+func termFrequencyScore(count int) float64 {
+	if count <= 0 {
+		return 0
+	}
+	return 1 + math.Log(float64(count))
+}
+
+// 計算商品每個詞的權重
+func documentScores(doc Document) map[string]float64 {
+	scores := make(map[string]float64)
+	for term, count := range IndexTerms(doc.Name) {
+		scores[term] += nameWeight * termFrequencyScore(count)
+	}
+	for term, count := range IndexTerms(doc.Description) {
+		scores[term] += descriptionWeight * termFrequencyScore(count)
+	}
+	return scores
+}
+
+// 建立或更新商品的搜尋索引
+func IndexProduct(ctx context.Context, rdb *redis.Client, doc Document) error {
+	oldTerms, err := rdb.SMembers(ctx, productKey(doc.ProductID)).Result()
+	if err != nil {
+		return err
+	}
+
+	scores := documentScores(doc)
+	member := strconv.FormatUint(uint64(doc.ProductID), 10)
+
+	pipe := rdb.TxPipeline()
+	for _, term := range oldTerms {
+		if _, ok := scores[term]; !ok {
+			pipe.ZRem(ctx, termKey(term), member)
+		}
+	}
+	pipe.Del(ctx, productKey(doc.ProductID))
+
+	terms := make([]interface{}, 0, len(scores))
+	for term, score := range scores {
+		pipe.ZAdd(ctx, termKey(term), redis.Z{Score: score, Member: member})
+		terms = append(terms, term)
+	}
+	if len(terms) > 0 {
+		pipe.SAdd(ctx, productKey(doc.ProductID), terms...)
+	}
+	pipe.SAdd(ctx, docsKey, member)
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 刪除商品的搜尋索引
+func RemoveProduct(ctx context.Context, rdb *redis.Client, productID uint) error {
+	terms, err := rdb.SMembers(ctx, productKey(productID)).Result()
+	if err != nil {
+		return err
+	}
+
+	member := strconv.FormatUint(uint64(productID), 10)
+
+	pipe := rdb.TxPipeline()
+	for _, term := range terms {
+		pipe.ZRem(ctx, termKey(term), member)
+	}
+	pipe.Del(ctx, productKey(productID))
+	pipe.SRem(ctx, docsKey, member)
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// 已建立索引的商品數量，為0時代表索引尚未建立或已遺失
+func IndexedCount(ctx context.Context, rdb *redis.Client) (int64, error) {
+	return rdb.SCard(ctx, docsKey).Result()
+}
+
+// 已建立索引的所有商品ID
+func IndexedProductIDs(ctx context.Context, rdb *redis.Client) ([]uint, error) {
+	members, err := rdb.SMembers(ctx, docsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs := make([]uint, 0, len(members))
+	for _, member := range members {
+		productID, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		productIDs = append(productIDs, uint(productID))
+	}
+	return productIDs, nil
+}
+
+// 搜尋商品並依相關度由高到低排序
+// 每個詞的權重乘上詞的稀有程度(IDF)後加總，符合越多詞、越稀有的詞的商品排序越前面
+func Search(ctx context.Context, rdb *redis.Client, query string, offset, limit int) (Result, error) {
+	var result Result
+	terms := QueryTerms(query)
+	if len(terms) == 0 || limit <= 0 {
+		return result, nil
+	}
+
+	pipe := rdb.Pipeline()
+	docsCmd := pipe.SCard(ctx, docsKey)
+	termCmds := make([]*redis.IntCmd, len(terms))
+	for i, term := range terms {
+		termCmds[i] = pipe.ZCard(ctx, termKey(term))
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	totalDocs := float64(docsCmd.Val())
+	var keys []string
+	var weights []float64
+	for i, term := range terms {
+		docCount := float64(termCmds[i].Val())
+		if docCount == 0 {
+			continue
+		}
+		keys = append(keys, termKey(term))
+		weights = append(weights, math.Log(1+totalDocs/docCount))
+	}
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	//將各詞的結果合併至暫存的ZSET，讀取後刪除
+	tmpKey := tmpKeyPrefix + uuid.New().String()
+	tx := rdb.TxPipeline()
+	tx.ZUnionStore(ctx, tmpKey, &redis.ZStore{
+		Keys:      keys,
+		Weights:   weights,
+		Aggregate: "SUM",
+	})
+	rangeCmd := tx.ZRevRangeWithScores(ctx, tmpKey, int64(offset), int64(offset+limit-1))
+	totalCmd := tx.ZCard(ctx, tmpKey)
+	tx.Del(ctx, tmpKey)
+	_, err = tx.Exec(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	result.Total = totalCmd.Val()
+	for _, z := range rangeCmd.Val() {
+		productID, err := strconv.ParseUint(z.Member.(string), 10, 64)
+		if err != nil {
+			continue
+		}
+		result.Hits = append(result.Hits, Hit{
+			ProductID: uint(productID),
+			Score:     z.Score,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,117 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 查詢最多使用的詞數，避免過長的查詢造成大量Redis運算
+const maxQueryTerms = 20
+
+// 是否為中日韓文字，這些文字之間沒有空白分隔，需另外切詞
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// 是否為英文、數字等以空白分隔的文字
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !isCJK(r)
+}
+
+// 將文字切為連續的英數字詞及中日韓文字段落，英文一律轉為小寫
+func splitRuns(text string) (words []string, cjkRuns [][]rune) {
+	var word []rune
+	var cjk []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+		if len(cjk) > 0 {
+			cjkRuns = append(cjkRuns, cjk)
+			cjk = nil
+		}
+	}
+
+	for _, r := range text {
+		r = unicode.ToLower(r)
+		switch {
+		case isCJK(r):
+			if len(word) > 0 {
+				flush()
+			}
+			cjk = append(cjk, r)
+		case isWordRune(r):
+			if len(cjk) > 0 {
+				flush()
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return words, cjkRuns
+}
+
+// 將文字切為索引用的詞並計算出現次數
+// 英數字以空白及標點分詞，中日韓文字同時以單字及相鄰兩字(bigram)建立索引，
+// 讓單字及多字的查詢都能找到商品
+func IndexTerms(text string) map[string]int {
+	terms := make(map[string]int)
+	words, cjkRuns := splitRuns(text)
+	for _, word := range words {
+		terms[word]++
+	}
+	for _, run := range cjkRuns {
+		for i := range run {
+			terms[string(run[i])]++
+			if i+1 < len(run) {
+				terms[string(run[i:i+2])]++
+			}
+		}
+	}
+	return terms
+}
+
+// 將查詢文字切為查詢用的詞(不重複)
+// 中日韓文字超過一個字時只使用bigram，避免單字造成過多不相關的結果
+func QueryTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	add := func(term string) {
+		if seen[term] || len(terms) >= maxQueryTerms {
+			return
+		}
+		seen[term] = true
+		terms = append(terms, term)
+	}
+
+	words, cjkRuns := splitRuns(query)
+	for _, word := range words {
+		add(word)
+	}
+	for _, run := range cjkRuns {
+		if len(run) == 1 {
+			add(string(run))
+			continue
+		}
+		for i := 0; i+1 < len(run); i++ {
+			add(string(run[i : i+2]))
+		}
+	}
+	return terms
+}
+
+// 將查詢詞轉為小寫的rune，用於標示符合的文字
+func termRunes(terms []string) [][]rune {
+	result := make([][]rune, 0, len(terms))
+	for _, term := range terms {
+		result = append(result, []rune(strings.ToLower(term)))
+	}
+	return result
+}
@@ -0,0 +1,181 @@
+package search
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestIndexTerms(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want map[string]int
+	}{
+		{
+			name: "英數字以空白及標點分詞並轉為小寫",
+			text: "USB-C Cable, usb",
+			want: map[string]int{"usb": 2, "c": 1, "cable": 1},
+		},
+		{
+			name: "中文建立單字及bigram",
+			text: "藍芽耳機",
+			want: map[string]int{"藍": 1, "芽": 1, "耳": 1, "機": 1, "藍芽": 1, "芽耳": 1, "耳機": 1},
+		},
+		{
+			name: "中英混合時英數字與中文分開",
+			text: "iPhone15手機殼",
+			want: map[string]int{"iphone15": 1, "手": 1, "機": 1, "殼": 1, "手機": 1, "機殼": 1},
+		},
+		{
+			name: "標點切斷中文段落，不跨段落建立bigram",
+			text: "紅茶、綠茶",
+			want: map[string]int{"紅": 1, "茶": 2, "綠": 1, "紅茶": 1, "綠茶": 1},
+		},
+		{
+			name: "日文假名及韓文視為中日韓文字",
+			text: "カメラ 카메라",
+			want: map[string]int{
+				"カ": 1, "メ": 1, "ラ": 1, "カメ": 1, "メラ": 1,
+				"카": 1, "메": 1, "라": 1, "카메": 1, "메라": 1,
+			},
+		},
+		{
+			name: "只有標點及空白",
+			text: " ,。! ",
+			want: map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := IndexTerms(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IndexTerms(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTerms(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "單一中文字直接查詢",
+			query: "茶",
+			want:  []string{"茶"},
+		},
+		{
+			name:  "多個中文字只使用bigram",
+			query: "藍芽耳機",
+			want:  []string{"藍芽", "芽耳", "耳機"},
+		},
+		{
+			name:  "英數字詞在前且不重複",
+			query: "Apple 無線耳機 apple",
+			want:  []string{"apple", "無線", "線耳", "耳機"},
+		},
+		{
+			name:  "空查詢",
+			query: "  ",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := QueryTerms(tt.query)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueryTerms(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTermsLimit(t *testing.T) {
+	query := ""
+	for i := 0; i < maxQueryTerms+10; i++ {
+		query += string(rune('a'+i%26)) + string(rune('a'+i/26)) + " "
+	}
+	if got := len(QueryTerms(query)); got != maxQueryTerms {
+		t.Errorf("len(QueryTerms) = %d, want %d", got, maxQueryTerms)
+	}
+}
+
+// 查詢產生的詞都須出現在商品文字的索引中，商品才能被找到
+func TestQueryTermsMatchIndexTerms(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		query   string
+		matches bool
+	}{
+		{"完整bigram", "無線藍芽耳機", "藍芽耳機", true},
+		{"單字", "無線藍芽耳機", "芽", true},
+		{"中英混合", "Sony無線耳機WH1000", "sony 耳機", true},
+		{"字順不同", "無線藍芽耳機", "芽藍", false},
+		{"跨標點的bigram", "紅茶、綠茶", "茶綠", false},
+		{"英文不做部分比對", "Bluetooth耳機", "blue", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexed := IndexTerms(tt.text)
+			matches := true
+			for _, term := range QueryTerms(tt.query) {
+				if indexed[term] == 0 {
+					matches = false
+				}
+			}
+			if matches != tt.matches {
+				t.Errorf("query %q on %q matches = %v, want %v", tt.query, tt.text, matches, tt.matches)
+			}
+		})
+	}
+}
+
+func TestDocumentScores(t *testing.T) {
+	scores := documentScores(Document{
+		ProductID:   1,
+		Name:        "耳機",
+		Description: "耳機 耳機 cable",
+	})
+
+	tests := []struct {
+		term string
+		want float64
+	}{
+		{"耳機", nameWeight*termFrequencyScore(1) + descriptionWeight*termFrequencyScore(2)},
+		{"cable", descriptionWeight * termFrequencyScore(1)},
+		{"耳", nameWeight*termFrequencyScore(1) + descriptionWeight*termFrequencyScore(2)},
+	}
+	for _, tt := range tests {
+		if got := scores[tt.term]; math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("score[%q] = %v, want %v", tt.term, got, tt.want)
+		}
+	}
+
+	//名稱中的詞比描述中出現同樣次數的詞重要
+	if nameScore, descScore := scores["耳機"]-descriptionWeight*termFrequencyScore(2), scores["cable"]; nameScore <= descScore {
+		t.Errorf("name score %v should be greater than description score %v", nameScore, descScore)
+	}
+}
+
+func TestTermFrequencyScore(t *testing.T) {
+	tests := []struct {
+		count int
+		want  float64
+	}{
+		{0, 0},
+		{1, 1},
+		{3, 1 + math.Log(3)},
+	}
+	for _, tt := range tests {
+		if got := termFrequencyScore(tt.count); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("termFrequencyScore(%d) = %v, want %v", tt.count, got, tt.want)
+		}
+	}
+}